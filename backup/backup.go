@@ -0,0 +1,260 @@
+// Package backup snapshots the state of a Transmission daemon and
+// restores it onto another daemon.
+//
+// A backup consists of the session settings and, for every torrent,
+// its metainfo, location, labels, file selection and priorities,
+// limits, queue position and trackers. Backups are stored as gzipped
+// tar archives containing a manifest.json and the torrent files, under
+// torrents/<hash>.torrent. The data of torrents isn't part of backups.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+)
+
+// Version is the version of the backup format written by this package.
+const Version = 1
+
+const manifestName = "manifest.json"
+
+// Fields are the torrent fields needed to back up torrents.
+var Fields = []string{
+	"id", "hashString", "name", "status", "torrentFile", "magnetLink", "downloadDir", "labels",
+	"wanted", "priorities", "bandwidthPriority", "maxConnectedPeers", "downloadLimit",
+	"downloadLimited", "uploadLimit", "uploadLimited", "honorsSessionLimits", "seedIdleLimit",
+	"seedIdleMode", "seedRatioLimit", "seedRatioMode", "queuePosition", "trackers",
+}
+
+type Backup struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Session are the session settings, in the daemon's naming.
+	Session  json.RawMessage `json:"session"`
+	Torrents []Torrent       `json:"torrents"`
+}
+
+// A Torrent is the backup of a single torrent.
+type Torrent struct {
+	Hash string `json:"hash"`
+	Name string `json:"name"`
+	// Metainfo is the content of the torrent file. It is stored in the
+	// archive separately from the manifest. If the torrent file
+	// couldn't be read, Metainfo is nil and the torrent is restored
+	// from its magnet link.
+	Metainfo            []byte                  `json:"-"`
+	Magnet              string                  `json:"magnet"`
+	DownloadDir         string                  `json:"download_dir"`
+	Labels              []string                `json:"labels"`
+	Paused              bool                    `json:"paused"`
+	Wanted              []bool                  `json:"wanted"`
+	Priorities          []transmission.Priority `json:"priorities"`
+	BandwidthPriority   transmission.Priority   `json:"bandwidth_priority"`
+	PeerLimit           int                     `json:"peer_limit"`
+	DownloadLimit       int                     `json:"download_limit"`
+	DownloadLimited     bool                    `json:"download_limited"`
+	UploadLimit         int                     `json:"upload_limit"`
+	UploadLimited       bool                    `json:"upload_limited"`
+	HonorsSessionLimits bool                    `json:"honors_session_limits"`
+	SeedIdleLimit       int                     `json:"seed_idle_limit"`
+	SeedIdleMode        int                     `json:"seed_idle_mode"`
+	SeedRatioLimit      float64                 `json:"seed_ratio_limit"`
+	SeedRatioMode       int                     `json:"seed_ratio_mode"`
+	QueuePosition       int                     `json:"queue_position"`
+	Trackers            []string                `json:"trackers"`
+}
+
+type CreateOptions struct {
+	// ReadFile reads torrent files from the paths reported by the
+	// daemon. If it is nil, ioutil.ReadFile is used, which only finds
+	// the files if the daemon runs on the local host, or its
+	// configuration directory is mounted at the same path.
+	ReadFile func(path string) ([]byte, error)
+	// Magnet backs up torrents whose files can't be read by their
+	// magnet links, instead of failing. Torrents restored from magnet
+	// links need to fetch their metadata from peers first.
+	Magnet bool
+}
+
+// Create takes a backup of a daemon. It fails if a torrent file can't
+// be read, unless opts.Magnet is set.
+func Create(cl *transmission.Client, opts CreateOptions) (*Backup, error) {
+	readFile := opts.ReadFile
+	if readFile == nil {
+		readFile = ioutil.ReadFile
+	}
+	session, err := cl.SessionInfo(nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get session settings: %s", err)
+	}
+	b := &Backup{Version: Version, Created: time.Now()}
+	b.Session, err = json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := cl.TorrentInfo(nil, Fields)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get torrents: %s", err)
+	}
+	for i := range infos {
+		info := &infos[i]
+		t := Torrent{
+			Hash:                strings.ToLower(info.Hash),
+			Name:                info.Name,
+			Magnet:              info.MagnetLink,
+			DownloadDir:         info.DownloadDir,
+			Labels:              info.Labels,
+			Paused:              info.Status == transmission.TorrentStatusStopped,
+			Wanted:              info.Wanted,
+			Priorities:          info.Priorities,
+			BandwidthPriority:   info.BandwidthPriority,
+			PeerLimit:           info.MaxConnectedPeers,
+			DownloadLimit:       info.DownloadLimit,
+			DownloadLimited:     info.DownloadLimited,
+			UploadLimit:         info.UploadLimit,
+			UploadLimited:       info.UploadLimited,
+			HonorsSessionLimits: info.HonorsSessionLimits,
+			SeedIdleLimit:       info.SeedIdleLimit,
+			SeedIdleMode:        info.SeedIdleMode,
+			SeedRatioLimit:      info.SeedRatioLimit,
+			SeedRatioMode:       info.SeedRatioMode,
+			QueuePosition:       info.QueuePosition,
+		}
+		for _, tr := range info.Trackers {
+			t.Trackers = append(t.Trackers, tr.Announce)
+		}
+		var readErr error
+		if info.TorrentFile != "" {
+			t.Metainfo, readErr = readFile(info.TorrentFile)
+		} else {
+			readErr = errors.New("daemon didn't report a torrent file")
+		}
+		if readErr != nil {
+			t.Metainfo = nil
+			if !opts.Magnet {
+				return nil, fmt.Errorf("%s: couldn't read torrent file: %s", info.Name, readErr)
+			}
+			if t.Magnet == "" {
+				return nil, fmt.Errorf("%s: couldn't read torrent file and there is no magnet link: %s", info.Name, readErr)
+			}
+		}
+		b.Torrents = append(b.Torrents, t)
+	}
+	return b, nil
+}
+
+func torrentPath(hash string) string {
+	return "torrents/" + hash + ".torrent"
+}
+
+// Write writes the backup as a gzipped tar archive.
+func (b *Backup) Write(w io.Writer) error {
+	manifest, err := json.MarshalIndent(b, "", "\t")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: b.Created,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	// The manifest comes first, so that readers can check the version
+	// before anything else.
+	if err := add(manifestName, manifest); err != nil {
+		return err
+	}
+	for _, t := range b.Torrents {
+		if t.Metainfo == nil {
+			continue
+		}
+		if err := add(torrentPath(t.Hash), t.Metainfo); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Read reads a backup written by Write.
+func Read(r io.Reader) (*Backup, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read manifest: %s", err)
+	}
+	if hdr.Name != manifestName {
+		return nil, errors.New("not a backup: missing manifest")
+	}
+	manifest, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return nil, err
+	}
+	var b Backup
+	if err := json.Unmarshal(manifest, &b); err != nil {
+		return nil, fmt.Errorf("couldn't parse manifest: %s", err)
+	}
+	if b.Version != Version {
+		return nil, fmt.Errorf("unsupported backup version %d", b.Version)
+	}
+
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = data
+	}
+	for i := range b.Torrents {
+		t := &b.Torrents[i]
+		t.Metainfo = files[torrentPath(t.Hash)]
+		if t.Metainfo == nil && t.Magnet == "" {
+			return nil, fmt.Errorf("%s: missing torrent file", t.Name)
+		}
+	}
+	return &b, nil
+}
+
+// SessionSettings returns the backed up session settings that can be
+// changed. Read-only settings, such as the daemon's version, are
+// dropped.
+func (b *Backup) SessionSettings() (*transmission.SessionSettings, error) {
+	var s transmission.SessionSettings
+	if err := json.NewDecoder(bytes.NewReader(b.Session)).Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid session settings: %s", err)
+	}
+	return &s, nil
+}
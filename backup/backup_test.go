@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"honnef.co/go/transmission"
+)
+
+func TestWriteRead(t *testing.T) {
+	b := &Backup{
+		Version: Version,
+		Created: time.Unix(1600000000, 0).UTC(),
+		Session: json.RawMessage(`{"download-dir":"/srv","speed-limit-down":100}`),
+		Torrents: []Torrent{
+			{
+				Hash:          "aabbccddeeff00112233445566778899aabbccdd",
+				Name:          "debian-12.iso",
+				Metainfo:      []byte("d4:infod4:name13:debian-12.isoee"),
+				Magnet:        "magnet:?xt=urn:btih:aabbccddeeff00112233445566778899aabbccdd",
+				DownloadDir:   "/srv/iso",
+				Labels:        []string{"linux", "iso"},
+				Wanted:        []bool{true, false},
+				Priorities:    []transmission.Priority{transmission.PriorityHigh, transmission.PriorityNormal},
+				QueuePosition: 1,
+				Trackers:      []string{"http://bttracker.debian.org:6969/announce"},
+			},
+			{
+				// Backed up by its magnet link only.
+				Hash:        "1111111111111111111111111111111111111111",
+				Name:        "magnet only",
+				Magnet:      "magnet:?xt=urn:btih:1111111111111111111111111111111111111111",
+				DownloadDir: "/srv/movies",
+				Paused:      true,
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the layout of the archive.
+	gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	wantNames := []string{"manifest.json", "torrents/aabbccddeeff00112233445566778899aabbccdd.torrent"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("archive contains %v, want %v", names, wantNames)
+	}
+
+	got, err := Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The manifest is indented, which changes the raw session settings.
+	var gotSession, wantSession bytes.Buffer
+	if err := json.Compact(&gotSession, got.Session); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Compact(&wantSession, b.Session); err != nil {
+		t.Fatal(err)
+	}
+	if gotSession.String() != wantSession.String() {
+		t.Errorf("got session %s, want %s", gotSession.String(), wantSession.String())
+	}
+	if !got.Created.Equal(b.Created) {
+		t.Errorf("got creation time %s, want %s", got.Created, b.Created)
+	}
+	got.Session, got.Created = b.Session, b.Created
+	if !reflect.DeepEqual(got, b) {
+		t.Errorf("got %+v, want %+v", got, b)
+	}
+}
+
+func TestReadMissingTorrentFile(t *testing.T) {
+	// Without metainfo, no torrent file is written, and there is no
+	// magnet link to fall back to.
+	b := &Backup{
+		Version:  Version,
+		Torrents: []Torrent{{Hash: "aabbccddeeff00112233445566778899aabbccdd", Name: "x"}},
+	}
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(&buf); err == nil {
+		t.Error("Read accepted a backup with a missing torrent file")
+	}
+}
+
+func TestDifferences(t *testing.T) {
+	info := &transmission.TorrentInfo{
+		DownloadDir: "/srv/iso",
+		Labels:      []string{"linux", "iso"},
+		Trackers:    []transmission.Tracker{{Announce: "http://a/announce"}, {Announce: "http://b/announce"}},
+	}
+	tests := []struct {
+		t    Torrent
+		dir  string
+		want []string
+	}{
+		{
+			Torrent{Labels: []string{"iso", "linux"}, Trackers: []string{"http://b/announce"}},
+			"/srv/iso",
+			nil,
+		},
+		{
+			Torrent{Labels: []string{"linux", "iso"}},
+			"/mnt/iso",
+			[]string{"location is /srv/iso, backup has /mnt/iso"},
+		},
+		{
+			Torrent{Labels: []string{"linux"}},
+			"/srv/iso",
+			[]string{"labels are [iso, linux], backup has [linux]"},
+		},
+		{
+			Torrent{Labels: []string{"linux", "iso"}, Trackers: []string{"http://a/announce", "http://c/announce", "http://d/announce"}},
+			"/srv/iso",
+			[]string{"missing tracker http://c/announce", "missing tracker http://d/announce"},
+		},
+		{
+			Torrent{Trackers: []string{"http://c/announce"}},
+			"/",
+			[]string{
+				"location is /srv/iso, backup has /",
+				"labels are [iso, linux], backup has []",
+				"missing tracker http://c/announce",
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := differences(&tt.t, tt.dir, info); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("differences(%+v, %q) = %q, want %q", tt.t, tt.dir, got, tt.want)
+		}
+	}
+	// differences must not reorder the torrent's labels.
+	if info.Labels[0] != "linux" {
+		t.Errorf("differences sorted the labels of the existing torrent: %v", info.Labels)
+	}
+}
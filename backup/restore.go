@@ -0,0 +1,237 @@
+package backup
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"honnef.co/go/transmission"
+)
+
+type RestoreOptions struct {
+	// SkipSession doesn't restore the session settings.
+	SkipSession bool
+	// Overwrite applies the backed up settings to torrents that
+	// already exist on the daemon. By default, they are left alone.
+	Overwrite bool
+	// MapDir, if not nil, maps backed up download directories to new
+	// ones.
+	MapDir func(dir string) string
+}
+
+// A Conflict is a backed up torrent that already exists on the daemon.
+type Conflict struct {
+	Hash string
+	Name string
+	// Differences describe how the existing torrent differs from the
+	// backup.
+	Differences []string
+	// Overwritten is true if the existing torrent's settings were
+	// replaced with the backed up ones.
+	Overwritten bool
+}
+
+// A Failure is a torrent that couldn't be restored.
+type Failure struct {
+	Hash string
+	Name string
+	Err  error
+}
+
+// A Report describes the outcome of a restore.
+type Report struct {
+	SessionRestored bool
+	// Added are the hashes of torrents that have been added.
+	Added     []string
+	Conflicts []Conflict
+	Failures  []Failure
+}
+
+// Restore replays a backup onto a daemon. It restores the session
+// settings, adds missing torrents with their settings and queue
+// positions and starts the torrents that weren't paused. Problems with
+// individual torrents are recorded in the report; the returned error
+// is reserved for failures that affect the restore as a whole.
+//
+// Torrents that were backed up by their magnet links have no metadata
+// until the daemon fetches it from peers, and their file selection and
+// priorities aren't restored.
+func Restore(cl *transmission.Client, b *Backup, opts RestoreOptions) (*Report, error) {
+	report := &Report{}
+	if !opts.SkipSession && len(b.Session) > 0 {
+		s, err := b.SessionSettings()
+		if err != nil {
+			return nil, err
+		}
+		if err := cl.SetSession(s); err != nil {
+			return nil, fmt.Errorf("couldn't restore session settings: %s", err)
+		}
+		report.SessionRestored = true
+	}
+
+	existing := map[string]*transmission.TorrentInfo{}
+	infos, err := cl.TorrentInfo(nil, []string{"id", "hashString", "downloadDir", "labels", "trackers"})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get torrents: %s", err)
+	}
+	for i := range infos {
+		existing[strings.ToLower(infos[i].Hash)] = &infos[i]
+	}
+
+	var restored []*Torrent
+	for i := range b.Torrents {
+		t := &b.Torrents[i]
+		dir := t.DownloadDir
+		if opts.MapDir != nil {
+			dir = opts.MapDir(dir)
+		}
+		if info, ok := existing[t.Hash]; ok {
+			c := Conflict{Hash: t.Hash, Name: t.Name, Differences: differences(t, dir, info)}
+			if opts.Overwrite {
+				if err := restoreSettings(cl, t, t.Hash); err != nil {
+					report.Failures = append(report.Failures, Failure{t.Hash, t.Name, err})
+					continue
+				}
+				c.Overwritten = true
+				restored = append(restored, t)
+			}
+			report.Conflicts = append(report.Conflicts, c)
+			continue
+		}
+
+		req := &transmission.NewTorrent{
+			DownloadDir:       dir,
+			Paused:            true,
+			BandwidthPriority: t.BandwidthPriority,
+			PeerLimit:         t.PeerLimit,
+		}
+		if t.Metainfo != nil {
+			req.Metainfo = base64.StdEncoding.EncodeToString(t.Metainfo)
+			for i, w := range t.Wanted {
+				if !w {
+					req.FilesUnwanted = append(req.FilesUnwanted, i)
+				}
+			}
+			for i, p := range t.Priorities {
+				switch p {
+				case transmission.PriorityHigh:
+					req.PriorityHigh = append(req.PriorityHigh, i)
+				case transmission.PriorityLow:
+					req.PriorityLow = append(req.PriorityLow, i)
+				}
+			}
+		} else {
+			req.Filename = t.Magnet
+		}
+		added, dup, err := cl.AddTorrent(req)
+		if err != nil {
+			report.Failures = append(report.Failures, Failure{t.Hash, t.Name, fmt.Errorf("couldn't add torrent: %s", err)})
+			continue
+		}
+		if dup {
+			// Added since we listed the torrents.
+			report.Conflicts = append(report.Conflicts, Conflict{Hash: t.Hash, Name: t.Name})
+			continue
+		}
+		report.Added = append(report.Added, t.Hash)
+		if err := restoreSettings(cl, t, added.Hash); err != nil {
+			report.Failures = append(report.Failures, Failure{t.Hash, t.Name, err})
+			continue
+		}
+		restored = append(restored, t)
+	}
+
+	// Setting positions in ascending order reproduces the backed up
+	// order, regardless of the torrents that were already queued.
+	sort.SliceStable(restored, func(i, j int) bool {
+		return restored[i].QueuePosition < restored[j].QueuePosition
+	})
+	var start []string
+	for _, t := range restored {
+		pos := t.QueuePosition
+		if err := cl.SetTorrent([]string{t.Hash}, &transmission.TorrentSettings{QueuePosition: &pos}); err != nil {
+			report.Failures = append(report.Failures, Failure{t.Hash, t.Name, fmt.Errorf("couldn't set queue position: %s", err)})
+		}
+		if !t.Paused {
+			start = append(start, t.Hash)
+		}
+	}
+	if len(start) > 0 {
+		if err := cl.StartTorrent(start); err != nil {
+			return report, fmt.Errorf("couldn't start torrents: %s", err)
+		}
+	}
+	return report, nil
+}
+
+// restoreSettings applies the labels, limits and trackers of a backed
+// up torrent.
+func restoreSettings(cl *transmission.Client, t *Torrent, hash string) error {
+	labels := t.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+	settings := &transmission.TorrentSettings{
+		Labels:              labels,
+		BandwidthPriority:   &t.BandwidthPriority,
+		PeerLimit:           &t.PeerLimit,
+		DownloadLimit:       &t.DownloadLimit,
+		DownloadLimited:     &t.DownloadLimited,
+		UploadLimit:         &t.UploadLimit,
+		UploadLimited:       &t.UploadLimited,
+		HonorsSessionLimits: &t.HonorsSessionLimits,
+		SeedIdleLimit:       &t.SeedIdleLimit,
+		SeedIdleMode:        &t.SeedIdleMode,
+		SeedRatioLimit:      &t.SeedRatioLimit,
+		SeedRatioMode:       &t.SeedRatioMode,
+	}
+	infos, err := cl.TorrentInfo([]string{hash}, []string{"id", "hashString", "trackers"})
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, info := range infos {
+		if strings.EqualFold(info.Hash, hash) {
+			for _, tr := range info.Trackers {
+				have[tr.Announce] = true
+			}
+		}
+	}
+	for _, announce := range t.Trackers {
+		if !have[announce] {
+			have[announce] = true
+			settings.TrackerAdd = append(settings.TrackerAdd, announce)
+		}
+	}
+	if err := cl.SetTorrent([]string{hash}, settings); err != nil {
+		return fmt.Errorf("couldn't restore settings: %s", err)
+	}
+	return nil
+}
+
+// differences describes how an existing torrent differs from its
+// backup.
+func differences(t *Torrent, dir string, info *transmission.TorrentInfo) []string {
+	var out []string
+	if info.DownloadDir != dir {
+		out = append(out, fmt.Sprintf("location is %s, backup has %s", info.DownloadDir, dir))
+	}
+	a := append([]string(nil), info.Labels...)
+	b := append([]string(nil), t.Labels...)
+	sort.Strings(a)
+	sort.Strings(b)
+	if strings.Join(a, ",") != strings.Join(b, ",") {
+		out = append(out, fmt.Sprintf("labels are [%s], backup has [%s]", strings.Join(a, ", "), strings.Join(b, ", ")))
+	}
+	have := map[string]bool{}
+	for _, tr := range info.Trackers {
+		have[tr.Announce] = true
+	}
+	for _, announce := range t.Trackers {
+		if !have[announce] {
+			out = append(out, "missing tracker "+announce)
+		}
+	}
+	return out
+}
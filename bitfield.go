@@ -0,0 +1,41 @@
+package transmission
+
+// Bitfield is a set of piece indices, as used by Transmission and the
+// BitTorrent wire protocol. The most significant bit of the first
+// byte corresponds to piece 0.
+type Bitfield []byte
+
+// NewBitfield returns an empty bitfield that can hold n pieces.
+func NewBitfield(n int) Bitfield {
+	return make(Bitfield, (n+7)/8)
+}
+
+// Has reports whether piece i is set. Indices outside the bitfield
+// are never set.
+func (b Bitfield) Has(i int) bool {
+	if i < 0 || i/8 >= len(b) {
+		return false
+	}
+	return b[i/8]&(0x80>>uint(i%8)) != 0
+}
+
+// Set marks piece i as present.
+func (b Bitfield) Set(i int) {
+	b[i/8] |= 0x80 >> uint(i%8)
+}
+
+// Clear marks piece i as missing.
+func (b Bitfield) Clear(i int) {
+	b[i/8] &^= 0x80 >> uint(i%8)
+}
+
+// Count returns the number of set pieces.
+func (b Bitfield) Count() int {
+	n := 0
+	for _, c := range b {
+		for ; c != 0; c &= c - 1 {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,198 @@
+package transmission
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTorrentNotFound is the result of a bulk operation for torrents
+// that don't exist.
+var ErrTorrentNotFound = errors.New("torrent not found")
+
+// BulkResult maps the IDs passed to a bulk operation to the outcome
+// for each torrent. A nil error means that the operation was verified
+// to have succeeded.
+type BulkResult map[string]error
+
+// Failed returns the IDs of torrents for which the operation failed,
+// sorted.
+func (r BulkResult) Failed() []string {
+	var out []string
+	for id, err := range r {
+		if err != nil {
+			out = append(out, id)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Err returns an error describing all failures, or nil.
+func (r BulkResult) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(failed))
+	for i, id := range failed {
+		msgs[i] = fmt.Sprintf("%s: %s", id, r[id])
+	}
+	return fmt.Errorf("%d of %d torrents failed: %s", len(failed), len(r), strings.Join(msgs, "; "))
+}
+
+// Bulk performs operations on large numbers of torrents. IDs are split
+// into chunks that are sent with bounded concurrency, and the outcome
+// of every operation is verified by reading the torrents back, so that
+// failures can be attributed to individual torrents.
+type Bulk struct {
+	Client *Client
+	// ChunkSize is the maximum number of torrents per request. It
+	// defaults to 100.
+	ChunkSize int
+	// Concurrency is the maximum number of concurrent requests. It
+	// defaults to 4.
+	Concurrency int
+	// Settle is how long to wait for operations that the daemon
+	// completes in the background, such as moving data, before
+	// considering them failed. By default, outcomes are checked once,
+	// right after the operation.
+	Settle time.Duration
+}
+
+func (b *Bulk) chunks(ids []string) [][]string {
+	size := b.ChunkSize
+	if size <= 0 {
+		size = 100
+	}
+	var out [][]string
+	for len(ids) > size {
+		out = append(out, ids[:size:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		out = append(out, ids)
+	}
+	return out
+}
+
+// parallel calls fn for every chunk, with bounded concurrency.
+func (b *Bulk) parallel(chunks [][]string, fn func(ids []string)) {
+	n := b.Concurrency
+	if n <= 0 {
+		n = 4
+	}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for _, chunk := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(chunk []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(chunk)
+		}(chunk)
+	}
+	wg.Wait()
+}
+
+// run performs an operation and verifies its outcome. check is called
+// for torrents that still exist and returns why the operation hasn't
+// taken effect. gone is the outcome for torrents that don't exist.
+func (b *Bulk) run(ids []string, op func(ids []string) error, fields []string, check func(info *TorrentInfo) error, gone error) BulkResult {
+	var mu sync.Mutex
+	res := BulkResult{}
+	chunks := b.chunks(ids)
+	b.parallel(chunks, func(chunk []string) {
+		err := op(chunk)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, id := range chunk {
+			res[id] = err
+		}
+	})
+
+	// Only verify the torrents whose requests succeeded.
+	var pending []string
+	for _, id := range ids {
+		if res[id] == nil {
+			pending = append(pending, id)
+		}
+	}
+	fields = append([]string{"id", "hashString"}, fields...)
+	deadline := time.Now().Add(b.Settle)
+	for {
+		var next []string
+		b.parallel(b.chunks(pending), func(chunk []string) {
+			infos, err := b.Client.TorrentInfo(chunk, fields)
+			byID := lookup(chunk, infos)
+			mu.Lock()
+			defer mu.Unlock()
+			for _, id := range chunk {
+				switch info := byID[id]; {
+				case err != nil:
+					res[id] = fmt.Errorf("couldn't verify: %s", err)
+				case info == nil:
+					res[id] = gone
+				default:
+					res[id] = check(info)
+				}
+				if res[id] != nil {
+					next = append(next, id)
+				}
+			}
+		})
+		if len(next) == 0 || !time.Now().Before(deadline) {
+			return res
+		}
+		pending = next
+		time.Sleep(time.Second)
+	}
+}
+
+// Remove removes torrents. Torrents that don't exist count as removed.
+func (b *Bulk) Remove(ids []string, deleteLocalData bool) BulkResult {
+	return b.run(ids, func(ids []string) error {
+		return b.Client.RemoveTorrent(ids, deleteLocalData)
+	}, nil, func(*TorrentInfo) error {
+		return errors.New("torrent still exists")
+	}, nil)
+}
+
+// Stop stops torrents.
+func (b *Bulk) Stop(ids []string) BulkResult {
+	return b.run(ids, b.Client.StopTorrent, []string{"status"}, func(info *TorrentInfo) error {
+		if info.Status != TorrentStatusStopped {
+			return fmt.Errorf("torrent is still %s", info.Status)
+		}
+		return nil
+	}, ErrTorrentNotFound)
+}
+
+// Start starts torrents. Torrents that are queued count as started.
+func (b *Bulk) Start(ids []string) BulkResult {
+	return b.run(ids, b.Client.StartTorrent, []string{"status"}, func(info *TorrentInfo) error {
+		if info.Status == TorrentStatusStopped {
+			return errors.New("torrent is still stopped")
+		}
+		return nil
+	}, ErrTorrentNotFound)
+}
+
+// Move changes the location of torrents, moving their data if move is
+// true. Moving data happens in the background; set Settle to wait for
+// it.
+func (b *Bulk) Move(ids []string, location string, move bool) BulkResult {
+	return b.run(ids, func(ids []string) error {
+		return b.Client.MoveTorrent(ids, location, move)
+	}, []string{"downloadDir"}, func(info *TorrentInfo) error {
+		if path.Clean(info.DownloadDir) != path.Clean(location) {
+			return fmt.Errorf("torrent is still in %s", info.DownloadDir)
+		}
+		return nil
+	}, ErrTorrentNotFound)
+}
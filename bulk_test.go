@@ -0,0 +1,193 @@
+package transmission
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDaemon is a minimal RPC server that knows about torrents with
+// the IDs 1 to n and records the IDs of torrent-stop requests.
+type fakeDaemon struct {
+	mu sync.Mutex
+	// stopped torrents, by ID.
+	stopped map[int]bool
+	// ignore lists IDs that torrent-stop leaves running.
+	ignore map[int]bool
+	// fail makes torrent-stop fail for requests containing the ID.
+	fail    int
+	n       int
+	batches [][]string
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const token = "token"
+	if r.Header.Get(csrfHeader) != token {
+		w.Header().Set(csrfHeader, token)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	var req struct {
+		Method    string
+		Arguments struct {
+			IDs []string
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	reply := func(result string, args interface{}) {
+		b, _ := json.Marshal(args)
+		raw := json.RawMessage(b)
+		json.NewEncoder(w).Encode(Response{Result: result, Arguments: &raw})
+	}
+	switch req.Method {
+	case MethodTorrentStop:
+		d.batches = append(d.batches, req.Arguments.IDs)
+		for _, id := range req.Arguments.IDs {
+			if n, _ := strconv.Atoi(id); n == d.fail {
+				reply("torrent is on fire", struct{}{})
+				return
+			}
+		}
+		for _, id := range req.Arguments.IDs {
+			n, _ := strconv.Atoi(id)
+			if !d.ignore[n] {
+				d.stopped[n] = true
+			}
+		}
+		reply("success", struct{}{})
+	case MethodTorrentGet:
+		var torrents []map[string]interface{}
+		for _, id := range req.Arguments.IDs {
+			n, _ := strconv.Atoi(id)
+			if n < 1 || n > d.n {
+				continue
+			}
+			status := TorrentStatusSeed
+			if d.stopped[n] {
+				status = TorrentStatusStopped
+			}
+			torrents = append(torrents, map[string]interface{}{
+				"id":         n,
+				"hashString": strconv.Itoa(n),
+				"status":     status,
+			})
+		}
+		reply("success", map[string]interface{}{"torrents": torrents})
+	default:
+		reply("unsupported method", struct{}{})
+	}
+}
+
+func TestBulkStop(t *testing.T) {
+	tests := []struct {
+		name   string
+		ids    []string
+		ignore []int
+		fail   int
+		// want maps IDs to a part of the expected error message, or to
+		// the empty string for success.
+		want    map[string]string
+		batches int
+	}{
+		{
+			name:    "all succeed",
+			ids:     []string{"1", "2", "3", "4", "5"},
+			want:    map[string]string{"1": "", "2": "", "3": "", "4": "", "5": ""},
+			batches: 3,
+		},
+		{
+			name:    "missing torrent",
+			ids:     []string{"1", "9"},
+			want:    map[string]string{"1": "", "9": ErrTorrentNotFound.Error()},
+			batches: 1,
+		},
+		{
+			name:    "not stopped",
+			ids:     []string{"1", "2"},
+			ignore:  []int{2},
+			want:    map[string]string{"1": "", "2": "torrent is still seeding"},
+			batches: 1,
+		},
+		{
+			name: "failed chunk",
+			ids:  []string{"1", "2", "3", "4"},
+			fail: 3,
+			want: map[string]string{
+				"1": "",
+				"2": "",
+				"3": "torrent is on fire",
+				"4": "torrent is on fire",
+			},
+			batches: 2,
+		},
+	}
+	for _, tt := range tests {
+		d := &fakeDaemon{n: 5, stopped: map[int]bool{}, ignore: map[int]bool{}, fail: tt.fail}
+		for _, id := range tt.ignore {
+			d.ignore[id] = true
+		}
+		srv := httptest.NewServer(d)
+		b := &Bulk{Client: NewClient(srv.URL, nil), ChunkSize: 2, Concurrency: 3}
+		res := b.Stop(tt.ids)
+		srv.Close()
+
+		if len(res) != len(tt.want) {
+			t.Errorf("%s: got %d results, want %d", tt.name, len(res), len(tt.want))
+		}
+		for id, want := range tt.want {
+			err, ok := res[id]
+			switch {
+			case !ok:
+				t.Errorf("%s: no result for %s", tt.name, id)
+			case want == "" && err != nil:
+				t.Errorf("%s: %s failed: %s", tt.name, id, err)
+			case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+				t.Errorf("%s: %s: got error %v, want %q", tt.name, id, err, want)
+			}
+		}
+		if len(d.batches) != tt.batches {
+			t.Errorf("%s: sent %d batches, want %d", tt.name, len(d.batches), tt.batches)
+		}
+		for _, batch := range d.batches {
+			if len(batch) > 2 {
+				t.Errorf("%s: batch %v exceeds chunk size", tt.name, batch)
+			}
+		}
+	}
+}
+
+func TestBulkResult(t *testing.T) {
+	r := BulkResult{"b": errors.New("boom"), "a": nil, "c": ErrTorrentNotFound}
+	if got, want := r.Failed(), []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Failed() = %v, want %v", got, want)
+	}
+	if got, want := r.Err().Error(), "2 of 3 torrents failed: b: boom; c: torrent not found"; got != want {
+		t.Errorf("Err() = %q, want %q", got, want)
+	}
+	if err := (BulkResult{"a": nil}).Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+}
+
+func TestBulkChunks(t *testing.T) {
+	b := &Bulk{ChunkSize: 2}
+	got := b.chunks([]string{"1", "2", "3", "4", "5"})
+	want := [][]string{{"1", "2"}, {"3", "4"}, {"5"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks = %v, want %v", got, want)
+	}
+	if got := b.chunks(nil); got != nil {
+		t.Errorf("chunks(nil) = %v, want nil", got)
+	}
+}
@@ -0,0 +1,443 @@
+package transmission
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Node is a daemon that is part of a Cluster.
+type Node struct {
+	Name   string
+	Client *Client
+}
+
+// Placement strategies for new torrents.
+const (
+	// PlaceMostFreeSpace adds torrents to the node with the most free
+	// space in the torrent's download directory.
+	PlaceMostFreeSpace = "most-free-space"
+	// PlaceFewestActive adds torrents to the node with the fewest
+	// active torrents.
+	PlaceFewestActive = "fewest-active"
+)
+
+// A Cluster spreads torrents across several daemons. It provides a
+// unified view of their torrents, routes actions to the nodes that
+// own the torrents, and places new torrents on the most suitable node.
+//
+// Torrents are identified by their info hashes. Numeric IDs are only
+// unique per node.
+type Cluster struct {
+	Nodes []Node
+	// Placement is one of the Place constants, defaulting to
+	// PlaceMostFreeSpace.
+	Placement string
+
+	mu sync.Mutex
+	// owners maps info hashes to the indices of the nodes that own
+	// them.
+	owners map[string]int
+}
+
+func NewCluster(nodes ...Node) *Cluster {
+	return &Cluster{
+		Nodes:  nodes,
+		owners: map[string]int{},
+	}
+}
+
+// A NodeTorrent is a torrent and the node it belongs to.
+type NodeTorrent struct {
+	Node string
+	TorrentInfo
+}
+
+// NodeErrors are errors of individual nodes, by node name.
+type NodeErrors map[string]error
+
+func (errs NodeErrors) Error() string {
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %s", name, errs[name])
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// each calls fn for every node concurrently and collects the errors.
+func (c *Cluster) each(fn func(i int, n *Node) error) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = NodeErrors{}
+	)
+	for i := range c.Nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := fn(i, &c.Nodes[i]); err != nil {
+				mu.Lock()
+				errs[c.Nodes[i].Name] = err
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c *Cluster) setOwner(hash string, node int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.owners == nil {
+		c.owners = map[string]int{}
+	}
+	c.owners[strings.ToLower(hash)] = node
+}
+
+// TorrentInfo returns information about torrents on all nodes. If ids
+// is empty, all torrents are returned. If some nodes fail, the
+// torrents of the other nodes are returned along with a NodeErrors.
+func (c *Cluster) TorrentInfo(ids []string, fields []string) ([]NodeTorrent, error) {
+	if !hasField(fields, "hashString") {
+		fields = append(fields[:len(fields):len(fields)], "hashString")
+	}
+	var (
+		mu  sync.Mutex
+		out []NodeTorrent
+	)
+	err := c.each(func(i int, n *Node) error {
+		infos, err := n.Client.TorrentInfo(ids, fields)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, info := range infos {
+			c.setOwner(info.Hash, i)
+			out = append(out, NodeTorrent{n.Name, info})
+		}
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Node != out[j].Node {
+			return out[i].Node < out[j].Node
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, err
+}
+
+// Owner returns the node that owns the torrent with the given info
+// hash.
+func (c *Cluster) Owner(hash string) (*Node, error) {
+	owners, err := c.lookupOwners([]string{hash})
+	if err != nil {
+		return nil, err
+	}
+	for i := range owners {
+		return &c.Nodes[i], nil
+	}
+	panic("unreachable")
+}
+
+// lookupOwners maps the indices of nodes to the hashes they own.
+// Hashes that aren't known yet are looked up on all nodes. Known
+// owners are verified first, because torrents may have been removed or
+// moved without going through the cluster. It fails if any hash can't
+// be found.
+func (c *Cluster) lookupOwners(hashes []string) (map[int][]string, error) {
+	cached := map[int][]string{}
+	var unknown []string
+	c.mu.Lock()
+	for _, h := range hashes {
+		if i, ok := c.owners[strings.ToLower(h)]; ok {
+			cached[i] = append(cached[i], h)
+		} else {
+			unknown = append(unknown, h)
+		}
+	}
+	c.mu.Unlock()
+
+	out := map[int][]string{}
+	for i, hs := range cached {
+		infos, err := c.Nodes[i].Client.TorrentInfo(hs, []string{"hashString"})
+		if err != nil {
+			// Let the action fail on the unreachable node.
+			out[i] = hs
+			continue
+		}
+		have := map[string]bool{}
+		for _, info := range infos {
+			have[strings.ToLower(info.Hash)] = true
+		}
+		c.mu.Lock()
+		for _, h := range hs {
+			if have[strings.ToLower(h)] {
+				out[i] = append(out[i], h)
+			} else {
+				delete(c.owners, strings.ToLower(h))
+				unknown = append(unknown, h)
+			}
+		}
+		c.mu.Unlock()
+	}
+	if len(unknown) == 0 {
+		return out, nil
+	}
+
+	found, err := c.TorrentInfo(unknown, []string{"id", "hashString"})
+	if err != nil && len(found) == 0 {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, h := range unknown {
+		i, ok := c.owners[strings.ToLower(h)]
+		if !ok {
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("no node has torrent %s", h)
+		}
+		out[i] = append(out[i], h)
+	}
+	return out, nil
+}
+
+// route calls fn for every node that owns some of the torrents, with
+// the hashes of those torrents.
+func (c *Cluster) route(hashes []string, fn func(cl *Client, hashes []string) error) error {
+	owners, err := c.lookupOwners(hashes)
+	if err != nil {
+		return err
+	}
+	errs := NodeErrors{}
+	for i, hs := range owners {
+		if err := fn(c.Nodes[i].Client, hs); err != nil {
+			errs[c.Nodes[i].Name] = err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c *Cluster) StartTorrent(hashes []string) error {
+	return c.route(hashes, (*Client).StartTorrent)
+}
+
+func (c *Cluster) StartTorrentNow(hashes []string) error {
+	return c.route(hashes, (*Client).StartTorrentNow)
+}
+
+func (c *Cluster) StopTorrent(hashes []string) error {
+	return c.route(hashes, (*Client).StopTorrent)
+}
+
+func (c *Cluster) VerifyTorrent(hashes []string) error {
+	return c.route(hashes, (*Client).VerifyTorrent)
+}
+
+func (c *Cluster) ReannounceTorrent(hashes []string) error {
+	return c.route(hashes, (*Client).ReannounceTorrent)
+}
+
+func (c *Cluster) RemoveTorrent(hashes []string, deleteLocalData bool) error {
+	err := c.route(hashes, func(cl *Client, hs []string) error {
+		return cl.RemoveTorrent(hs, deleteLocalData)
+	})
+	c.mu.Lock()
+	for _, h := range hashes {
+		delete(c.owners, strings.ToLower(h))
+	}
+	c.mu.Unlock()
+	return err
+}
+
+func (c *Cluster) MoveTorrent(hashes []string, location string, move bool) error {
+	return c.route(hashes, func(cl *Client, hs []string) error {
+		return cl.MoveTorrent(hs, location, move)
+	})
+}
+
+func (c *Cluster) SetTorrent(hashes []string, settings *TorrentSettings) error {
+	return c.route(hashes, func(cl *Client, hs []string) error {
+		return cl.SetTorrent(hs, settings)
+	})
+}
+
+// magnetHash returns the hex info hash of a magnet link, if it has one.
+// Info hashes may be hex or base32 encoded.
+func magnetHash(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "magnet" {
+		return ""
+	}
+	for _, xt := range u.Query()["xt"] {
+		h := strings.TrimPrefix(xt, "urn:btih:")
+		if h == xt {
+			continue
+		}
+		switch len(h) {
+		case 40:
+			if _, err := hex.DecodeString(h); err == nil {
+				return strings.ToLower(h)
+			}
+		case 32:
+			if b, err := base32.StdEncoding.DecodeString(strings.ToUpper(h)); err == nil {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+	return ""
+}
+
+// place picks the node to add a torrent to. Nodes that can't be
+// queried are skipped.
+func (c *Cluster) place(torrent *NewTorrent) (int, error) {
+	scores := make([]int64, len(c.Nodes))
+	err := c.each(func(i int, n *Node) error {
+		if c.Placement == PlaceFewestActive {
+			stats, err := n.Client.SessionStats()
+			if err != nil {
+				return err
+			}
+			// Fewer is better.
+			scores[i] = -int64(stats.ActiveTorrents)
+			return nil
+		}
+		dir := torrent.DownloadDir
+		if dir == "" {
+			info, err := n.Client.SessionInfo([]string{"download-dir"})
+			if err != nil {
+				return err
+			}
+			dir = info.DownloadDir
+		}
+		free, err := n.Client.FreeSpace(dir)
+		if err != nil {
+			return err
+		}
+		scores[i] = free
+		return nil
+	})
+	errs, _ := err.(NodeErrors)
+	best := -1
+	for i := range c.Nodes {
+		if _, failed := errs[c.Nodes[i].Name]; failed {
+			continue
+		}
+		if best == -1 || scores[i] > scores[best] {
+			best = i
+		}
+	}
+	if best == -1 {
+		return 0, fmt.Errorf("no node available: %s", err)
+	}
+	return best, nil
+}
+
+// AddTorrent adds a torrent to the node chosen by the placement
+// strategy. If any node already has the torrent, it isn't added again,
+// and duplicate is true. node is the name of the node that has the
+// torrent.
+//
+// If some of the other nodes can't be checked for the torrent, it is
+// added but left stopped, because it may be a duplicate, and their
+// errors are returned as NodeErrors.
+func (c *Cluster) AddTorrent(torrent *NewTorrent) (node string, info AddedTorrent, duplicate bool, err error) {
+	// For magnet links, we know the hash in advance and can avoid
+	// adding a duplicate altogether.
+	if h := magnetHash(torrent.Filename); h != "" {
+		if n, err := c.Owner(h); err == nil {
+			if found, ok, err := findTorrent(n.Client, h); err == nil && ok {
+				return n.Name, found, true, nil
+			}
+		}
+	}
+
+	i, err := c.place(torrent)
+	if err != nil {
+		return "", AddedTorrent{}, false, err
+	}
+	n := &c.Nodes[i]
+	// Add the torrent paused, so that it doesn't start downloading
+	// before we know that no other node has it.
+	paused := *torrent
+	paused.Paused = true
+	info, duplicate, err = n.Client.AddTorrent(&paused)
+	if err != nil {
+		return n.Name, info, false, err
+	}
+	if duplicate {
+		c.setOwner(info.Hash, i)
+		return n.Name, info, true, nil
+	}
+
+	// Check that no other node already had the torrent.
+	var (
+		mu    sync.Mutex
+		other = -1
+		found AddedTorrent
+	)
+	err = c.each(func(j int, o *Node) error {
+		if j == i {
+			return nil
+		}
+		t, ok, err := findTorrent(o.Client, info.Hash)
+		if err != nil || !ok {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if other == -1 || j < other {
+			other = j
+			found = t
+		}
+		return nil
+	})
+	if other == -1 {
+		c.setOwner(info.Hash, i)
+		if err != nil {
+			return n.Name, info, false, err
+		}
+		if !torrent.Paused {
+			if err := n.Client.StartTorrent([]string{info.Hash}); err != nil {
+				return n.Name, info, false, fmt.Errorf("added torrent to %s, but couldn't start it: %s", n.Name, err)
+			}
+		}
+		return n.Name, info, false, nil
+	}
+	if err := n.Client.RemoveTorrent([]string{info.Hash}, false); err != nil {
+		return n.Name, info, false, fmt.Errorf("%s already has the torrent, but couldn't remove it from %s again: %s", c.Nodes[other].Name, n.Name, err)
+	}
+	c.setOwner(found.Hash, other)
+	return c.Nodes[other].Name, found, true, nil
+}
+
+func findTorrent(cl *Client, hash string) (AddedTorrent, bool, error) {
+	infos, err := cl.TorrentInfo([]string{hash}, []string{"id", "name", "hashString"})
+	if err != nil {
+		return AddedTorrent{}, false, err
+	}
+	for _, info := range infos {
+		if strings.EqualFold(info.Hash, hash) {
+			return AddedTorrent{ID: info.ID, Name: info.Name, Hash: info.Hash}, true, nil
+		}
+	}
+	return AddedTorrent{}, false, nil
+}
@@ -0,0 +1,87 @@
+// Command transmission-diskguard pauses downloads of a Transmission
+// daemon before they fill its disks, and resumes them once enough
+// space is available again.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"honnef.co/go/transmission/config"
+	"honnef.co/go/transmission/diskguard"
+	"honnef.co/go/transmission/internal/format"
+)
+
+var (
+	fConfig     = flag.String("config", "", "Configuration file with connection profiles")
+	fProfile    = flag.String("profile", "", "Connection profile to use")
+	fRPC        = flag.String("rpc", "", "URL of Transmission RPC endpoint, overriding the profile (default "+config.DefaultURL+")")
+	fUser       = flag.String("user", "", "Transmission username, overriding the profile")
+	fPassFile   = flag.String("pass-file", "", "File to read Transmission password from, overriding the profile")
+	fMinFree    = flag.String("min-free", "10G", "Free space to keep in every download directory, such as 500M or 10G")
+	fResumeFree = flag.String("resume-free", "", "Projected free space required to resume torrents (default -min-free)")
+	fMode       = flag.String("mode", diskguard.ModeStop, "How to pause torrents: stop, or requeue to also move them to the bottom of the queue")
+	fLabel      = flag.String("label", "", "Label to mark paused torrents with, so they are resumed after a restart")
+	fInterval   = flag.Duration("interval", time.Minute, "How often to check")
+	fOnce       = flag.Bool("once", false, "Check once and exit")
+	fDryRun     = flag.Bool("dry-run", false, "Only print what would be done; implies -once")
+)
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	minFree, err := format.ParseSize(*fMinFree)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resumeFree := minFree
+	if *fResumeFree != "" {
+		resumeFree, err = format.ParseSize(*fResumeFree)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	switch *fMode {
+	case diskguard.ModeStop, diskguard.ModeRequeue:
+	default:
+		log.Fatalf("unknown mode %q", *fMode)
+	}
+
+	cl, err := config.ResolveClient(*fConfig, *fProfile, config.Overrides{URL: *fRPC, Username: *fUser, PasswordFile: *fPassFile})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g := &diskguard.Guard{
+		Client:     cl,
+		MinFree:    minFree,
+		ResumeFree: resumeFree,
+		Mode:       *fMode,
+		Label:      *fLabel,
+		DryRun:     *fDryRun,
+	}
+	report := func(events []diskguard.Event, err error) {
+		for _, ev := range events {
+			if *fDryRun {
+				log.Printf("would have %s", ev)
+			} else {
+				log.Print(ev)
+			}
+		}
+		if err != nil {
+			log.Print(err)
+		}
+	}
+	if *fOnce || *fDryRun {
+		events, err := g.Check()
+		report(events, err)
+		if err != nil {
+			log.Fatal("check failed")
+		}
+		return
+	}
+	g.Run(context.Background(), *fInterval, report)
+}
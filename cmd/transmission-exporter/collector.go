@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "transmission_"
+
+type CollectorOptions struct {
+	// Torrents enables the per-torrent metrics.
+	Torrents bool
+	// Session enables the session-level metrics.
+	Session bool
+	// Peers enables the aggregated peer statistics.
+	Peers bool
+	// Timeout bounds the time spent collecting metrics. Zero means no
+	// timeout.
+	Timeout time.Duration
+
+	Torrent TorrentOptions
+	// GeoIP, if not nil, is used to break down peers by country and
+	// autonomous system.
+	GeoIP *GeoIP
+}
+
+type Collector struct {
+	client   *transmission.Client
+	opts     CollectorOptions
+	torrents *torrentCollector
+	peers    *peerCollector
+
+	descUp             *prometheus.Desc
+	descScrapeDuration *prometheus.Desc
+
+	descInfo              *prometheus.Desc
+	descDownloadRate      *prometheus.Desc
+	descUploadRate        *prometheus.Desc
+	descDownloaded        *prometheus.Desc
+	descUploaded          *prometheus.Desc
+	descSessionDownloaded *prometheus.Desc
+	descSessionUploaded   *prometheus.Desc
+	descUptime            *prometheus.Desc
+	descActiveSeconds     *prometheus.Desc
+	descSessions          *prometheus.Desc
+	descFilesAdded        *prometheus.Desc
+	descTorrents          *prometheus.Desc
+	descSpeedLimit        *prometheus.Desc
+	descSpeedLimitEnabled *prometheus.Desc
+	descAltSpeedEnabled   *prometheus.Desc
+	descQueueSize         *prometheus.Desc
+	descQueueEnabled      *prometheus.Desc
+	descBlocklistRules    *prometheus.Desc
+	descDownloadDirFree   *prometheus.Desc
+}
+
+func NewCollector(client *transmission.Client, opts CollectorOptions) *Collector {
+	return &Collector{
+		client:   client,
+		opts:     opts,
+		torrents: newTorrentCollector(opts.Torrent),
+		peers:    newPeerCollector(opts.GeoIP),
+
+		descUp:             prometheus.NewDesc(namespace+"up", "Whether all metrics could be collected from the daemon", nil, nil),
+		descScrapeDuration: prometheus.NewDesc(namespace+"scrape_duration_seconds", "How long collecting metrics from the daemon took", nil, nil),
+
+		descInfo:              prometheus.NewDesc(namespace+"info", "Version of the Transmission daemon", []string{"version", "rpc_version"}, nil),
+		descDownloadRate:      prometheus.NewDesc(namespace+"download_rate_bytes", "Current global download rate in bytes per second", nil, nil),
+		descUploadRate:        prometheus.NewDesc(namespace+"upload_rate_bytes", "Current global upload rate in bytes per second", nil, nil),
+		descDownloaded:        prometheus.NewDesc(namespace+"downloaded_bytes_total", "Bytes downloaded across all sessions", nil, nil),
+		descUploaded:          prometheus.NewDesc(namespace+"uploaded_bytes_total", "Bytes uploaded across all sessions", nil, nil),
+		descSessionDownloaded: prometheus.NewDesc(namespace+"session_downloaded_bytes", "Bytes downloaded in the current session", nil, nil),
+		descSessionUploaded:   prometheus.NewDesc(namespace+"session_uploaded_bytes", "Bytes uploaded in the current session", nil, nil),
+		descUptime:            prometheus.NewDesc(namespace+"uptime_seconds", "Seconds since the daemon was started", nil, nil),
+		descActiveSeconds:     prometheus.NewDesc(namespace+"active_seconds_total", "Seconds the daemon has been running, across all sessions", nil, nil),
+		descSessions:          prometheus.NewDesc(namespace+"sessions_total", "Number of times the daemon has been started", nil, nil),
+		descFilesAdded:        prometheus.NewDesc(namespace+"files_added_total", "Number of files added across all sessions", nil, nil),
+		descTorrents:          prometheus.NewDesc(namespace+"torrents", "Number of torrents", []string{"state"}, nil),
+		descSpeedLimit:        prometheus.NewDesc(namespace+"speed_limit_bytes", "Configured speed limit in bytes per second", []string{"direction", "alt"}, nil),
+		descSpeedLimitEnabled: prometheus.NewDesc(namespace+"speed_limit_enabled", "Whether the regular speed limit is enabled", []string{"direction"}, nil),
+		descAltSpeedEnabled:   prometheus.NewDesc(namespace+"alt_speed_enabled", "Whether the alternative speed limits are in effect", nil, nil),
+		descQueueSize:         prometheus.NewDesc(namespace+"queue_size", "Maximum number of torrents in the queue", []string{"queue"}, nil),
+		descQueueEnabled:      prometheus.NewDesc(namespace+"queue_enabled", "Whether the queue is enabled", []string{"queue"}, nil),
+		descBlocklistRules:    prometheus.NewDesc(namespace+"blocklist_rules", "Number of rules in the blocklist", nil, nil),
+		descDownloadDirFree:   prometheus.NewDesc(namespace+"download_dir_free_bytes", "Free space in the default download directory", []string{"path"}, nil),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.descUp
+	ch <- c.descScrapeDuration
+	c.torrents.describe(ch)
+	c.peers.describe(ch)
+
+	ch <- c.descInfo
+	ch <- c.descDownloadRate
+	ch <- c.descUploadRate
+	ch <- c.descDownloaded
+	ch <- c.descUploaded
+	ch <- c.descSessionDownloaded
+	ch <- c.descSessionUploaded
+	ch <- c.descUptime
+	ch <- c.descActiveSeconds
+	ch <- c.descSessions
+	ch <- c.descFilesAdded
+	ch <- c.descTorrents
+	ch <- c.descSpeedLimit
+	ch <- c.descSpeedLimitEnabled
+	ch <- c.descAltSpeedEnabled
+	ch <- c.descQueueSize
+	ch <- c.descQueueEnabled
+	ch <- c.descBlocklistRules
+	ch <- c.descDownloadDirFree
+}
+
+// Collect collects metrics from the daemon. Failures are reported
+// via transmission_up rather than failing the whole scrape, and
+// metrics that depend on a failed request are omitted. All requests
+// share a single deadline, so that the scrape as a whole doesn't take
+// longer than the timeout.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	ctx := context.Background()
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(c.opts.Timeout))
+		defer cancel()
+	}
+
+	var errs []string
+	if c.opts.Torrents {
+		if err := c.collectTorrents(ctx, ch); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if c.opts.Session {
+		if err := c.collectSession(ctx, ch); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if c.opts.Peers {
+		if err := c.peers.collect(ctx, ch, c.client); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	up := 1.0
+	if len(errs) > 0 {
+		up = 0
+		log.Printf("collecting metrics from %s failed: %s", c.client.Endpoint, strings.Join(errs, "; "))
+	}
+	ch <- prometheus.MustNewConstMetric(c.descUp, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(c.descScrapeDuration, prometheus.GaugeValue, time.Since(start).Seconds())
+}
+
+func (c *Collector) collectTorrents(ctx context.Context, ch chan<- prometheus.Metric) error {
+	torrents, err := c.torrents.fetch(ctx, c.client)
+	if err != nil {
+		return fmt.Errorf("couldn't get torrents: %s", err)
+	}
+	c.torrents.collect(ch, torrents)
+	return nil
+}
+
+var sessionFields = []string{
+	"version", "rpc-version", "speed-limit-down", "speed-limit-down-enabled", "speed-limit-up",
+	"speed-limit-up-enabled", "alt-speed-down", "alt-speed-up", "alt-speed-enabled", "download-queue-size",
+	"download-queue-enabled", "seed-queue-size", "seed-queue-enabled", "blocklist-size", "download-dir", "units",
+}
+
+func (c *Collector) collectSession(ctx context.Context, ch chan<- prometheus.Metric) error {
+	stats, err := c.client.SessionStatsContext(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't get session statistics: %s", err)
+	}
+	gauge := func(desc *prometheus.Desc, v int, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(v), labels...)
+	}
+	counter := func(desc *prometheus.Desc, v int, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), labels...)
+	}
+	gauge(c.descDownloadRate, stats.DownloadSpeed)
+	gauge(c.descUploadRate, stats.UploadSpeed)
+	counter(c.descDownloaded, stats.CumulativeStats.DownloadedBytes)
+	counter(c.descUploaded, stats.CumulativeStats.UploadedBytes)
+	gauge(c.descSessionDownloaded, stats.CurrentStats.DownloadedBytes)
+	gauge(c.descSessionUploaded, stats.CurrentStats.UploadedBytes)
+	gauge(c.descUptime, stats.CurrentStats.SecondsActive)
+	counter(c.descActiveSeconds, stats.CumulativeStats.SecondsActive)
+	counter(c.descSessions, stats.CumulativeStats.SessionCount)
+	counter(c.descFilesAdded, stats.CumulativeStats.FilesAdded)
+	gauge(c.descTorrents, stats.ActiveTorrents, "active")
+	gauge(c.descTorrents, stats.PausedTorrents, "paused")
+
+	info, err := c.client.SessionInfoContext(ctx, sessionFields)
+	if err != nil {
+		return fmt.Errorf("couldn't get session: %s", err)
+	}
+	ch <- prometheus.MustNewConstMetric(c.descInfo, prometheus.GaugeValue, 1, info.Version, strconv.Itoa(info.RpcVersion))
+	// Speed limits are configured in units of speed-bytes, which is
+	// usually 1000.
+	kB := info.Units.SpeedBytes
+	if kB == 0 {
+		kB = 1000
+	}
+	gauge(c.descSpeedLimit, info.SpeedLimitDown*kB, "down", "false")
+	gauge(c.descSpeedLimit, info.SpeedLimitUp*kB, "up", "false")
+	gauge(c.descSpeedLimit, info.AltSpeedDown*kB, "down", "true")
+	gauge(c.descSpeedLimit, info.AltSpeedUp*kB, "up", "true")
+	gauge(c.descSpeedLimitEnabled, boolToInt(info.SpeedLimitDownEnabled), "down")
+	gauge(c.descSpeedLimitEnabled, boolToInt(info.SpeedLimitUpEnabled), "up")
+	gauge(c.descAltSpeedEnabled, boolToInt(info.AltSpeedEnabled))
+	gauge(c.descQueueSize, info.DownloadQueueSize, "download")
+	gauge(c.descQueueSize, info.SeedQueueSize, "seed")
+	gauge(c.descQueueEnabled, boolToInt(info.DownloadQueueEnabled), "download")
+	gauge(c.descQueueEnabled, boolToInt(info.SeedQueueEnabled), "seed")
+	gauge(c.descBlocklistRules, info.BlocklistSize)
+
+	free, err := c.client.FreeSpaceContext(ctx, info.DownloadDir)
+	if err != nil {
+		return fmt.Errorf("couldn't get free space: %s", err)
+	}
+	ch <- prometheus.MustNewConstMetric(c.descDownloadDirFree, prometheus.GaugeValue, float64(free), info.DownloadDir)
+	return nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -1,90 +1,46 @@
 package main
 
 import (
-	"bytes"
 	"flag"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
-	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/config"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// TODO uptime_seconds_total
-
-const namespace = "transmission_"
-
 var (
 	telemetryAddr = flag.String("telemetry.addr", ":9742", "address for transmission exporter")
 	metricsPath   = flag.String("telemetry.path", "/metrics", "URL path for surfacing collected metrics")
-
-	transmissionRPC      = flag.String("transmission.rpc", "http://localhost:9091/transmission/rpc", "URL of Transmission RPC endpoint")
-	transmissionUser     = flag.String("transmission.user", "", "Transmission username")
-	transmissionPass     = flag.String("transmission.pass", "", "Transmission password")
-	transmissionPassFile = flag.String("transmission.pass-file", "", "File to read Transmission password from")
+	probePath     = flag.String("telemetry.probe-path", "/probe", "URL path for probing the targets in the configuration file")
+
+	transmissionConfig   = flag.String("transmission.config", "", "Configuration file with connection profiles")
+	transmissionProfile  = flag.String("transmission.profile", "", "Connection profile to use")
+	transmissionRPC      = flag.String("transmission.rpc", "", "URL of Transmission RPC endpoint, overriding the profile (default "+config.DefaultURL+")")
+	transmissionUser     = flag.String("transmission.user", "", "Transmission username, overriding the profile")
+	transmissionPass     = flag.String("transmission.pass", "", "Transmission password, overriding the profile")
+	transmissionPassFile = flag.String("transmission.pass-file", "", "File to read Transmission password from, overriding the profile")
+	defaultTarget        = flag.Bool("transmission.default-target", true, "Collect metrics of the configured daemon on the metrics path; disable to only use the probe path")
+
+	torrentLabelFlag = flag.String("torrent.labels", "", "Comma-separated list of additional labels for per-torrent metrics: name, labels, download_dir, tracker_host, private")
+	torrentMaxSeries = flag.Int("torrent.max-series", 0, "Maximum number of torrents to report individually; 0 means no limit")
+	torrentOverflow  = flag.String("torrent.overflow", OverflowAggregate, "What to do with torrents above -torrent.max-series: aggregate or drop")
+	torrentCache     = flag.Bool("torrent.cache-labels", false, "Cache the values of -torrent.labels and only refresh them when a torrent was edited")
+
+	collectTorrents = flag.Bool("collector.torrents", true, "Collect per-torrent metrics")
+	collectSession  = flag.Bool("collector.session", true, "Collect session-level metrics")
+	collectPeers    = flag.Bool("collector.peers", false, "Collect aggregated peer statistics")
+	geoIPCountry    = flag.String("peers.geoip-country", "", "MaxMind-format country database for breaking down peers by country")
+	geoIPASN        = flag.String("peers.geoip-asn", "", "MaxMind-format ASN database for breaking down peers by autonomous system")
+	scrapeTimeout   = flag.Duration("transmission.timeout", 10*time.Second, "Timeout for collecting metrics from a daemon; 0 means no timeout")
 )
 
-type Collector struct {
-	client *transmission.Client
-
-	descTorrentDownloaded    *prometheus.Desc
-	descTorrentUploaded      *prometheus.Desc
-	descTorrentTrackers      *prometheus.Desc
-	descTorrentStatus        *prometheus.Desc
-	descTorrentSize          *prometheus.Desc
-	descPeers                *prometheus.Desc
-	descPeersUploadingTo     *prometheus.Desc
-	descPeersDownloadingFrom *prometheus.Desc
-}
-
-func NewCollector(client *transmission.Client) *Collector {
-	return &Collector{
-		client: client,
-
-		descTorrentDownloaded:    prometheus.NewDesc(namespace+"torrent_downloaded_bytes_total", "", []string{"torrent"}, nil),
-		descTorrentUploaded:      prometheus.NewDesc(namespace+"torrent_uploaded_bytes_total", "", []string{"torrent"}, nil),
-		descTorrentTrackers:      prometheus.NewDesc(namespace+"torrent_trackers", "", []string{"torrent", "tracker"}, nil),
-		descTorrentStatus:        prometheus.NewDesc(namespace+"torrent_status", "", []string{"torrent", "status"}, nil),
-		descTorrentSize:          prometheus.NewDesc(namespace+"torrent_size_bytes", "", []string{"torrent"}, nil),
-		descPeers:                prometheus.NewDesc(namespace+"torrent_peers", "", []string{"torrent"}, nil),
-		descPeersUploadingTo:     prometheus.NewDesc(namespace+"torrent_peers_uploading_to", "", []string{"torrent"}, nil),
-		descPeersDownloadingFrom: prometheus.NewDesc(namespace+"torrent_peers_downloading_from", "", []string{"torrent"}, nil),
-	}
-}
-
-func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.descTorrentDownloaded
-	ch <- c.descTorrentUploaded
-	ch <- c.descTorrentTrackers
-	ch <- c.descTorrentStatus
-	ch <- c.descTorrentSize
-	ch <- c.descPeers
-	ch <- c.descPeersUploadingTo
-	ch <- c.descPeersDownloadingFrom
-}
-
-func (c *Collector) Collect(ch chan<- prometheus.Metric) {
-	res, err := c.client.TorrentInfo(nil, transmission.AllTorrentFields)
-	if err != nil {
-		ch <- prometheus.NewInvalidMetric(prometheus.NewInvalidDesc(err), err)
-	}
-
-	for _, info := range res {
-		for _, tr := range info.TrackerStats {
-			ch <- prometheus.MustNewConstMetric(c.descTorrentTrackers, prometheus.GaugeValue, 1, info.Hash, tr.Host)
-		}
-		ch <- prometheus.MustNewConstMetric(c.descTorrentStatus, prometheus.GaugeValue, 1, info.Hash, info.Status.String())
-		ch <- prometheus.MustNewConstMetric(c.descTorrentSize, prometheus.GaugeValue, float64(info.SizeWhenDone), info.Hash)
-		ch <- prometheus.MustNewConstMetric(c.descTorrentUploaded, prometheus.CounterValue, float64(info.UploadedEver), info.Hash)
-		ch <- prometheus.MustNewConstMetric(c.descTorrentDownloaded, prometheus.CounterValue, float64(info.DownloadedEver), info.Hash)
-		ch <- prometheus.MustNewConstMetric(c.descPeersUploadingTo, prometheus.GaugeValue, float64(info.PeersGettingFromUs), info.Hash)
-		ch <- prometheus.MustNewConstMetric(c.descPeersDownloadingFrom, prometheus.GaugeValue, float64(info.PeersSendingToUs), info.Hash)
-		ch <- prometheus.MustNewConstMetric(c.descPeers, prometheus.GaugeValue, float64(info.PeersConnected), info.Hash)
-	}
-}
+var collectorOptions CollectorOptions
 
 func main() {
 	log.SetFlags(0)
@@ -94,25 +50,71 @@ func main() {
 		log.Fatal("shouldn't specify both -transmission.pass and -transmission.pass-file")
 	}
 
-	pass := *transmissionPass
-	if *transmissionPassFile != "" {
-		b, err := ioutil.ReadFile(*transmissionPassFile)
+	collectorOptions = CollectorOptions{
+		Torrents: *collectTorrents,
+		Session:  *collectSession,
+		Peers:    *collectPeers,
+		Timeout:  *scrapeTimeout,
+		Torrent: TorrentOptions{
+			MaxSeries:   *torrentMaxSeries,
+			Overflow:    *torrentOverflow,
+			CacheLabels: *torrentCache,
+		},
+	}
+	if *torrentLabelFlag != "" {
+		collectorOptions.Torrent.Labels = strings.Split(*torrentLabelFlag, ",")
+	}
+	if err := collectorOptions.Torrent.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if *geoIPCountry != "" || *geoIPASN != "" {
+		g, err := OpenGeoIP(*geoIPCountry, *geoIPASN)
 		if err != nil {
-			log.Fatalf("couldn't read password: %s", err)
+			log.Fatal(err)
 		}
-		pass = string(bytes.TrimRight(b, "\n"))
+		collectorOptions.GeoIP = g
 	}
 
-	cl := &transmission.Client{
-		Client:   http.DefaultClient,
-		Endpoint: *transmissionRPC,
-		Username: *transmissionUser,
-		Password: pass,
+	if *transmissionConfig != "" {
+		f, err := config.Load(*transmissionConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *defaultTarget && *transmissionProfile == "" && os.Getenv("TRANSMISSION_PROFILE") == "" {
+			// With several targets and none of them the default, there
+			// is nothing sensible to collect on the metrics path.
+			if _, err := f.Profile(""); err != nil {
+				log.Printf("%s: %s; only serving probes", *transmissionConfig, err)
+				*defaultTarget = false
+			}
+		}
+		prober, err := newProber(f, collectorOptions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		prometheus.MustRegister(probeErrors)
+		http.Handle(*probePath, prober)
+	}
+	if *defaultTarget {
+		registerDefaultTarget()
 	}
 
-	prometheus.MustRegister(NewCollector(cl))
 	http.Handle(*metricsPath, promhttp.Handler())
 	if err := http.ListenAndServe(*telemetryAddr, nil); err != nil {
 		log.Fatal(err)
 	}
 }
+
+func registerDefaultTarget() {
+	cl, err := config.ResolveClient(*transmissionConfig, *transmissionProfile, config.Overrides{
+		URL:          *transmissionRPC,
+		Username:     *transmissionUser,
+		Password:     *transmissionPass,
+		PasswordFile: *transmissionPassFile,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	prometheus.MustRegister(NewCollector(cl, collectorOptions))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"honnef.co/go/transmission"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	progressBuckets = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}
+	rateBuckets     = prometheus.ExponentialBuckets(1024, 4, 9)
+)
+
+// GeoIP looks up the country and autonomous system of peers in
+// MaxMind-format databases. Either database may be nil.
+type GeoIP struct {
+	Country *maxminddb.Reader
+	ASN     *maxminddb.Reader
+}
+
+// OpenGeoIP opens the country and ASN databases. Empty paths are
+// skipped.
+func OpenGeoIP(countryPath, asnPath string) (*GeoIP, error) {
+	g := &GeoIP{}
+	var err error
+	if countryPath != "" {
+		g.Country, err = maxminddb.Open(countryPath)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open country database: %s", err)
+		}
+	}
+	if asnPath != "" {
+		g.ASN, err = maxminddb.Open(asnPath)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open ASN database: %s", err)
+		}
+	}
+	return g, nil
+}
+
+func (g *GeoIP) country(ip net.IP) string {
+	var rec struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := g.Country.Lookup(ip, &rec); err != nil || rec.Country.ISOCode == "" {
+		return "unknown"
+	}
+	return rec.Country.ISOCode
+}
+
+func (g *GeoIP) asn(ip net.IP) (asn, org string) {
+	var rec struct {
+		Number       uint   `maxminddb:"autonomous_system_number"`
+		Organization string `maxminddb:"autonomous_system_organization"`
+	}
+	if err := g.ASN.Lookup(ip, &rec); err != nil || rec.Number == 0 {
+		return "unknown", ""
+	}
+	return "AS" + strconv.FormatUint(uint64(rec.Number), 10), rec.Organization
+}
+
+// peerCollector reports statistics of the peers of all torrents. Peers
+// are aggregated so that no per-peer or per-address series are
+// emitted.
+type peerCollector struct {
+	geoIP *GeoIP
+
+	descPeers        *prometheus.Desc
+	descCountry      *prometheus.Desc
+	descASN          *prometheus.Desc
+	descProgress     *prometheus.Desc
+	descDownloadRate *prometheus.Desc
+	descUploadRate   *prometheus.Desc
+}
+
+func newPeerCollector(geoIP *GeoIP) *peerCollector {
+	return &peerCollector{
+		geoIP: geoIP,
+
+		descPeers:        prometheus.NewDesc(namespace+"peers", "Number of connected peers", []string{"client", "transport", "encryption", "direction"}, nil),
+		descCountry:      prometheus.NewDesc(namespace+"peers_by_country", "Number of connected peers by country", []string{"country"}, nil),
+		descASN:          prometheus.NewDesc(namespace+"peers_by_asn", "Number of connected peers by autonomous system", []string{"asn", "organization"}, nil),
+		descProgress:     prometheus.NewDesc(namespace+"peer_progress", "How much of the torrent connected peers have", nil, nil),
+		descDownloadRate: prometheus.NewDesc(namespace+"peer_download_rate_bytes", "Rate at which we download from connected peers, in bytes per second", nil, nil),
+		descUploadRate:   prometheus.NewDesc(namespace+"peer_upload_rate_bytes", "Rate at which we upload to connected peers, in bytes per second", nil, nil),
+	}
+}
+
+func (c *peerCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.descPeers
+	ch <- c.descCountry
+	ch <- c.descASN
+	ch <- c.descProgress
+	ch <- c.descDownloadRate
+	ch <- c.descUploadRate
+}
+
+// histogram accumulates observations for a constant histogram.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) metric(desc *prometheus.Desc) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.bounds))
+	for i, b := range h.bounds {
+		buckets[b] = h.counts[i]
+	}
+	return prometheus.MustNewConstHistogram(desc, h.count, h.sum, buckets)
+}
+
+type peerKey struct {
+	client, transport, encryption, direction string
+}
+
+type asKey struct {
+	asn, org string
+}
+
+func (c *peerCollector) collect(ctx context.Context, ch chan<- prometheus.Metric, cl *transmission.Client) error {
+	infos, err := cl.TorrentInfoContext(ctx, nil, []string{"id", "peers"})
+	if err != nil {
+		return fmt.Errorf("couldn't get peers: %s", err)
+	}
+
+	peers := map[peerKey]int{}
+	countries := map[string]int{}
+	ases := map[asKey]int{}
+	progress := newHistogram(progressBuckets)
+	down := newHistogram(rateBuckets)
+	up := newHistogram(rateBuckets)
+	for _, info := range infos {
+		for _, p := range info.Peers {
+			key := peerKey{
+				client:     p.UnversionedClientName(),
+				transport:  "tcp",
+				encryption: "false",
+				direction:  "outgoing",
+			}
+			if p.IsUTP {
+				key.transport = "utp"
+			}
+			if p.IsEncrypted {
+				key.encryption = "true"
+			}
+			if p.IsIncoming {
+				key.direction = "incoming"
+			}
+			peers[key]++
+			progress.observe(p.Progress)
+			down.observe(float64(p.RateToClient))
+			up.observe(float64(p.RateToPeer))
+
+			if c.geoIP == nil {
+				continue
+			}
+			ip := net.ParseIP(p.Address)
+			if ip == nil {
+				continue
+			}
+			if c.geoIP.Country != nil {
+				countries[c.geoIP.country(ip)]++
+			}
+			if c.geoIP.ASN != nil {
+				asn, org := c.geoIP.asn(ip)
+				ases[asKey{asn, org}]++
+			}
+		}
+	}
+
+	for key, n := range peers {
+		ch <- prometheus.MustNewConstMetric(c.descPeers, prometheus.GaugeValue, float64(n), key.client, key.transport, key.encryption, key.direction)
+	}
+	for country, n := range countries {
+		ch <- prometheus.MustNewConstMetric(c.descCountry, prometheus.GaugeValue, float64(n), country)
+	}
+	for key, n := range ases {
+		ch <- prometheus.MustNewConstMetric(c.descASN, prometheus.GaugeValue, float64(n), key.asn, key.org)
+	}
+	ch <- progress.metric(c.descProgress)
+	ch <- down.metric(c.descDownloadRate)
+	ch <- up.metric(c.descUploadRate)
+	return nil
+}
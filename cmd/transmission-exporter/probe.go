@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"honnef.co/go/transmission/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var probeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: namespace + "probe_errors_total",
+	Help: "Number of failed probes, by target",
+}, []string{"target"})
+
+// prober serves the probe endpoint, which scrapes one of several
+// daemons, selected by the target query parameter. This allows a
+// single exporter to monitor many daemons, in the same fashion as the
+// blackbox and SNMP exporters.
+type prober struct {
+	// Collectors are created once and reused, so that their clients
+	// can hold on to their CSRF tokens and HTTP connections, and so
+	// that label caches persist across probes. Concurrent probes of
+	// the same target share them; both clients and collectors are
+	// safe for concurrent use.
+	collectors map[string]*Collector
+}
+
+func newProber(f *config.File, opts CollectorOptions) (*prober, error) {
+	p := &prober{collectors: map[string]*Collector{}}
+	for _, name := range f.Names() {
+		cl, err := f.Profiles[name].Client()
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %s", name, err)
+		}
+		p.collectors[name] = NewCollector(cl, opts)
+		// Initialize the counter so that it exists before the first error.
+		probeErrors.WithLabelValues(name)
+	}
+	return p, nil
+}
+
+func (p *prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	c, ok := p.collectors[target]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown target %q", target), http.StatusBadRequest)
+		return
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	start := time.Now()
+	mfs, err := reg.Gather()
+	duration := time.Since(start)
+	if err != nil {
+		log.Printf("probe of %s failed: %s", target, err)
+	}
+	ok = err == nil && targetUp(mfs)
+	if !ok {
+		probeErrors.WithLabelValues(target).Inc()
+	}
+
+	success := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: namespace + "probe_success",
+		Help: "Whether all metrics of the target could be collected",
+	})
+	if ok {
+		success.Set(1)
+	}
+	durationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: namespace + "probe_duration_seconds",
+		Help: "How long the probe took",
+	})
+	durationGauge.Set(duration.Seconds())
+	probeReg := prometheus.NewRegistry()
+	probeReg.MustRegister(success, durationGauge)
+
+	gatherers := prometheus.Gatherers{
+		probeReg,
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return mfs, nil }),
+	}
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}).ServeHTTP(w, r)
+}
+
+// targetUp reports whether transmission_up is 1 in mfs.
+func targetUp(mfs []*dto.MetricFamily) bool {
+	for _, mf := range mfs {
+		if mf.GetName() != namespace+"up" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			return m.GetGauge().GetValue() == 1
+		}
+	}
+	return false
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"honnef.co/go/transmission"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// otherTorrent is the value of the torrent label for series that
+// aggregate the torrents above the series limit.
+const otherTorrent = "_other"
+
+// torrentLabel is an additional label that can be attached to
+// per-torrent metrics.
+type torrentLabel struct {
+	// fields are the torrent fields needed to compute the label.
+	fields []string
+	value  func(info *transmission.TorrentInfo) string
+}
+
+var torrentLabels = map[string]torrentLabel{
+	"name":         {[]string{"name"}, func(info *transmission.TorrentInfo) string { return info.Name }},
+	"labels":       {[]string{"labels"}, func(info *transmission.TorrentInfo) string { return strings.Join(info.Labels, ",") }},
+	"download_dir": {[]string{"downloadDir"}, func(info *transmission.TorrentInfo) string { return info.DownloadDir }},
+	"tracker_host": {[]string{"trackers"}, trackerHost},
+	"private": {[]string{"isPrivate"}, func(info *transmission.TorrentInfo) string {
+		if info.IsPrivate {
+			return "true"
+		}
+		return "false"
+	}},
+}
+
+func trackerHost(info *transmission.TorrentInfo) string {
+	if len(info.Trackers) == 0 {
+		return ""
+	}
+	u, err := url.Parse(info.Trackers[0].Announce)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// Overflow strategies for torrents above TorrentOptions.MaxSeries.
+const (
+	OverflowAggregate = "aggregate"
+	OverflowDrop      = "drop"
+)
+
+type TorrentOptions struct {
+	// Labels are additional labels to attach to per-torrent metrics,
+	// from the keys of torrentLabels.
+	Labels []string
+	// MaxSeries limits the number of torrents that get their own
+	// series. Torrents are kept in order of their IDs, so that the
+	// same torrents are reported from scrape to scrape. Zero means no
+	// limit.
+	MaxSeries int
+	// Overflow determines what happens to the remaining torrents;
+	// they are either summed up in series with the torrent label set
+	// to "_other", or dropped.
+	Overflow string
+	// CacheLabels caches the values of the additional labels and only
+	// fetches the fields they are computed from for torrents whose
+	// edit date changed.
+	CacheLabels bool
+}
+
+func (opts TorrentOptions) validate() error {
+	seen := map[string]bool{}
+	for _, l := range opts.Labels {
+		if _, ok := torrentLabels[l]; !ok {
+			return fmt.Errorf("unknown torrent label %q", l)
+		}
+		if seen[l] {
+			return fmt.Errorf("duplicate torrent label %q", l)
+		}
+		seen[l] = true
+	}
+	if opts.MaxSeries < 0 {
+		return fmt.Errorf("negative series limit %d", opts.MaxSeries)
+	}
+	switch opts.Overflow {
+	case "", OverflowAggregate, OverflowDrop:
+	default:
+		return fmt.Errorf("unknown overflow strategy %q", opts.Overflow)
+	}
+	return nil
+}
+
+type torrentCollector struct {
+	opts TorrentOptions
+
+	mu    sync.Mutex
+	cache map[string]cachedLabels
+
+	descDownloaded       *prometheus.Desc
+	descUploaded         *prometheus.Desc
+	descTrackers         *prometheus.Desc
+	descStatus           *prometheus.Desc
+	descSize             *prometheus.Desc
+	descPeers            *prometheus.Desc
+	descPeersUploadingTo *prometheus.Desc
+	descPeersDownloading *prometheus.Desc
+	descDownloadRate     *prometheus.Desc
+	descUploadRate       *prometheus.Desc
+	descRatio            *prometheus.Desc
+	descETA              *prometheus.Desc
+	descQueuePosition    *prometheus.Desc
+	descCorrupt          *prometheus.Desc
+	descDesiredAvailable *prometheus.Desc
+	descLeftUntilDone    *prometheus.Desc
+	descTrackerSeeders   *prometheus.Desc
+	descTrackerLeechers  *prometheus.Desc
+	descOverLimit        *prometheus.Desc
+}
+
+func newTorrentCollector(opts TorrentOptions) *torrentCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		labels = append([]string{"torrent"}, labels...)
+		labels = append(labels, opts.Labels...)
+		return prometheus.NewDesc(namespace+name, help, labels, nil)
+	}
+	return &torrentCollector{
+		opts:  opts,
+		cache: map[string]cachedLabels{},
+
+		descDownloaded:       desc("torrent_downloaded_bytes_total", ""),
+		descUploaded:         desc("torrent_uploaded_bytes_total", ""),
+		descTrackers:         desc("torrent_trackers", "Number of trackers, by host", "tracker"),
+		descStatus:           desc("torrent_status", "", "status"),
+		descSize:             desc("torrent_size_bytes", ""),
+		descPeers:            desc("torrent_peers", ""),
+		descPeersUploadingTo: desc("torrent_peers_uploading_to", ""),
+		descPeersDownloading: desc("torrent_peers_downloading_from", ""),
+		descDownloadRate:     desc("torrent_download_rate_bytes", "Current download rate in bytes per second"),
+		descUploadRate:       desc("torrent_upload_rate_bytes", "Current upload rate in bytes per second"),
+		descRatio:            desc("torrent_ratio", "Upload ratio"),
+		descETA:              desc("torrent_eta_seconds", "Estimated seconds until the download completes or the seed ratio is reached"),
+		descQueuePosition:    desc("torrent_queue_position", "Position in the queue"),
+		descCorrupt:          desc("torrent_corrupt_bytes_total", "Bytes of corrupt data downloaded"),
+		descDesiredAvailable: desc("torrent_desired_available_bytes", "Bytes still wanted that connected peers have"),
+		descLeftUntilDone:    desc("torrent_left_until_done_bytes", "Bytes still wanted"),
+		descTrackerSeeders:   desc("torrent_tracker_seeders", "Number of seeders the tracker knows of; the maximum of all trackers on the same host", "tracker"),
+		descTrackerLeechers:  desc("torrent_tracker_leechers", "Number of leechers the tracker knows of; the maximum of all trackers on the same host", "tracker"),
+		descOverLimit:        prometheus.NewDesc(namespace+"torrents_over_series_limit", "Number of torrents without their own series because of the series limit", nil, nil),
+	}
+}
+
+func (c *torrentCollector) describe(ch chan<- *prometheus.Desc) {
+	ch <- c.descDownloaded
+	ch <- c.descUploaded
+	ch <- c.descTrackers
+	ch <- c.descStatus
+	ch <- c.descSize
+	ch <- c.descPeers
+	ch <- c.descPeersUploadingTo
+	ch <- c.descPeersDownloading
+	ch <- c.descDownloadRate
+	ch <- c.descUploadRate
+	ch <- c.descRatio
+	ch <- c.descETA
+	ch <- c.descQueuePosition
+	ch <- c.descCorrupt
+	ch <- c.descDesiredAvailable
+	ch <- c.descLeftUntilDone
+	ch <- c.descTrackerSeeders
+	ch <- c.descTrackerLeechers
+	ch <- c.descOverLimit
+}
+
+// torrentSums accumulates the summable values of the torrents above
+// the series limit.
+type torrentSums struct {
+	size                                      int
+	peers, peersUploadingTo, peersDownloading int
+	downloadRate, uploadRate                  int
+	desiredAvailable, leftUntilDone           int
+	trackers                                  map[string]int
+	status                                    map[string]int
+}
+
+// torrentFields are the fields needed for the per-torrent metrics,
+// excluding additional labels.
+var torrentFields = []string{
+	"id", "hashString", "status", "sizeWhenDone", "uploadedEver", "downloadedEver", "corruptEver",
+	"peersConnected", "peersGettingFromUs", "peersSendingToUs", "rateDownload", "rateUpload",
+	"uploadRatio", "eta", "queuePosition", "desiredAvailable", "leftUntilDone", "trackerStats",
+}
+
+type cachedLabels struct {
+	editDate time.Time
+	values   []string
+}
+
+func (c *torrentCollector) labelFields() []string {
+	var fields []string
+	for _, l := range c.opts.Labels {
+		fields = append(fields, torrentLabels[l].fields...)
+	}
+	return fields
+}
+
+func (c *torrentCollector) labelValues(info *transmission.TorrentInfo) []string {
+	values := make([]string, len(c.opts.Labels))
+	for i, l := range c.opts.Labels {
+		values[i] = torrentLabels[l].value(info)
+	}
+	return values
+}
+
+// fetch requests all torrents, along with the values of their
+// additional labels.
+func (c *torrentCollector) fetch(ctx context.Context, cl *transmission.Client) ([]torrent, error) {
+	if !c.opts.CacheLabels || len(c.opts.Labels) == 0 {
+		fields := append(append([]string(nil), torrentFields...), c.labelFields()...)
+		infos, err := cl.TorrentInfoContext(ctx, nil, fields)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]torrent, len(infos))
+		for i := range infos {
+			out[i] = torrent{infos[i], c.labelValues(&infos[i])}
+		}
+		return out, nil
+	}
+
+	fields := append(append([]string(nil), torrentFields...), "editDate")
+	infos, err := cl.TorrentInfoContext(ctx, nil, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := map[string]bool{}
+	var stale []string
+	for _, info := range infos {
+		seen[info.Hash] = true
+		if cached, ok := c.cache[info.Hash]; !ok || !cached.editDate.Equal(info.EditDate) {
+			stale = append(stale, info.Hash)
+		}
+	}
+	for hash := range c.cache {
+		if !seen[hash] {
+			delete(c.cache, hash)
+		}
+	}
+	if len(stale) > 0 {
+		fields := append([]string{"hashString", "editDate"}, c.labelFields()...)
+		updated, err := cl.TorrentInfoContext(ctx, stale, fields)
+		if err != nil {
+			return nil, err
+		}
+		for i := range updated {
+			c.cache[updated[i].Hash] = cachedLabels{updated[i].EditDate, c.labelValues(&updated[i])}
+		}
+	}
+
+	out := make([]torrent, 0, len(infos))
+	for _, info := range infos {
+		cached, ok := c.cache[info.Hash]
+		if !ok {
+			// The torrent was removed between the two requests.
+			continue
+		}
+		out = append(out, torrent{info, cached.values})
+	}
+	return out, nil
+}
+
+// torrent is a torrent together with the values of its additional
+// labels.
+type torrent struct {
+	info   transmission.TorrentInfo
+	labels []string
+}
+
+func (c *torrentCollector) collect(ch chan<- prometheus.Metric, torrents []torrent) {
+	sort.Slice(torrents, func(i, j int) bool { return torrents[i].info.ID < torrents[j].info.ID })
+
+	var over []torrent
+	if c.opts.MaxSeries > 0 && len(torrents) > c.opts.MaxSeries {
+		torrents, over = torrents[:c.opts.MaxSeries], torrents[c.opts.MaxSeries:]
+	}
+	ch <- prometheus.MustNewConstMetric(c.descOverLimit, prometheus.GaugeValue, float64(len(over)))
+
+	for i := range torrents {
+		c.collectTorrent(ch, &torrents[i].info, torrents[i].labels)
+	}
+	if len(over) > 0 && c.opts.Overflow != OverflowDrop {
+		c.collectOther(ch, over)
+	}
+}
+
+func (c *torrentCollector) collectTorrent(ch chan<- prometheus.Metric, info *transmission.TorrentInfo, extra []string) {
+	labels := func(ls ...string) []string {
+		out := append([]string{info.Hash}, ls...)
+		return append(out, extra...)
+	}
+	gauge := func(desc *prometheus.Desc, v float64, ls ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels(ls...)...)
+	}
+	counter := func(desc *prometheus.Desc, v float64, ls ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, labels(ls...)...)
+	}
+
+	// Several trackers can share a host, for example in different
+	// tiers or with different announce paths, but each host may only
+	// have one series. Count the trackers per host and report the
+	// largest swarm any of them knows of.
+	type hostStats struct {
+		trackers int
+		seeders  int
+		leechers int
+	}
+	var hosts []string
+	stats := map[string]*hostStats{}
+	for _, tr := range info.TrackerStats {
+		st, ok := stats[tr.Host]
+		if !ok {
+			// Negative counts mean that the tracker doesn't know.
+			st = &hostStats{seeders: -1, leechers: -1}
+			stats[tr.Host] = st
+			hosts = append(hosts, tr.Host)
+		}
+		st.trackers++
+		if tr.SeederCount > st.seeders {
+			st.seeders = tr.SeederCount
+		}
+		if tr.LeecherCount > st.leechers {
+			st.leechers = tr.LeecherCount
+		}
+	}
+	for _, host := range hosts {
+		st := stats[host]
+		gauge(c.descTrackers, float64(st.trackers), host)
+		if st.seeders >= 0 {
+			gauge(c.descTrackerSeeders, float64(st.seeders), host)
+		}
+		if st.leechers >= 0 {
+			gauge(c.descTrackerLeechers, float64(st.leechers), host)
+		}
+	}
+	gauge(c.descStatus, 1, info.Status.String())
+	gauge(c.descSize, float64(info.SizeWhenDone))
+	counter(c.descUploaded, float64(info.UploadedEver))
+	counter(c.descDownloaded, float64(info.DownloadedEver))
+	counter(c.descCorrupt, float64(info.CorruptEver))
+	gauge(c.descPeersUploadingTo, float64(info.PeersGettingFromUs))
+	gauge(c.descPeersDownloading, float64(info.PeersSendingToUs))
+	gauge(c.descPeers, float64(info.PeersConnected))
+	gauge(c.descDownloadRate, float64(info.RateDownload))
+	gauge(c.descUploadRate, float64(info.RateUpload))
+	gauge(c.descRatio, info.UploadRatio)
+	// Transmission uses negative ETAs for "not available" and
+	// "unknown".
+	if info.ETA >= 0 {
+		gauge(c.descETA, info.ETA.Seconds())
+	}
+	gauge(c.descQueuePosition, float64(info.QueuePosition))
+	gauge(c.descDesiredAvailable, float64(info.DesiredAvailable))
+	gauge(c.descLeftUntilDone, float64(info.LeftUntilDone))
+}
+
+// collectOther reports the sums of torrents above the series limit.
+// Values that cannot be summed meaningfully, such as ratios, are
+// omitted. So are counters: the set of torrents above the limit changes
+// as torrents are added and removed, so their sums could decrease,
+// which looks like a counter reset.
+func (c *torrentCollector) collectOther(ch chan<- prometheus.Metric, torrents []torrent) {
+	sums := torrentSums{
+		trackers: map[string]int{},
+		status:   map[string]int{},
+	}
+	for _, t := range torrents {
+		info := &t.info
+		sums.size += info.SizeWhenDone
+		sums.peers += info.PeersConnected
+		sums.peersUploadingTo += info.PeersGettingFromUs
+		sums.peersDownloading += info.PeersSendingToUs
+		sums.downloadRate += info.RateDownload
+		sums.uploadRate += info.RateUpload
+		sums.desiredAvailable += info.DesiredAvailable
+		sums.leftUntilDone += info.LeftUntilDone
+		for _, tr := range info.TrackerStats {
+			sums.trackers[tr.Host]++
+		}
+		sums.status[info.Status.String()]++
+	}
+
+	extra := make([]string, len(c.opts.Labels))
+	labels := func(ls ...string) []string {
+		out := append([]string{otherTorrent}, ls...)
+		return append(out, extra...)
+	}
+	gauge := func(desc *prometheus.Desc, v int, ls ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(v), labels(ls...)...)
+	}
+	for host, n := range sums.trackers {
+		gauge(c.descTrackers, n, host)
+	}
+	for status, n := range sums.status {
+		gauge(c.descStatus, n, status)
+	}
+	gauge(c.descSize, sums.size)
+	gauge(c.descPeersUploadingTo, sums.peersUploadingTo)
+	gauge(c.descPeersDownloading, sums.peersDownloading)
+	gauge(c.descPeers, sums.peers)
+	gauge(c.descDownloadRate, sums.downloadRate)
+	gauge(c.descUploadRate, sums.uploadRate)
+	gauge(c.descDesiredAvailable, sums.desiredAvailable)
+	gauge(c.descLeftUntilDone, sums.leftUntilDone)
+}
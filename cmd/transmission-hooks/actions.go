@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+)
+
+var torrentFields = []string{
+	"id", "hashString", "name", "percentDone", "doneDate", "downloadDir", "labels", "files",
+	"sizeWhenDone", "downloadedEver", "metadataPercentComplete", "trackers", "bandwidthPriority",
+}
+
+func complete(info *transmission.TorrentInfo) bool {
+	return info.MetadataPercentComplete == 1 && info.PercentDone == 1
+}
+
+// payload is the information about a torrent passed to webhooks.
+type payload struct {
+	ID          int       `json:"id"`
+	Hash        string    `json:"hash"`
+	Name        string    `json:"name"`
+	DownloadDir string    `json:"download_dir"`
+	Labels      []string  `json:"labels"`
+	Size        int       `json:"size"`
+	DoneDate    time.Time `json:"done_date"`
+	Files       []string  `json:"files"`
+}
+
+func (c *Config) run(cl *transmission.Client, h *Hook, info *transmission.TorrentInfo) error {
+	switch {
+	case len(h.Command) > 0:
+		session, err := cl.SessionInfo([]string{"version"})
+		if err != nil {
+			return fmt.Errorf("couldn't get daemon version: %s", err)
+		}
+		return runCommand(h.Command, h.timeout(), session.Version, info)
+	case h.Link != nil:
+		return c.link(h.Link, info)
+	case h.Webhook != nil:
+		return postWebhook(h.Webhook, h.timeout(), info)
+	case h.Move != "":
+		return cl.MoveTorrent([]string{info.Hash}, h.Move, true)
+	}
+	return nil
+}
+
+// runCommand runs a command with the same environment variables as
+// Transmission's done script, killing it after timeout. version is the
+// daemon's version string, of which only the version number is passed
+// on, like the daemon does.
+func runCommand(args []string, timeout time.Duration, version string, info *transmission.TorrentInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if f := strings.Fields(version); len(f) > 0 {
+		version = f[0]
+	}
+	trackers := make([]string, len(info.Trackers))
+	for i, tr := range info.Trackers {
+		trackers[i] = tr.Announce
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"TR_APP_VERSION="+version,
+		"TR_TORRENT_ID="+strconv.Itoa(info.ID),
+		"TR_TORRENT_HASH="+info.Hash,
+		"TR_TORRENT_NAME="+info.Name,
+		"TR_TORRENT_DIR="+info.DownloadDir,
+		"TR_TORRENT_LABELS="+strings.Join(info.Labels, ","),
+		"TR_TORRENT_BYTES_DOWNLOADED="+strconv.Itoa(info.DownloadedEver),
+		"TR_TORRENT_TRACKERS="+strings.Join(trackers, ","),
+		"TR_TORRENT_PRIORITY="+strconv.Itoa(int(info.BandwidthPriority)),
+		"TR_TIME_LOCALTIME="+time.Now().Format(time.ANSIC),
+	)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("killed after %s", timeout)
+	}
+	if err != nil {
+		out = bytes.TrimSpace(out)
+		if len(out) > 0 {
+			return fmt.Errorf("%s: %s", err, out)
+		}
+		return err
+	}
+	return nil
+}
+
+func postWebhook(wh *Webhook, timeout time.Duration, info *transmission.TorrentInfo) error {
+	p := payload{
+		ID:          info.ID,
+		Hash:        info.Hash,
+		Name:        info.Name,
+		DownloadDir: info.DownloadDir,
+		Labels:      info.Labels,
+		Size:        info.SizeWhenDone,
+		DoneDate:    info.DoneDate,
+	}
+	for _, f := range info.Files {
+		p.Files = append(p.Files, f.Name)
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wh.Headers {
+		req.Header.Set(k, v)
+	}
+	hc := &http.Client{Timeout: timeout}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// link hardlinks or copies the completed files of a torrent into a
+// directory, preserving their paths within the torrent.
+func (c *Config) link(l *Link, info *transmission.TorrentInfo) error {
+	src := c.localPath(info.DownloadDir)
+	for _, f := range info.Files {
+		if f.BytesCompleted != f.Length {
+			// Not wanted.
+			continue
+		}
+		from := filepath.Join(src, filepath.FromSlash(f.Name))
+		to := filepath.Join(l.Dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return err
+		}
+		if _, err := os.Lstat(to); err == nil {
+			// Already linked, possibly by an earlier, interrupted run.
+			continue
+		}
+		var err error
+		switch l.Mode {
+		case LinkHardlink:
+			err = os.Link(from, to)
+		case LinkCopy:
+			err = copyFile(from, to)
+		case LinkHardlinkOrCopy:
+			if err = os.Link(from, to); err != nil {
+				err = copyFile(from, to)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// Copy to a temporary name, so that an interrupted copy isn't
+	// mistaken for a complete one.
+	tmp := to + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, to)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Link modes.
+const (
+	LinkHardlink       = "hardlink"
+	LinkCopy           = "copy"
+	LinkHardlinkOrCopy = "hardlink-or-copy"
+)
+
+// A Hook is an action to run once for every completed torrent. Exactly
+// one of Command, Link, Webhook and Move must be set.
+type Hook struct {
+	Name string `yaml:"name"`
+	// Labels restrict the hook to torrents that have all of the
+	// labels.
+	Labels []string `yaml:"labels"`
+
+	// Command is run with information about the torrent in TR_*
+	// environment variables, like Transmission's done script.
+	Command []string `yaml:"command"`
+	// Link links or copies the torrent's files into a directory.
+	Link *Link `yaml:"link"`
+	// Webhook posts information about the torrent as JSON to a URL.
+	Webhook *Webhook `yaml:"webhook"`
+	// Move moves the torrent's data to a new location on the daemon.
+	Move string `yaml:"move"`
+
+	// Timeout bounds the runtime of commands and webhooks, defaulting
+	// to DefaultCommandTimeout and DefaultWebhookTimeout. Commands
+	// are killed once it expires.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Default timeouts of hooks.
+const (
+	DefaultCommandTimeout = 10 * time.Minute
+	DefaultWebhookTimeout = 30 * time.Second
+)
+
+func (h *Hook) timeout() time.Duration {
+	switch {
+	case h.Timeout > 0:
+		return h.Timeout
+	case h.Webhook != nil:
+		return DefaultWebhookTimeout
+	default:
+		return DefaultCommandTimeout
+	}
+}
+
+type Link struct {
+	Dir string `yaml:"dir"`
+	// Mode is one of the Link constants, defaulting to
+	// LinkHardlinkOrCopy.
+	Mode string `yaml:"mode"`
+}
+
+type Webhook struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+type Config struct {
+	// State is the file in which completed hooks are recorded.
+	State string `yaml:"state"`
+	// RemotePrefix and LocalPrefix translate the daemon's paths into
+	// local ones, for when the files are mounted at a different
+	// location than on the daemon's host.
+	RemotePrefix string  `yaml:"remote_prefix"`
+	LocalPrefix  string  `yaml:"local_prefix"`
+	Hooks        []*Hook `yaml:"hooks"`
+}
+
+func loadConfig(file string) (*Config, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", file, err)
+	}
+	if c.State == "" {
+		return nil, fmt.Errorf("%s: no state file", file)
+	}
+	if len(c.Hooks) == 0 {
+		return nil, fmt.Errorf("%s: no hooks", file)
+	}
+	names := map[string]bool{}
+	for i, h := range c.Hooks {
+		if h.Name == "" {
+			return nil, fmt.Errorf("%s: hook #%d has no name", file, i+1)
+		}
+		if names[h.Name] {
+			return nil, fmt.Errorf("%s: duplicate hook %s", file, h.Name)
+		}
+		names[h.Name] = true
+		n := 0
+		if len(h.Command) > 0 {
+			n++
+		}
+		if h.Link != nil {
+			n++
+			switch h.Link.Mode {
+			case "":
+				h.Link.Mode = LinkHardlinkOrCopy
+			case LinkHardlink, LinkCopy, LinkHardlinkOrCopy:
+			default:
+				return nil, fmt.Errorf("%s: hook %s: unknown link mode %q", file, h.Name, h.Link.Mode)
+			}
+			if h.Link.Dir == "" {
+				return nil, fmt.Errorf("%s: hook %s: link without directory", file, h.Name)
+			}
+		}
+		if h.Webhook != nil {
+			n++
+			if h.Webhook.URL == "" {
+				return nil, fmt.Errorf("%s: hook %s: webhook without URL", file, h.Name)
+			}
+		}
+		if h.Move != "" {
+			n++
+		}
+		if h.Timeout < 0 {
+			return nil, fmt.Errorf("%s: hook %s: negative timeout", file, h.Name)
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("%s: hook %s must have exactly one of command, link, webhook and move", file, h.Name)
+		}
+	}
+	return &c, nil
+}
+
+// localPath translates a path on the daemon's host into a local one.
+func (c *Config) localPath(p string) string {
+	if c.RemotePrefix == "" {
+		return p
+	}
+	p = path.Clean(p)
+	prefix := path.Clean(c.RemotePrefix)
+	if p == prefix || strings.HasPrefix(p, prefix+"/") {
+		return c.LocalPrefix + strings.TrimPrefix(p, prefix)
+	}
+	return p
+}
+
+func (h *Hook) matches(info *transmission.TorrentInfo) bool {
+	for _, want := range h.Labels {
+		found := false
+		for _, l := range info.Labels {
+			if l == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+// Command transmission-hooks runs actions when torrents of a
+// Transmission daemon complete, such as running commands, linking files
+// into a library, calling webhooks or moving data. Unlike the daemon's
+// done script, it runs on the client side, supports several actions
+// and remembers which actions have run, so that each runs once per
+// torrent, even across restarts.
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/config"
+)
+
+var (
+	fConfig   = flag.String("config", "", "Configuration file with connection profiles")
+	fProfile  = flag.String("profile", "", "Connection profile to use")
+	fRPC      = flag.String("rpc", "", "URL of Transmission RPC endpoint, overriding the profile (default "+config.DefaultURL+")")
+	fUser     = flag.String("user", "", "Transmission username, overriding the profile")
+	fPassFile = flag.String("pass-file", "", "File to read Transmission password from, overriding the profile")
+	fHooks    = flag.String("hooks", "", "YAML file with the hooks to run")
+	fInterval = flag.Duration("interval", 30*time.Second, "How often to check for completed torrents")
+	fBackfill = flag.Bool("backfill", false, "Also run new hooks for torrents that have already completed")
+	fOnce     = flag.Bool("once", false, "Check once and exit")
+)
+
+type runner struct {
+	cl    *transmission.Client
+	cfg   *Config
+	state *state
+}
+
+// check runs the pending hooks of all completed torrents.
+func (r *runner) check() error {
+	infos, err := r.cl.TorrentInfo(nil, torrentFields)
+	if err != nil {
+		return err
+	}
+	dirty := false
+	exists := map[string]bool{}
+	for i := range infos {
+		info := &infos[i]
+		exists[info.Hash] = true
+		if !complete(info) {
+			continue
+		}
+		for _, h := range r.cfg.Hooks {
+			if r.state.done(info.Hash, h.Name) || !h.matches(info) {
+				continue
+			}
+			if err := r.cfg.run(r.cl, h, info); err != nil {
+				// Later hooks may depend on this one, for example a
+				// move after a copy, so don't run them yet.
+				log.Printf("%s: hook %s failed, will retry: %s", info.Name, h.Name, err)
+				break
+			}
+			log.Printf("%s: ran hook %s", info.Name, h.Name)
+			r.state.markDone(info.Hash, h.Name)
+			// Save after every hook, so that it doesn't run again if
+			// we crash.
+			if err := r.state.save(); err != nil {
+				return err
+			}
+		}
+	}
+	// Forget removed torrents.
+	for hash := range r.state.Done {
+		if !exists[hash] {
+			delete(r.state.Done, hash)
+			dirty = true
+		}
+	}
+	if dirty {
+		return r.state.save()
+	}
+	return nil
+}
+
+// baseline marks hooks as done for all torrents that have already
+// completed, so that new hooks don't run for the whole history.
+func (r *runner) baseline(hooks []*Hook) error {
+	infos, err := r.cl.TorrentInfo(nil, torrentFields)
+	if err != nil {
+		return err
+	}
+	for i := range infos {
+		if !complete(&infos[i]) {
+			continue
+		}
+		for _, h := range hooks {
+			r.state.markDone(infos[i].Hash, h.Name)
+		}
+	}
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+	if *fHooks == "" {
+		log.Fatal("-hooks is required")
+	}
+	cfg, err := loadConfig(*fHooks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	st, err := loadState(cfg.State)
+	if err != nil {
+		log.Fatalf("couldn't load state: %s", err)
+	}
+
+	cl, err := config.ResolveClient(*fConfig, *fProfile, config.Overrides{URL: *fRPC, Username: *fUser, PasswordFile: *fPassFile})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r := &runner{cl: cl, cfg: cfg, state: st}
+	var newHooks []*Hook
+	for _, h := range cfg.Hooks {
+		if !st.knows(h.Name) {
+			newHooks = append(newHooks, h)
+			st.Hooks = append(st.Hooks, h.Name)
+		}
+	}
+	if len(newHooks) > 0 {
+		if !*fBackfill {
+			if err := r.baseline(newHooks); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := st.save(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for {
+		if err := r.check(); err != nil {
+			log.Print(err)
+			if *fOnce {
+				log.Fatal("check failed")
+			}
+		}
+		if *fOnce {
+			return
+		}
+		time.Sleep(*fInterval)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// state records which hooks have run for which torrents.
+type state struct {
+	file string
+	// Done maps info hashes to the names of the hooks that have
+	// completed for them.
+	Done map[string][]string `json:"done"`
+	// Hooks are the names of all hooks that have been configured so
+	// far, to detect new hooks.
+	Hooks []string `json:"hooks"`
+}
+
+// loadState reads the state file, if it exists.
+func loadState(file string) (*state, error) {
+	s := &state{file: file, Done: map[string][]string{}}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if s.Done == nil {
+		s.Done = map[string][]string{}
+	}
+	return s, nil
+}
+
+func (s *state) knows(hook string) bool {
+	for _, h := range s.Hooks {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *state) done(hash, hook string) bool {
+	for _, h := range s.Done[hash] {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *state) markDone(hash, hook string) {
+	if !s.done(hash, hook) {
+		s.Done[hash] = append(s.Done[hash], hook)
+	}
+}
+
+// save writes the state file atomically, so that a crash can't leave
+// a truncated file behind.
+func (s *state) save() error {
+	b, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
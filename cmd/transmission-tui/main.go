@@ -0,0 +1,202 @@
+// Command transmission-tui is an interactive terminal interface for
+// monitoring and controlling a Transmission daemon.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"honnef.co/go/transmission/config"
+
+	"golang.org/x/term"
+)
+
+var (
+	fConfig   = flag.String("config", "", "Configuration file with connection profiles")
+	fProfile  = flag.String("profile", "", "Connection profile to use")
+	fRPC      = flag.String("rpc", "", "URL of Transmission RPC endpoint, overriding the profile (default "+config.DefaultURL+")")
+	fUser     = flag.String("user", "", "Transmission username, overriding the profile")
+	fPassFile = flag.String("pass-file", "", "File to read Transmission password from, overriding the profile")
+	fInterval = flag.Duration("interval", 2*time.Second, "How often to refresh")
+)
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	cl, err := config.ResolveClient(*fConfig, *fProfile, config.Overrides{URL: *fRPC, Username: *fUser, PasswordFile: *fPassFile})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		log.Fatal("standard input is not a terminal")
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	// Switch to the alternate screen and hide the cursor.
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	out.Flush()
+
+	u := newUI(cl, out)
+	err = u.run(readKeys(os.Stdin))
+
+	fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+	out.Flush()
+	term.Restore(fd, state)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (u *ui) run(keys <-chan string) error {
+	// All requests are made by a single worker goroutine, so that slow
+	// requests don't block the interface and the client isn't used
+	// concurrently.
+	jobs := make(chan job, 1)
+	results := make(chan jobResult, 1)
+	go func() {
+		for j := range jobs {
+			if j.action != nil {
+				results <- jobResult{action: true, err: j.action()}
+			} else {
+				results <- jobResult{refresh: u.fetch(j.detailHash)}
+			}
+		}
+	}()
+	defer close(jobs)
+
+	busy := false
+	dispatch := func() {
+		if busy || len(u.jobs) == 0 {
+			return
+		}
+		j := u.jobs[0]
+		u.jobs = u.jobs[1:]
+		if j.action == nil && u.view == viewDetail {
+			j.detailHash = u.detailHash
+		}
+		jobs <- j
+		busy = true
+	}
+
+	ticker := time.NewTicker(*fInterval)
+	defer ticker.Stop()
+	u.refresh()
+	dispatch()
+	u.render()
+	for {
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			quit, dirty := u.handleKey(key)
+			if quit {
+				return nil
+			}
+			if dirty {
+				u.refresh()
+			}
+		case res := <-results:
+			busy = false
+			if res.action {
+				if res.err != nil {
+					u.message = res.err.Error()
+				}
+				u.refresh()
+			} else {
+				u.apply(res.refresh)
+			}
+		case <-ticker.C:
+			u.refresh()
+		}
+		dispatch()
+		u.render()
+	}
+}
+
+// readKeys reads key presses from r and translates them into names
+// such as "up" or "enter". Printable characters are returned as is.
+func readKeys(r *os.File) <-chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		buf := make([]byte, 32)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			for _, key := range parseKeys(buf[:n]) {
+				ch <- key
+			}
+		}
+	}()
+	return ch
+}
+
+var escapeSequences = map[string]string{
+	"\x1b[A":  "up",
+	"\x1b[B":  "down",
+	"\x1b[C":  "right",
+	"\x1b[D":  "left",
+	"\x1b[H":  "home",
+	"\x1b[F":  "end",
+	"\x1b[5~": "pgup",
+	"\x1b[6~": "pgdn",
+	"\x1bOA":  "up",
+	"\x1bOB":  "down",
+	"\x1bOC":  "right",
+	"\x1bOD":  "left",
+}
+
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		if b[0] == 0x1b {
+			if len(b) == 1 {
+				keys = append(keys, "esc")
+				b = b[1:]
+				continue
+			}
+			matched := false
+			for seq, name := range escapeSequences {
+				if bytes.HasPrefix(b, []byte(seq)) {
+					keys = append(keys, name)
+					b = b[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				// Unknown sequence; drop the rest of the read.
+				return keys
+			}
+			continue
+		}
+		switch b[0] {
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case '\t':
+			keys = append(keys, "tab")
+		case 0x7f, 0x08:
+			keys = append(keys, "backspace")
+		case 0x03:
+			keys = append(keys, "ctrl-c")
+		default:
+			keys = append(keys, string(b[0]))
+		}
+		b = b[1:]
+	}
+	return keys
+}
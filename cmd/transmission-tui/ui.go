@@ -0,0 +1,658 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/internal/format"
+
+	"golang.org/x/term"
+)
+
+const (
+	viewList = iota
+	viewDetail
+)
+
+const (
+	paneFiles = iota
+	panePeers
+	paneTrackers
+	panePieces
+	numPanes
+)
+
+var paneNames = [numPanes]string{"Files", "Peers", "Trackers", "Pieces"}
+
+var listFields = []string{
+	"id", "hashString", "name", "status", "error", "errorString", "percentDone", "sizeWhenDone",
+	"rateDownload", "rateUpload", "eta", "uploadRatio", "peersConnected", "addedDate", "queuePosition",
+	"bandwidthPriority", "recheckProgress",
+}
+
+var detailFields = []string{
+	"id", "hashString", "name", "downloadDir", "labels", "files", "fileStats", "peers", "trackerStats",
+	"pieces", "pieceCount", "pieceSize", "haveValid", "uploadedEver", "downloadedEver", "corruptEver",
+	"comment", "isPrivate",
+}
+
+type sortKey struct {
+	name string
+	less func(a, b *transmission.TorrentInfo) bool
+}
+
+var sortKeys = []sortKey{
+	{"queue", func(a, b *transmission.TorrentInfo) bool { return a.QueuePosition < b.QueuePosition }},
+	{"name", func(a, b *transmission.TorrentInfo) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }},
+	{"status", func(a, b *transmission.TorrentInfo) bool { return a.Status < b.Status }},
+	{"done", func(a, b *transmission.TorrentInfo) bool { return a.PercentDone < b.PercentDone }},
+	{"size", func(a, b *transmission.TorrentInfo) bool { return a.SizeWhenDone < b.SizeWhenDone }},
+	{"down", func(a, b *transmission.TorrentInfo) bool { return a.RateDownload < b.RateDownload }},
+	{"up", func(a, b *transmission.TorrentInfo) bool { return a.RateUpload < b.RateUpload }},
+	{"eta", func(a, b *transmission.TorrentInfo) bool { return a.ETA < b.ETA }},
+	{"ratio", func(a, b *transmission.TorrentInfo) bool { return a.UploadRatio < b.UploadRatio }},
+	{"peers", func(a, b *transmission.TorrentInfo) bool { return a.PeersConnected < b.PeersConnected }},
+	{"added", func(a, b *transmission.TorrentInfo) bool { return a.AddedDate.Before(b.AddedDate) }},
+}
+
+// A job is performed by the worker goroutine. Jobs without an action
+// refresh the data.
+type job struct {
+	action     func() error
+	detailHash string
+}
+
+type jobResult struct {
+	action  bool
+	err     error
+	refresh refreshResult
+}
+
+type refreshResult struct {
+	torrents []transmission.TorrentInfo
+	detail   *transmission.TorrentInfo
+	err      error
+}
+
+type ui struct {
+	cl     *transmission.Client
+	poller *transmission.Poller
+	out    *bufio.Writer
+
+	// jobs are waiting for the worker.
+	jobs []job
+
+	torrents []transmission.TorrentInfo
+	sortKey  int
+	reverse  bool
+	cursor   int
+	offset   int
+	// The ID of the selected torrent, used to keep the selection
+	// stable when the order changes.
+	selectedID int
+
+	view       int
+	detailID   int
+	detailHash string
+	detail     *transmission.TorrentInfo
+	pane       int
+	paneCursor int
+	paneOffset int
+
+	message string
+	// If set, the next key press is interpreted as an answer to the
+	// confirmation prompt.
+	confirm *confirmation
+	err     error
+
+	width, height int
+}
+
+type confirmation struct {
+	prompt string
+	action func() error
+}
+
+func newUI(cl *transmission.Client, out *bufio.Writer) *ui {
+	return &ui{
+		cl: cl,
+		poller: &transmission.Poller{
+			Client:      cl,
+			Fields:      listFields,
+			FullRefresh: 30,
+		},
+		out:        out,
+		selectedID: -1,
+	}
+}
+
+// perform queues a request that acts on torrents. The data is
+// refreshed once it has completed.
+func (u *ui) perform(action func() error) {
+	u.jobs = append(u.jobs, job{action: action})
+}
+
+// refresh queues a refresh, unless one is already waiting.
+func (u *ui) refresh() {
+	for _, j := range u.jobs {
+		if j.action == nil {
+			return
+		}
+	}
+	u.jobs = append(u.jobs, job{})
+}
+
+// fetch runs on the worker goroutine. It must not touch any state other
+// than the client and the poller, which aren't used anywhere else.
+func (u *ui) fetch(detailHash string) refreshResult {
+	torrents, err := u.poller.Poll()
+	if err != nil {
+		return refreshResult{err: err}
+	}
+	res := refreshResult{torrents: torrents}
+	if detailHash != "" {
+		infos, err := u.cl.TorrentInfo([]string{detailHash}, detailFields)
+		if err != nil {
+			return refreshResult{err: err}
+		}
+		if len(infos) == 1 {
+			res.detail = &infos[0]
+		}
+	}
+	return res
+}
+
+func (u *ui) apply(res refreshResult) {
+	u.err = res.err
+	if res.err != nil {
+		return
+	}
+	u.torrents = res.torrents
+	u.sort()
+	if res.detail != nil && res.detail.ID == u.detailID {
+		u.detail = res.detail
+	}
+}
+
+func (u *ui) sort() {
+	less := sortKeys[u.sortKey].less
+	sort.SliceStable(u.torrents, func(i, j int) bool {
+		if u.reverse {
+			return less(&u.torrents[j], &u.torrents[i])
+		}
+		return less(&u.torrents[i], &u.torrents[j])
+	})
+	u.cursor = 0
+	for i := range u.torrents {
+		if u.torrents[i].ID == u.selectedID {
+			u.cursor = i
+			break
+		}
+	}
+	u.clampCursor()
+}
+
+func (u *ui) clampCursor() {
+	if u.cursor >= len(u.torrents) {
+		u.cursor = len(u.torrents) - 1
+	}
+	if u.cursor < 0 {
+		u.cursor = 0
+	}
+	if len(u.torrents) > 0 {
+		u.selectedID = u.torrents[u.cursor].ID
+	}
+}
+
+func (u *ui) selected() *transmission.TorrentInfo {
+	if u.view == viewDetail {
+		for i := range u.torrents {
+			if u.torrents[i].ID == u.detailID {
+				return &u.torrents[i]
+			}
+		}
+		return nil
+	}
+	if u.cursor < len(u.torrents) {
+		return &u.torrents[u.cursor]
+	}
+	return nil
+}
+
+// handleKey processes a key press. It reports whether the program
+// should exit and whether the data needs to be refreshed.
+func (u *ui) handleKey(key string) (quit, dirty bool) {
+	if key == "ctrl-c" {
+		return true, false
+	}
+	u.message = ""
+	if u.confirm != nil {
+		c := u.confirm
+		u.confirm = nil
+		if key == "y" || key == "Y" {
+			if u.view == viewDetail {
+				u.view = viewList
+			}
+			u.perform(c.action)
+			return false, false
+		}
+		u.message = "cancelled"
+		return false, false
+	}
+
+	if u.view == viewDetail {
+		if done, dirty := u.handleDetailKey(key); done {
+			return false, dirty
+		}
+	}
+
+	switch key {
+	case "q":
+		if u.view == viewDetail {
+			u.view = viewList
+			return false, false
+		}
+		return true, false
+	case "up", "k":
+		u.cursor--
+	case "down", "j":
+		u.cursor++
+	case "pgup":
+		u.cursor -= u.listHeight()
+	case "pgdn":
+		u.cursor += u.listHeight()
+	case "home", "g":
+		u.cursor = 0
+	case "end", "G":
+		u.cursor = len(u.torrents) - 1
+	case "enter":
+		if t := u.selected(); t != nil && u.view == viewList {
+			u.view = viewDetail
+			u.detailID = t.ID
+			u.detailHash = t.Hash
+			u.detail = nil
+			u.paneCursor, u.paneOffset = 0, 0
+			return false, true
+		}
+	case "s":
+		u.sortKey = (u.sortKey + 1) % len(sortKeys)
+		u.sort()
+		u.message = "sorting by " + sortKeys[u.sortKey].name
+	case "r":
+		u.reverse = !u.reverse
+		u.sort()
+	case "?", "h":
+		u.message = "p:start/stop v:verify d:remove D:remove+data K/J:queue up/down T/B:queue top/bottom +/-:priority s:sort r:reverse q:quit"
+	default:
+		u.torrentAction(key)
+		return false, false
+	}
+	if u.view == viewList {
+		u.clampCursor()
+	}
+	return false, false
+}
+
+// torrentAction handles keys that act on the selected torrent.
+func (u *ui) torrentAction(key string) {
+	t := u.selected()
+	if t == nil {
+		return
+	}
+	ids := []string{t.Hash}
+	cl := u.cl
+	switch key {
+	case "p", " ":
+		if t.Status == transmission.TorrentStatusStopped {
+			u.perform(func() error { return cl.StartTorrent(ids) })
+		} else {
+			u.perform(func() error { return cl.StopTorrent(ids) })
+		}
+	case "v":
+		u.perform(func() error { return cl.VerifyTorrent(ids) })
+	case "d", "D":
+		del := key == "D"
+		prompt := fmt.Sprintf("Remove %s? [y/N]", t.Name)
+		if del {
+			prompt = fmt.Sprintf("Remove %s and DELETE its data? [y/N]", t.Name)
+		}
+		u.confirm = &confirmation{prompt, func() error {
+			return cl.RemoveTorrent(ids, del)
+		}}
+	case "K":
+		u.perform(func() error { return cl.QueueMoveUp(ids) })
+	case "J":
+		u.perform(func() error { return cl.QueueMoveDown(ids) })
+	case "T":
+		u.perform(func() error { return cl.QueueMoveTop(ids) })
+	case "B":
+		u.perform(func() error { return cl.QueueMoveBottom(ids) })
+	case "+", "-":
+		p := t.BandwidthPriority
+		if key == "+" && p < transmission.PriorityHigh {
+			p++
+		} else if key == "-" && p > transmission.PriorityLow {
+			p--
+		}
+		u.perform(func() error {
+			return cl.SetTorrent(ids, &transmission.TorrentSettings{BandwidthPriority: &p})
+		})
+	}
+}
+
+// handleDetailKey handles keys specific to the detail view. It
+// reports whether the key was consumed.
+func (u *ui) handleDetailKey(key string) (done, dirty bool) {
+	switch key {
+	case "esc", "backspace":
+		u.view = viewList
+		return true, false
+	case "tab", "right", "l":
+		u.pane = (u.pane + 1) % numPanes
+		u.paneCursor, u.paneOffset = 0, 0
+		return true, false
+	case "left", "H":
+		u.pane = (u.pane + numPanes - 1) % numPanes
+		u.paneCursor, u.paneOffset = 0, 0
+		return true, false
+	case "up", "k":
+		u.paneCursor--
+	case "down", "j":
+		u.paneCursor++
+	case "pgup":
+		u.paneCursor -= u.paneHeight()
+	case "pgdn":
+		u.paneCursor += u.paneHeight()
+	case "+", "-", "w":
+		if u.pane != paneFiles || u.detail == nil || len(u.detail.Files) == 0 {
+			return false, false
+		}
+		u.fileAction(key)
+		return true, false
+	default:
+		return false, false
+	}
+	return true, false
+}
+
+func (u *ui) fileAction(key string) {
+	d := u.detail
+	i := u.paneCursor
+	if i < 0 || i >= len(d.FileStats) {
+		return
+	}
+	fs := d.FileStats[i]
+	var s transmission.TorrentSettings
+	switch key {
+	case "w":
+		if fs.Wanted {
+			s.FilesUnwanted = []int{i}
+		} else {
+			s.FilesWanted = []int{i}
+		}
+	case "+":
+		if fs.Priority == transmission.PriorityLow {
+			s.PriorityNormal = []int{i}
+		} else {
+			s.PriorityHigh = []int{i}
+		}
+	case "-":
+		if fs.Priority == transmission.PriorityHigh {
+			s.PriorityNormal = []int{i}
+		} else {
+			s.PriorityLow = []int{i}
+		}
+	}
+	cl, ids := u.cl, []string{d.Hash}
+	u.perform(func() error { return cl.SetTorrent(ids, &s) })
+}
+
+func (u *ui) listHeight() int {
+	// Title, header and status line
+	return u.height - 3
+}
+
+func (u *ui) paneHeight() int {
+	// Title, summary, tabs and status line
+	return u.height - 8
+}
+
+func (u *ui) render() {
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || w <= 0 || h <= 0 {
+		w, h = 80, 24
+	}
+	u.width, u.height = w, h
+
+	var lines []string
+	if u.view == viewDetail {
+		lines = u.renderDetail()
+	} else {
+		lines = u.renderList()
+	}
+
+	status := u.message
+	if u.confirm != nil {
+		status = u.confirm.prompt
+	} else if u.err != nil {
+		status = "error: " + u.err.Error()
+	} else if status == "" {
+		status = "?: help"
+	}
+	for len(lines) < h-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:h-1], "\x1b[7m"+pad(status, w)+"\x1b[0m")
+
+	fmt.Fprint(u.out, "\x1b[H")
+	for i, line := range lines {
+		fmt.Fprint(u.out, line, "\x1b[K")
+		if i < len(lines)-1 {
+			fmt.Fprint(u.out, "\r\n")
+		}
+	}
+	u.out.Flush()
+}
+
+func (u *ui) title() string {
+	var down, up int
+	for _, t := range u.torrents {
+		down += t.RateDownload
+		up += t.RateUpload
+	}
+	return fmt.Sprintf("\x1b[1m%s\x1b[0m  %d torrents  ↓ %s  ↑ %s  sort: %s",
+		u.cl.Endpoint, len(u.torrents), format.Rate(down), format.Rate(up), sortKeys[u.sortKey].name)
+}
+
+func (u *ui) renderList() []string {
+	lines := []string{u.title()}
+	nameWidth := u.width - 76
+	if nameWidth < 10 {
+		nameWidth = 10
+	}
+	row := func(id, name, status, done, size, down, up, eta, ratio, peers string) string {
+		return fmt.Sprintf("%5s %s %-18s %6s %9s %11s %11s %8s %6s %5s",
+			id, pad(name, nameWidth), trunc(status, 18), done, size, down, up, eta, ratio, peers)
+	}
+	lines = append(lines, "\x1b[1m"+row("ID", "Name", "Status", "Done", "Size", "Down", "Up", "ETA", "Ratio", "Peers")+"\x1b[0m")
+
+	height := u.listHeight()
+	if u.cursor < u.offset {
+		u.offset = u.cursor
+	}
+	if u.cursor >= u.offset+height {
+		u.offset = u.cursor - height + 1
+	}
+	for i := u.offset; i < len(u.torrents) && i < u.offset+height; i++ {
+		t := &u.torrents[i]
+		status := t.Status.String()
+		if t.Error != 0 {
+			status = "error"
+		} else if t.Status == transmission.TorrentStatusCheck {
+			status = fmt.Sprintf("checking %.0f%%", t.RecheckProgress*100)
+		}
+		line := row(
+			fmt.Sprint(t.ID), t.Name, status,
+			fmt.Sprintf("%.1f%%", t.PercentDone*100),
+			format.Bytes(int64(t.SizeWhenDone)),
+			format.Rate(t.RateDownload),
+			format.Rate(t.RateUpload),
+			format.ETA(t.ETA),
+			format.Ratio(t.UploadRatio),
+			fmt.Sprint(t.PeersConnected),
+		)
+		if i == u.cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (u *ui) renderDetail() []string {
+	lines := []string{u.title()}
+	t := u.selected()
+	d := u.detail
+	if t == nil || d == nil {
+		return append(lines, "loading…")
+	}
+	status := t.Status.String()
+	if t.Error != 0 {
+		status = "error: " + t.ErrorString
+	}
+	lines = append(lines,
+		"\x1b[1m"+trunc(t.Name, u.width)+"\x1b[0m",
+		fmt.Sprintf("%s, %.1f%% of %s, ↓ %s ↑ %s, ETA %s, ratio %s, %s priority",
+			status, t.PercentDone*100, format.Bytes(int64(t.SizeWhenDone)), format.Rate(t.RateDownload),
+			format.Rate(t.RateUpload), format.ETA(t.ETA), format.Ratio(t.UploadRatio), t.BandwidthPriority),
+		fmt.Sprintf("Location: %s  Labels: %s  Private: %t", d.DownloadDir, strings.Join(d.Labels, ","), d.IsPrivate),
+		fmt.Sprintf("Hash: %s  Downloaded: %s  Uploaded: %s  Corrupt: %s",
+			d.Hash, format.Bytes(int64(d.DownloadedEver)), format.Bytes(int64(d.UploadedEver)), format.Bytes(int64(d.CorruptEver))),
+	)
+	var tabs []string
+	for i, name := range paneNames {
+		if i == u.pane {
+			name = "\x1b[7m " + name + " \x1b[0m"
+		} else {
+			name = " " + name + " "
+		}
+		tabs = append(tabs, name)
+	}
+	lines = append(lines, strings.Join(tabs, " "), "")
+
+	var rows []string
+	switch u.pane {
+	case paneFiles:
+		for i, f := range d.Files {
+			prio, wanted := "", "yes"
+			if i < len(d.FileStats) {
+				prio = d.FileStats[i].Priority.String()
+				if !d.FileStats[i].Wanted {
+					wanted = "no"
+				}
+			}
+			done := 0.0
+			if f.Length > 0 {
+				done = float64(f.BytesCompleted) / float64(f.Length) * 100
+			}
+			rows = append(rows, fmt.Sprintf("%6.1f%% %9s %-6s %-3s %s", done, format.Bytes(int64(f.Length)), prio, wanted, f.Name))
+		}
+	case panePeers:
+		for _, p := range d.Peers {
+			rows = append(rows, fmt.Sprintf("%-40s %-24s %6.1f%% ↓ %11s ↑ %11s %s",
+				trunc(p.Address, 40), trunc(p.ClientName, 24), p.Progress*100,
+				format.Rate(p.RateToClient), format.Rate(p.RateToPeer), p.FlagStr))
+		}
+	case paneTrackers:
+		for _, tr := range d.TrackerStats {
+			rows = append(rows, fmt.Sprintf("tier %d  %-40s %s  seeders %d  leechers %d  %s",
+				tr.Tier, trunc(tr.Host, 40), tr.AnnounceState, tr.SeederCount, tr.LeecherCount, tr.LastAnnounceResult))
+		}
+	case panePieces:
+		rows = pieceMap(d.Pieces, d.PieceCount, u.width, u.paneHeight())
+	}
+
+	height := u.paneHeight()
+	if u.pane == panePieces {
+		u.paneCursor = -1
+	} else {
+		if u.paneCursor >= len(rows) {
+			u.paneCursor = len(rows) - 1
+		}
+		if u.paneCursor < 0 {
+			u.paneCursor = 0
+		}
+		if u.paneCursor < u.paneOffset {
+			u.paneOffset = u.paneCursor
+		}
+		if u.paneCursor >= u.paneOffset+height {
+			u.paneOffset = u.paneCursor - height + 1
+		}
+	}
+	for i := u.paneOffset; i < len(rows) && i < u.paneOffset+height; i++ {
+		line := trunc(rows[i], u.width)
+		if i == u.paneCursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// pieceMap renders the bitfield as a grid of at most width×height
+// cells. If there are more pieces than cells, each cell covers several
+// pieces and shows how many of them are present.
+func pieceMap(pieces transmission.Bitfield, n, width, height int) []string {
+	if n == 0 || width <= 0 || height <= 0 {
+		return nil
+	}
+	cells := n
+	if cells > width*height {
+		cells = width * height
+	}
+	shades := []rune(" ░▒▓█")
+	var rows []string
+	var sb strings.Builder
+	for c := 0; c < cells; c++ {
+		lo, hi := c*n/cells, (c+1)*n/cells
+		have := 0
+		for i := lo; i < hi; i++ {
+			if pieces.Has(i) {
+				have++
+			}
+		}
+		shade := shades[0]
+		if have > 0 {
+			shade = shades[1+(have*(len(shades)-2))/(hi-lo)]
+		}
+		sb.WriteRune(shade)
+		if (c+1)%width == 0 {
+			rows = append(rows, sb.String())
+			sb.Reset()
+		}
+	}
+	if sb.Len() > 0 {
+		rows = append(rows, sb.String())
+	}
+	return rows
+}
+
+func trunc(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 1 {
+		return string([]rune(s)[:n])
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+func pad(s string, n int) string {
+	s = trunc(s, n)
+	return s + strings.Repeat(" ", n-utf8.RuneCountInString(s))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/metainfo"
+)
+
+// torrentFile returns whether name is a file that should be ingested.
+func torrentFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".torrent" || ext == ".magnet"
+}
+
+// ingest adds a torrent or magnet file to the daemon, according to the
+// folder's rules.
+func ingest(cl *transmission.Client, f *Folder, file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	prio, _ := f.priority()
+	req := &transmission.NewTorrent{
+		DownloadDir: f.DownloadDir,
+		// Labels can only be set once the torrent has been added; keep
+		// it paused until then, so that it doesn't transfer without
+		// them.
+		Paused:            f.Paused || len(f.Labels) > 0,
+		BandwidthPriority: prio,
+	}
+
+	var trackers []string
+	if strings.ToLower(filepath.Ext(file)) == ".magnet" {
+		link := string(bytes.TrimSpace(b))
+		if i := strings.IndexAny(link, "\r\n"); i >= 0 {
+			link = link[:i]
+		}
+		u, err := url.Parse(link)
+		if err != nil || u.Scheme != "magnet" {
+			return "", fmt.Errorf("not a magnet link")
+		}
+		req.Filename = link
+		trackers = u.Query()["tr"]
+	} else {
+		mi, err := metainfo.Parse(b)
+		if err != nil {
+			return "", err
+		}
+		req.Metainfo = base64.StdEncoding.EncodeToString(b)
+		files := mi.Info.FileList()
+		for i, fi := range files {
+			p := fi.Path
+			if mi.Info.Files != nil {
+				p = append([]string{mi.Info.Name}, p...)
+			}
+			if f.excluded(p) {
+				req.FilesUnwanted = append(req.FilesUnwanted, i)
+			}
+		}
+		if len(req.FilesUnwanted) == len(files) {
+			return "", fmt.Errorf("all files are excluded")
+		}
+		for _, tier := range mi.Trackers() {
+			trackers = append(trackers, tier...)
+		}
+	}
+
+	added, dup, err := cl.AddTorrent(req)
+	if err != nil {
+		return "", err
+	}
+	if !dup {
+		if len(f.Labels) > 0 {
+			// The torrent is in the daemon, so the file has been
+			// processed even if the following fails; retrying it would
+			// only find a duplicate.
+			ids := []string{added.Hash}
+			if err := cl.SetTorrent(ids, &transmission.TorrentSettings{Labels: f.Labels}); err != nil {
+				return fmt.Sprintf("added %s, but couldn't set labels, leaving it stopped: %s", added.Name, err), nil
+			}
+			if !f.Paused {
+				if err := cl.StartTorrent(ids); err != nil {
+					return fmt.Sprintf("added %s, but couldn't start it: %s", added.Name, err), nil
+				}
+			}
+		}
+		return fmt.Sprintf("added %s", added.Name), nil
+	}
+
+	switch f.Duplicate {
+	case DuplicateFail:
+		return "", fmt.Errorf("duplicate of %s (%s)", added.Name, added.Hash)
+	case DuplicateIgnore:
+		return fmt.Sprintf("ignored duplicate of %s", added.Name), nil
+	}
+	n, err := mergeTrackers(cl, added.Hash, trackers)
+	if err != nil {
+		return "", fmt.Errorf("duplicate of %s, couldn't merge trackers: %s", added.Name, err)
+	}
+	return fmt.Sprintf("merged %d trackers into %s", n, added.Name), nil
+}
+
+// mergeTrackers adds the trackers that the torrent doesn't have yet.
+func mergeTrackers(cl *transmission.Client, hash string, trackers []string) (int, error) {
+	infos, err := cl.TorrentInfo([]string{hash}, []string{"id", "trackers"})
+	if err != nil {
+		return 0, err
+	}
+	if len(infos) == 0 {
+		return 0, fmt.Errorf("torrent disappeared")
+	}
+	have := map[string]bool{}
+	for _, tr := range infos[0].Trackers {
+		have[tr.Announce] = true
+	}
+	var add []string
+	for _, tr := range trackers {
+		if !have[tr] {
+			have[tr] = true
+			add = append(add, tr)
+		}
+	}
+	if len(add) == 0 {
+		return 0, nil
+	}
+	return len(add), cl.SetTorrent([]string{hash}, &transmission.TorrentSettings{TrackerAdd: add})
+}
+
+// transient reports whether err is a failure to reach the daemon, in
+// which case the file should be retried later.
+func transient(err error) bool {
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+// archive moves a processed file into the done or failed subdirectory
+// of its folder. For failures, the error is written to a sidecar file
+// next to it.
+func archive(file string, subdir string, ingestErr error) error {
+	dir := filepath.Join(filepath.Dir(file), subdir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, filepath.Base(file))
+	if _, err := os.Stat(dst); err == nil {
+		ext := filepath.Ext(dst)
+		dst = fmt.Sprintf("%s.%s%s", strings.TrimSuffix(dst, ext), time.Now().Format("20060102-150405"), ext)
+	}
+	if err := os.Rename(file, dst); err != nil {
+		return err
+	}
+	if ingestErr != nil {
+		return ioutil.WriteFile(dst+".error", []byte(ingestErr.Error()+"\n"), 0644)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Command transmission-watch watches directories for .torrent and
+// .magnet files and adds them to a Transmission daemon, with download
+// directories, labels and file selection configured per directory.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"time"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/config"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var (
+	fConfig    = flag.String("config", "", "Configuration file with connection profiles")
+	fProfile   = flag.String("profile", "", "Connection profile to use")
+	fRPC       = flag.String("rpc", "", "URL of Transmission RPC endpoint, overriding the profile (default "+config.DefaultURL+")")
+	fUser      = flag.String("user", "", "Transmission username, overriding the profile")
+	fPassFile  = flag.String("pass-file", "", "File to read Transmission password from, overriding the profile")
+	fRules     = flag.String("rules", "", "YAML file with the watched folders and their rules")
+	fPoll      = flag.Duration("poll", 30*time.Second, "How often to scan the folders, in addition to inotify")
+	fNoInotify = flag.Bool("no-inotify", false, "Don't use inotify, only poll")
+	fSettle    = flag.Duration("settle", 2*time.Second, "How long a file must remain unmodified before it is processed")
+)
+
+type watcher struct {
+	cl    *transmission.Client
+	rules *Rules
+}
+
+// scan processes the files in a folder that haven't been modified
+// recently. It returns whether any files were skipped because of that.
+func (w *watcher) scan(f *Folder) (pending bool) {
+	fis, err := ioutil.ReadDir(f.Path)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || !torrentFile(fi.Name()) {
+			continue
+		}
+		if time.Since(fi.ModTime()) < *fSettle {
+			// The file may still be being written.
+			pending = true
+			continue
+		}
+		file := filepath.Join(f.Path, fi.Name())
+		msg, err := ingest(w.cl, f, file)
+		if err != nil && transient(err) {
+			log.Printf("%s: %s; will retry", file, err)
+			continue
+		}
+		subdir := "done"
+		if err != nil {
+			subdir = "failed"
+			log.Printf("%s: %s", file, err)
+		} else {
+			log.Printf("%s: %s", file, msg)
+		}
+		if err := archive(file, subdir, err); err != nil {
+			log.Printf("%s: couldn't archive: %s", file, err)
+		}
+	}
+	return pending
+}
+
+func (w *watcher) scanAll() (pending bool) {
+	for _, f := range w.rules.Folders {
+		if w.scan(f) {
+			pending = true
+		}
+	}
+	return pending
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+	if *fRules == "" {
+		log.Fatal("-rules is required")
+	}
+	rules, err := loadRules(*fRules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cl, err := config.ResolveClient(*fConfig, *fProfile, config.Overrides{URL: *fRPC, Username: *fUser, PasswordFile: *fPassFile})
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := &watcher{cl: cl, rules: rules}
+
+	var events chan fsnotify.Event
+	if !*fNoInotify {
+		fw, err := fsnotify.NewWatcher()
+		if err == nil {
+			for _, f := range rules.Folders {
+				if err = fw.Add(f.Path); err != nil {
+					fw.Close()
+					break
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("couldn't watch folders, falling back to polling: %s", err)
+		} else {
+			events = fw.Events
+			go func() {
+				for err := range fw.Errors {
+					log.Printf("watch error: %s", err)
+				}
+			}()
+		}
+	}
+
+	poll := time.NewTicker(*fPoll)
+	defer poll.Stop()
+	// settle fires when files that were skipped because they were
+	// still being written should be looked at again.
+	settle := time.NewTimer(0)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) != 0 && torrentFile(ev.Name) {
+				settle.Reset(*fSettle)
+			}
+			continue
+		case <-poll.C:
+		case <-settle.C:
+		}
+		if w.scanAll() {
+			settle.Reset(*fSettle)
+		}
+	}
+}
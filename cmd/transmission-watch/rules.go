@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"honnef.co/go/transmission"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Duplicate handling.
+const (
+	// DuplicateMergeTrackers adds the trackers of the new torrent to
+	// the existing one.
+	DuplicateMergeTrackers = "merge-trackers"
+	// DuplicateIgnore leaves the existing torrent alone.
+	DuplicateIgnore = "ignore"
+	// DuplicateFail treats duplicates as failures.
+	DuplicateFail = "fail"
+)
+
+// A Folder is a watched directory and the rules for torrents dropped
+// into it.
+type Folder struct {
+	Path        string   `yaml:"path"`
+	DownloadDir string   `yaml:"download_dir"`
+	Labels      []string `yaml:"labels"`
+	Paused      bool     `yaml:"paused"`
+	// Priority is low, normal or high.
+	Priority string `yaml:"priority"`
+	// Exclude are patterns, as in path.Match, of files not to
+	// download. They are matched against the slash-separated path of
+	// each file within the torrent, and against its base name. They
+	// only apply to .torrent files, as the files of magnet links are
+	// not known yet.
+	Exclude []string `yaml:"exclude"`
+	// Duplicate is one of the Duplicate constants, defaulting to
+	// DuplicateMergeTrackers.
+	Duplicate string `yaml:"duplicate"`
+}
+
+type Rules struct {
+	Folders []*Folder `yaml:"folders"`
+}
+
+func loadRules(file string) (*Rules, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var r Rules
+	if err := yaml.UnmarshalStrict(b, &r); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", file, err)
+	}
+	if len(r.Folders) == 0 {
+		return nil, fmt.Errorf("%s: no folders", file)
+	}
+	for _, f := range r.Folders {
+		if f.Path == "" {
+			return nil, fmt.Errorf("%s: folder without path", file)
+		}
+		f.Path = filepath.Clean(f.Path)
+		if _, err := f.priority(); err != nil {
+			return nil, fmt.Errorf("%s: folder %s: %s", file, f.Path, err)
+		}
+		for _, pat := range f.Exclude {
+			if _, err := path.Match(pat, ""); err != nil {
+				return nil, fmt.Errorf("%s: folder %s: invalid pattern %q", file, f.Path, pat)
+			}
+		}
+		switch f.Duplicate {
+		case "":
+			f.Duplicate = DuplicateMergeTrackers
+		case DuplicateMergeTrackers, DuplicateIgnore, DuplicateFail:
+		default:
+			return nil, fmt.Errorf("%s: folder %s: unknown duplicate handling %q", file, f.Path, f.Duplicate)
+		}
+	}
+	return &r, nil
+}
+
+func (f *Folder) priority() (transmission.Priority, error) {
+	switch f.Priority {
+	case "", "normal":
+		return transmission.PriorityNormal, nil
+	case "low":
+		return transmission.PriorityLow, nil
+	case "high":
+		return transmission.PriorityHigh, nil
+	default:
+		return 0, fmt.Errorf("unknown priority %q", f.Priority)
+	}
+}
+
+func (f *Folder) excluded(p []string) bool {
+	full := strings.Join(p, "/")
+	for _, pat := range f.Exclude {
+		if ok, _ := path.Match(pat, full); ok {
+			return true
+		}
+		if ok, _ := path.Match(pat, p[len(p)-1]); ok {
+			return true
+		}
+	}
+	return false
+}
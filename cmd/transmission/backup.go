@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/backup"
+)
+
+func cmdBackup(cl *transmission.Client, args []string) error {
+	var maps dirMappings
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.Var(&maps, "map", "Map the daemon's paths of torrent files to local ones, as `from=to`; may be repeated")
+	magnet := fs.Bool("magnet", false, "Fall back to magnet links if torrent files can't be read")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	opts := backup.CreateOptions{Magnet: *magnet}
+	if len(maps) > 0 {
+		opts.ReadFile = func(p string) ([]byte, error) {
+			return ioutil.ReadFile(filepath.FromSlash(maps.apply(p)))
+		}
+	}
+	b, err := backup.Create(cl, opts)
+	if err != nil {
+		if !*magnet {
+			err = fmt.Errorf("%s; use -map if the daemon runs on another host, or -magnet to back up torrents by their magnet links", err)
+		}
+		return err
+	}
+	f, err := os.Create(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := b.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	magnets := 0
+	for _, t := range b.Torrents {
+		if t.Metainfo == nil {
+			magnets++
+		}
+	}
+	if magnets > 0 {
+		fmt.Fprintf(os.Stderr, "backed up %d torrents, %d of them only by magnet link because their torrent files couldn't be read\n", len(b.Torrents), magnets)
+	}
+	return nil
+}
+
+func cmdRestore(cl *transmission.Client, args []string) error {
+	var maps dirMappings
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	noSession := fs.Bool("no-session", false, "Don't restore session settings")
+	overwrite := fs.Bool("overwrite", false, "Apply backed up settings to torrents that already exist")
+	fs.Var(&maps, "map", "Map download directories, as `from=to`; may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := backup.Read(f)
+	if err != nil {
+		return err
+	}
+	opts := backup.RestoreOptions{SkipSession: *noSession, Overwrite: *overwrite}
+	if len(maps) > 0 {
+		opts.MapDir = maps.apply
+	}
+	report, err := backup.Restore(cl, b, opts)
+	if report == nil {
+		return err
+	}
+
+	names := map[string]string{}
+	for _, t := range b.Torrents {
+		names[t.Hash] = t.Name
+	}
+	type row struct {
+		Hash   string
+		Name   string
+		Result string
+		Detail string `json:",omitempty"`
+	}
+	var rows []row
+	t := &table{header: []string{"Name", "Result", "Detail"}}
+	add := func(r row) {
+		rows = append(rows, r)
+		t.add(r.Name, r.Result, r.Detail)
+	}
+	for _, h := range report.Added {
+		add(row{Hash: h, Name: names[h], Result: "added"})
+	}
+	for _, c := range report.Conflicts {
+		res := "exists"
+		if c.Overwritten {
+			res = "overwritten"
+		}
+		add(row{Hash: c.Hash, Name: c.Name, Result: res, Detail: strings.Join(c.Differences, "; ")})
+	}
+	for _, fl := range report.Failures {
+		add(row{Hash: fl.Hash, Name: fl.Name, Result: "failed", Detail: fl.Err.Error()})
+	}
+	t.value = rows
+	if perr := t.print(); perr != nil {
+		return perr
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Failures) > 0 {
+		return fmt.Errorf("%d torrents couldn't be restored", len(report.Failures))
+	}
+	return nil
+}
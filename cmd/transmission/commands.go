@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/internal/format"
+)
+
+var listFields = []string{
+	"id", "hashString", "name", "status", "percentDone", "sizeWhenDone", "rateDownload", "rateUpload",
+	"eta", "uploadRatio", "labels", "error", "errorString",
+}
+
+func cmdList(cl *transmission.Client, args []string) error {
+	var (
+		infos []transmission.TorrentInfo
+		err   error
+	)
+	if len(args) == 0 {
+		infos, err = cl.TorrentInfo(nil, listFields)
+	} else {
+		infos, err = selectTorrents(cl, args, listFields)
+	}
+	if err != nil {
+		return err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	t := &table{
+		header: []string{"ID", "Hash", "Name", "Status", "Done", "Size", "Down", "Up", "ETA", "Ratio", "Labels"},
+		value:  infos,
+	}
+	for _, info := range infos {
+		status := info.Status.String()
+		if info.Error != 0 {
+			status = "error: " + info.ErrorString
+		}
+		hash := info.Hash
+		if *fOutput == "table" && len(hash) > 8 {
+			hash = hash[:8]
+		}
+		t.add(
+			strconv.Itoa(info.ID),
+			hash,
+			info.Name,
+			status,
+			formatPercent(info.PercentDone),
+			format.Bytes(int64(info.SizeWhenDone)),
+			format.Rate(info.RateDownload),
+			format.Rate(info.RateUpload),
+			format.ETA(info.ETA),
+			format.Ratio(info.UploadRatio),
+			strings.Join(info.Labels, ","),
+		)
+	}
+	return t.print()
+}
+
+func cmdInfo(cl *transmission.Client, args []string) error {
+	infos, err := selectTorrents(cl, args, transmission.AllTorrentFields)
+	if err != nil {
+		return err
+	}
+	if *fOutput == "json" {
+		return printJSON(infos)
+	}
+
+	t := &table{header: []string{"ID", "Field", "Value"}}
+	for _, info := range infos {
+		id := strconv.Itoa(info.ID)
+		add := func(k, v string) { t.add(id, k, v) }
+		add("Name", info.Name)
+		add("Hash", info.Hash)
+		add("Status", info.Status.String())
+		if info.Error != 0 {
+			add("Error", info.ErrorString)
+		}
+		add("Location", info.DownloadDir)
+		add("Labels", strings.Join(info.Labels, ","))
+		add("Done", formatPercent(info.PercentDone))
+		add("Size", format.Bytes(int64(info.TotalSize)))
+		add("Size when done", format.Bytes(int64(info.SizeWhenDone)))
+		add("Left until done", format.Bytes(int64(info.LeftUntilDone)))
+		add("Verified", format.Bytes(int64(info.HaveValid)))
+		add("Corrupt", format.Bytes(int64(info.CorruptEver)))
+		add("Downloaded", format.Bytes(int64(info.DownloadedEver)))
+		add("Uploaded", format.Bytes(int64(info.UploadedEver)))
+		add("Ratio", format.Ratio(info.UploadRatio))
+		add("Download rate", format.Rate(info.RateDownload))
+		add("Upload rate", format.Rate(info.RateUpload))
+		add("ETA", format.ETA(info.ETA))
+		add("Peers", fmt.Sprintf("%d connected, %d uploading, %d downloading",
+			info.PeersConnected, info.PeersSendingToUs, info.PeersGettingFromUs))
+		add("Pieces", fmt.Sprintf("%d of %d, %s each", info.Pieces.Count(), info.PieceCount, format.Bytes(int64(info.PieceSize))))
+		add("Priority", info.BandwidthPriority.String())
+		add("Queue position", strconv.Itoa(info.QueuePosition))
+		add("Private", strconv.FormatBool(info.IsPrivate))
+		add("Added", formatTime(info.AddedDate))
+		add("Done date", formatTime(info.DoneDate))
+		add("Last activity", formatTime(info.ActivityDate))
+		add("Seeding time", info.SecondsSeeding.String())
+		add("Comment", info.Comment)
+		add("Creator", info.Creator)
+		add("Magnet", info.MagnetLink)
+		for _, tr := range info.TrackerStats {
+			add("Tracker", fmt.Sprintf("tier %d: %s (%d seeders, %d leechers)", tr.Tier, tr.Announce, tr.SeederCount, tr.LeecherCount))
+		}
+		for i, f := range info.Files {
+			prio, wanted := "", ""
+			if i < len(info.FileStats) {
+				prio = info.FileStats[i].Priority.String()
+				if !info.FileStats[i].Wanted {
+					wanted = ", not wanted"
+				}
+			}
+			add("File", fmt.Sprintf("%d: %s (%s of %s, %s priority%s)",
+				i, f.Name, format.Bytes(int64(f.BytesCompleted)), format.Bytes(int64(f.Length)), prio, wanted))
+		}
+	}
+	return t.print()
+}
+
+func cmdAdd(cl *transmission.Client, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	paused := fs.Bool("paused", false, "Don't start the torrents")
+	dir := fs.String("dir", "", "Download directory")
+	labels := fs.String("labels", "", "Comma-separated list of labels")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	t := &table{header: []string{"ID", "Hash", "Name", "Result"}}
+	var added []transmission.AddedTorrent
+	for _, arg := range fs.Args() {
+		req := &transmission.NewTorrent{
+			DownloadDir: *dir,
+			Paused:      *paused,
+		}
+		if strings.HasPrefix(arg, "magnet:") || strings.Contains(arg, "://") {
+			req.Filename = arg
+		} else {
+			b, err := ioutil.ReadFile(arg)
+			if err != nil {
+				return err
+			}
+			req.Metainfo = base64.StdEncoding.EncodeToString(b)
+		}
+		info, dup, err := cl.AddTorrent(req)
+		if err != nil {
+			return fmt.Errorf("couldn't add %s: %s", arg, err)
+		}
+		result := "added"
+		if dup {
+			result = "duplicate"
+		}
+		added = append(added, info)
+		t.add(strconv.Itoa(info.ID), info.Hash, info.Name, result)
+		if *labels != "" && !dup {
+			if err := cl.SetTorrent([]string{info.Hash}, &transmission.TorrentSettings{Labels: strings.Split(*labels, ",")}); err != nil {
+				return fmt.Errorf("couldn't set labels of %s: %s", info.Name, err)
+			}
+		}
+	}
+	t.value = added
+	return t.print()
+}
+
+func cmdRemove(cl *transmission.Client, args []string) error {
+	fs := flag.NewFlagSet("remove", flag.ContinueOnError)
+	del := fs.Bool("delete", false, "Delete local data")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	ids, err := selectIDs(cl, fs.Args())
+	if err != nil {
+		return err
+	}
+	return cl.RemoveTorrent(ids, *del)
+}
+
+func cmdStart(cl *transmission.Client, args []string) error {
+	fs := flag.NewFlagSet("start", flag.ContinueOnError)
+	now := fs.Bool("now", false, "Bypass the queue")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	ids, err := selectIDs(cl, fs.Args())
+	if err != nil {
+		return err
+	}
+	if *now {
+		return cl.StartTorrentNow(ids)
+	}
+	return cl.StartTorrent(ids)
+}
+
+func cmdStop(cl *transmission.Client, args []string) error {
+	ids, err := selectIDs(cl, args)
+	if err != nil {
+		return err
+	}
+	return cl.StopTorrent(ids)
+}
+
+func cmdVerify(cl *transmission.Client, args []string) error {
+	ids, err := selectIDs(cl, args)
+	if err != nil {
+		return err
+	}
+	return cl.VerifyTorrent(ids)
+}
+
+func cmdMove(cl *transmission.Client, args []string) error {
+	fs := flag.NewFlagSet("move", flag.ContinueOnError)
+	find := fs.Bool("find", false, "Don't move the data, it already is in the new location")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < 2 {
+		return errUsage
+	}
+	ids, err := selectIDs(cl, fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	return cl.MoveTorrent(ids, fs.Arg(0), !*find)
+}
+
+func cmdRename(cl *transmission.Client, args []string) error {
+	fs := flag.NewFlagSet("rename", flag.ContinueOnError)
+	tmpl := fs.String("template", "", "Rename the selected torrents according to a template, such as '{title} ({year})'")
+	file := fs.Int("file", -1, "Rename the file with this index instead of a path")
+	dryRun := fs.Bool("dry-run", false, "Only print the renames a template would make")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	args = fs.Args()
+
+	if *tmpl != "" {
+		if len(args) == 0 {
+			return errUsage
+		}
+		infos, err := selectTorrents(cl, args, []string{"files"})
+		if err != nil {
+			return err
+		}
+		renames, err := transmission.RenameTemplate(infos, *tmpl)
+		if err != nil {
+			return err
+		}
+		type rename struct {
+			transmission.Rename
+			Err string `json:",omitempty"`
+		}
+		out := make([]rename, len(renames))
+		t := &table{header: []string{"ID", "Old name", "New name"}, value: out}
+		for i, r := range renames {
+			out[i].Rename = r
+			name := r.Name
+			if r.Err != nil {
+				out[i].Err = r.Err.Error()
+				name = "error: " + r.Err.Error()
+			}
+			t.add(strconv.Itoa(r.ID), r.Path, name)
+		}
+		if err := t.print(); err != nil {
+			return err
+		}
+		if *dryRun {
+			return nil
+		}
+		return cl.ApplyRenames(renames).Err()
+	}
+
+	var path, name string
+	switch {
+	case *file >= 0 && len(args) == 2:
+		name = args[1]
+	case *file < 0 && len(args) == 3:
+		path, name = args[1], args[2]
+	default:
+		return errUsage
+	}
+	ids, err := selectIDs(cl, args[:1])
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return fmt.Errorf("%q matches %d torrents, need exactly one", args[0], len(ids))
+	}
+	if *file >= 0 {
+		_, err = cl.RenameFile(ids[0], *file, name)
+	} else {
+		_, err = cl.RenamePath(ids[0], path, name)
+	}
+	return err
+}
+
+func cmdSet(cl *transmission.Client, args []string) error {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	var (
+		s       transmission.TorrentSettings
+		setFlag = map[string]bool{}
+	)
+	downLimit := fs.Int("down-limit", 0, "Download limit in KB/s, or -1 to remove the limit")
+	upLimit := fs.Int("up-limit", 0, "Upload limit in KB/s, or -1 to remove the limit")
+	peerLimit := fs.Int("peer-limit", 0, "Maximum number of peers")
+	priority := fs.String("priority", "", "Bandwidth priority: low, normal or high")
+	labels := fs.String("labels", "", "Comma-separated list of labels; empty to remove all labels")
+	ratio := fs.Float64("ratio", 0, "Seed ratio limit, or -1 to use the session's limit")
+	queue := fs.Int("queue-position", 0, "Queue position")
+	honor := fs.Bool("honor-session-limits", true, "Honor the session's speed limits")
+	trackerAdd := fs.String("tracker-add", "", "Announce URL of tracker to add")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	fs.Visit(func(f *flag.Flag) { setFlag[f.Name] = true })
+	if len(setFlag) == 0 {
+		return errUsage
+	}
+
+	if setFlag["down-limit"] {
+		limited := *downLimit >= 0
+		s.DownloadLimited = &limited
+		if limited {
+			s.DownloadLimit = downLimit
+		}
+	}
+	if setFlag["up-limit"] {
+		limited := *upLimit >= 0
+		s.UploadLimited = &limited
+		if limited {
+			s.UploadLimit = upLimit
+		}
+	}
+	if setFlag["peer-limit"] {
+		s.PeerLimit = peerLimit
+	}
+	if setFlag["priority"] {
+		var p transmission.Priority
+		switch *priority {
+		case "low":
+			p = transmission.PriorityLow
+		case "normal":
+			p = transmission.PriorityNormal
+		case "high":
+			p = transmission.PriorityHigh
+		default:
+			return fmt.Errorf("invalid priority %q", *priority)
+		}
+		s.BandwidthPriority = &p
+	}
+	if setFlag["labels"] {
+		s.Labels = []string{}
+		if *labels != "" {
+			s.Labels = strings.Split(*labels, ",")
+		}
+	}
+	if setFlag["ratio"] {
+		// Seed ratio modes: 0 = use session limit, 1 = use torrent limit
+		mode := 0
+		if *ratio >= 0 {
+			mode = 1
+			s.SeedRatioLimit = ratio
+		}
+		s.SeedRatioMode = &mode
+	}
+	if setFlag["queue-position"] {
+		s.QueuePosition = queue
+	}
+	if setFlag["honor-session-limits"] {
+		s.HonorsSessionLimits = honor
+	}
+	if setFlag["tracker-add"] {
+		s.TrackerAdd = []string{*trackerAdd}
+	}
+
+	ids, err := selectIDs(cl, fs.Args())
+	if err != nil {
+		return err
+	}
+	return cl.SetTorrent(ids, &s)
+}
+
+func cmdSession(cl *transmission.Client, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "get":
+		// Go through a map so that we can print exactly the requested
+		// fields, in the daemon's naming.
+		info, err := cl.SessionInfo(args[1:])
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(b, &m); err != nil {
+			return err
+		}
+		keys := args[1:]
+		if len(keys) == 0 {
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+		}
+		out := map[string]json.RawMessage{}
+		t := &table{header: []string{"Key", "Value"}, value: out}
+		for _, k := range keys {
+			v, ok := m[k]
+			if !ok {
+				return fmt.Errorf("unknown session field %q", k)
+			}
+			out[k] = v
+			t.add(k, string(v))
+		}
+		return t.print()
+	case "set":
+		if len(args) < 2 {
+			return errUsage
+		}
+		m := map[string]json.RawMessage{}
+		for _, arg := range args[1:] {
+			idx := strings.IndexByte(arg, '=')
+			if idx == -1 {
+				return errUsage
+			}
+			k, v := arg[:idx], arg[idx+1:]
+			if !json.Valid([]byte(v)) {
+				// Allow unquoted strings
+				b, _ := json.Marshal(v)
+				v = string(b)
+			}
+			m[k] = json.RawMessage(v)
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		var s transmission.SessionSettings
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&s); err != nil {
+			return fmt.Errorf("invalid session settings: %s", err)
+		}
+		return cl.SetSession(&s)
+	default:
+		return errUsage
+	}
+}
+
+func cmdStats(cl *transmission.Client, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	stats, err := cl.SessionStats()
+	if err != nil {
+		return err
+	}
+	t := &table{header: []string{"Statistic", "Current", "Cumulative"}, value: stats}
+	cur, cum := stats.CurrentStats, stats.CumulativeStats
+	t.add("Torrents", strconv.Itoa(stats.Torrents), "")
+	t.add("Active torrents", strconv.Itoa(stats.ActiveTorrents), "")
+	t.add("Paused torrents", strconv.Itoa(stats.PausedTorrents), "")
+	t.add("Download rate", format.Rate(stats.DownloadSpeed), "")
+	t.add("Upload rate", format.Rate(stats.UploadSpeed), "")
+	t.add("Downloaded", format.Bytes(int64(cur.DownloadedBytes)), format.Bytes(int64(cum.DownloadedBytes)))
+	t.add("Uploaded", format.Bytes(int64(cur.UploadedBytes)), format.Bytes(int64(cum.UploadedBytes)))
+	t.add("Files added", strconv.Itoa(cur.FilesAdded), strconv.Itoa(cum.FilesAdded))
+	t.add("Sessions", strconv.Itoa(cur.SessionCount), strconv.Itoa(cum.SessionCount))
+	t.add("Seconds active", strconv.Itoa(cur.SecondsActive), strconv.Itoa(cum.SecondsActive))
+	return t.print()
+}
+
+func cmdQueue(cl *transmission.Client, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	var fn func([]string) error
+	switch args[0] {
+	case "top":
+		fn = cl.QueueMoveTop
+	case "up":
+		fn = cl.QueueMoveUp
+	case "down":
+		fn = cl.QueueMoveDown
+	case "bottom":
+		fn = cl.QueueMoveBottom
+	default:
+		return errUsage
+	}
+	ids, err := selectIDs(cl, args[1:])
+	if err != nil {
+		return err
+	}
+	return fn(ids)
+}
+
+func cmdFreeSpace(cl *transmission.Client, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	var path string
+	if len(args) == 1 {
+		path = args[0]
+	} else {
+		info, err := cl.SessionInfo([]string{"download-dir"})
+		if err != nil {
+			return err
+		}
+		path = info.DownloadDir
+	}
+	n, err := cl.FreeSpace(path)
+	if err != nil {
+		return err
+	}
+	t := &table{
+		header: []string{"Path", "Free"},
+		value:  map[string]interface{}{"path": path, "size-bytes": n},
+	}
+	t.add(path, format.Bytes(n))
+	if *fOutput == "csv" {
+		t.rows[0][1] = strconv.FormatInt(n, 10)
+	}
+	return t.print()
+}
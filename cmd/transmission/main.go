@@ -0,0 +1,144 @@
+// Command transmission is a command-line client for the Transmission
+// BitTorrent daemon.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/config"
+)
+
+var (
+	fConfig   = flag.String("config", "", "Configuration file with connection profiles")
+	fProfile  = flag.String("profile", "", "Connection profile to use")
+	fRPC      = flag.String("rpc", "", "URL of Transmission RPC endpoint, overriding the profile (default "+config.DefaultURL+")")
+	fUser     = flag.String("user", "", "Transmission username, overriding the profile")
+	fPassFile = flag.String("pass-file", "", "File to read Transmission password from, overriding the profile")
+	fOutput   = flag.String("o", "table", "Output format: table, json or csv")
+)
+
+type command struct {
+	usage string
+	help  string
+	run   func(cl *transmission.Client, args []string) error
+}
+
+var commands = map[string]command{
+	"list":       {"[selector...]", "list torrents", cmdList},
+	"info":       {"selector...", "show details of torrents", cmdInfo},
+	"add":        {"[flags] file|url|magnet...", "add torrents", cmdAdd},
+	"remove":     {"[-delete] selector...", "remove torrents", cmdRemove},
+	"start":      {"[-now] selector...", "start torrents", cmdStart},
+	"stop":       {"selector...", "stop torrents", cmdStop},
+	"verify":     {"selector...", "verify torrents' local data", cmdVerify},
+	"migrate":    {"-to profile [flags] selector...", "move torrents to another daemon", cmdMigrate},
+	"move":       {"[-find] location selector...", "move torrents' data", cmdMove},
+	"rename":     {"selector path name | -file index selector name | -template template [-dry-run] selector...", "rename files or directories of torrents", cmdRename},
+	"set":        {"[flags] selector...", "change torrent settings", cmdSet},
+	"session":    {"get [field...] | set key=value...", "show or change session settings", cmdSession},
+	"stats":      {"", "show session statistics", cmdStats},
+	"queue":      {"top|up|down|bottom selector...", "move torrents in the queue", cmdQueue},
+	"backup":     {"[-map from=to] [-magnet] file", "back up session settings and torrents", cmdBackup},
+	"restore":    {"[-no-session] [-overwrite] [-map from=to] file", "restore a backup", cmdRestore},
+	"free-space": {"[path]", "show free space in a directory on the daemon's host", cmdFreeSpace},
+	"policy":     {"[-dry-run] file [selector...]", "apply a seeding policy", cmdPolicy},
+	"reconcile":  {"[-dry-run] file", "bring the daemon into the state described by a file", cmdReconcile},
+	"profiles":   {"", "list connection profiles", nil},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] command [args]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s  %s\n", name, commands[name].help)
+	}
+	fmt.Fprintf(out, "\n%s", selectorHelp)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	switch *fOutput {
+	case "table", "json", "csv":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *fOutput)
+		os.Exit(2)
+	}
+
+	if flag.Arg(0) == "profiles" {
+		if err := cmdProfiles(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cl, err := newClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := cmd.run(cl, flag.Args()[1:]); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n", os.Args[0], flag.Arg(0), cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func newClient() (*transmission.Client, error) {
+	return config.ResolveClient(*fConfig, *fProfile, config.Overrides{URL: *fRPC, Username: *fUser, PasswordFile: *fPassFile})
+}
+
+func cmdProfiles() error {
+	path := *fConfig
+	if path == "" {
+		path = os.Getenv("TRANSMISSION_CONFIG")
+	}
+	if path == "" {
+		path = config.DefaultPath()
+	}
+	if path == "" {
+		return fmt.Errorf("no configuration file found")
+	}
+	f, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	t := &table{header: []string{"Name", "Default", "Profile"}}
+	var profiles []config.Profile
+	for _, name := range f.Names() {
+		p := f.Profiles[name].Redacted()
+		profiles = append(profiles, p)
+		def := ""
+		if name == f.Default {
+			def = "*"
+		}
+		t.add(name, def, p.String())
+	}
+	t.value = profiles
+	return t.print()
+}
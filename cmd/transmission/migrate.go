@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"path"
+	"strings"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/config"
+	"honnef.co/go/transmission/migrate"
+)
+
+// dirMappings are repeatable from=to flags.
+type dirMappings [][2]string
+
+func (m *dirMappings) String() string {
+	var s []string
+	for _, p := range *m {
+		s = append(s, p[0]+"="+p[1])
+	}
+	return strings.Join(s, ",")
+}
+
+func (m *dirMappings) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i == -1 {
+		return errors.New("mapping must be of the form from=to")
+	}
+	*m = append(*m, [2]string{path.Clean(s[:i]), path.Clean(s[i+1:])})
+	return nil
+}
+
+// apply maps dir using the first matching mapping.
+func (m dirMappings) apply(dir string) string {
+	dir = path.Clean(dir)
+	for _, p := range m {
+		if dir == p[0] {
+			return p[1]
+		}
+		if strings.HasPrefix(dir, strings.TrimSuffix(p[0], "/")+"/") {
+			return path.Join(p[1], dir[len(p[0]):])
+		}
+	}
+	return dir
+}
+
+func cmdMigrate(cl *transmission.Client, args []string) error {
+	var maps dirMappings
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	to := fs.String("to", "", "Connection profile of the destination")
+	toRPC := fs.String("to-rpc", "", "URL of the destination's RPC endpoint, overriding the profile")
+	fs.Var(&maps, "map", "Map download directories, as `from=to`; may be repeated")
+	magnet := fs.Bool("magnet", false, "Fall back to magnet links if torrent files can't be read")
+	verify := fs.Bool("verify", false, "Verify data on the destination")
+	start := fs.Bool("start", false, "Start torrents on the destination")
+	remove := fs.Bool("remove", false, "Remove torrents from the source once verified on the destination; implies -verify")
+	del := fs.Bool("delete", false, "Also delete the source's data when removing torrents")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 || (*to == "" && *toRPC == "") {
+		return errUsage
+	}
+	dst, err := config.ResolveClient(*fConfig, *to, config.Overrides{URL: *toRPC})
+	if err != nil {
+		return err
+	}
+
+	ids, err := selectIDs(cl, fs.Args())
+	if err != nil {
+		return err
+	}
+	m := &migrate.Migration{
+		Source:           cl,
+		Destination:      dst,
+		AllowMagnet:      *magnet,
+		Verify:           *verify || *remove,
+		Start:            *start,
+		RemoveSource:     *remove,
+		DeleteSourceData: *del,
+	}
+	if len(maps) > 0 {
+		m.MapDir = maps.apply
+	}
+	results, err := m.Migrate(context.Background(), ids)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		migrate.Result
+		Err string `json:",omitempty"`
+	}
+	out := make([]result, len(results))
+	t := &table{header: []string{"Name", "Result"}, value: out}
+	failed := 0
+	for i, res := range results {
+		out[i].Result = res
+		var status []string
+		if res.Err != nil {
+			failed++
+			out[i].Err = res.Err.Error()
+			status = append(status, "failed: "+res.Err.Error())
+		} else {
+			if res.Duplicate {
+				status = append(status, "already on destination")
+			} else {
+				status = append(status, "added")
+			}
+			if res.Verified {
+				status = append(status, "verified")
+			}
+			if res.Removed {
+				status = append(status, "removed from source")
+			}
+		}
+		t.add(res.Name, strings.Join(status, ", "))
+	}
+	if err := t.print(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.New("some torrents couldn't be migrated")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// table is tabular output. In JSON mode, value is printed instead of
+// the rows, so that no information is lost to formatting.
+type table struct {
+	header []string
+	rows   [][]string
+	value  interface{}
+}
+
+func (t *table) add(cols ...string) {
+	t.rows = append(t.rows, cols)
+}
+
+func (t *table) print() error {
+	switch *fOutput {
+	case "json":
+		v := t.value
+		if v == nil {
+			rows := make([]map[string]string, len(t.rows))
+			for i, row := range t.rows {
+				rows[i] = map[string]string{}
+				for j, col := range row {
+					rows[i][t.header[j]] = col
+				}
+			}
+			v = rows
+		}
+		return printJSON(v)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		if err := w.Write(t.header); err != nil {
+			return err
+		}
+		if err := w.WriteAll(t.rows); err != nil {
+			return err
+		}
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(t.header, "\t"))
+		for _, row := range t.rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	}
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatPercent(f float64) string {
+	return fmt.Sprintf("%.1f%%", f*100)
+}
+
+func formatTime(t time.Time) string {
+	if t.Unix() <= 0 {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"time"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/policy"
+)
+
+func cmdPolicy(cl *transmission.Client, args []string) error {
+	fs := flag.NewFlagSet("policy", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Only print the actions the policy would take")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < 1 {
+		return errUsage
+	}
+	p, err := policy.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	sels := fs.Args()[1:]
+	if len(sels) == 0 {
+		sels = []string{"all"}
+	}
+	infos, err := selectTorrents(cl, sels, p.Fields())
+	if err != nil {
+		return err
+	}
+
+	plan, err := p.Plan(infos, time.Now())
+	if err != nil {
+		return err
+	}
+	t := &table{header: []string{"ID", "Name", "Rule", "Reason", "Action"}, value: plan}
+	for _, pl := range plan {
+		t.add(strconv.Itoa(pl.ID), pl.Name, pl.Rule, pl.Reason, pl.Action.String())
+	}
+	if err := t.print(); err != nil {
+		return err
+	}
+	if *dryRun {
+		return nil
+	}
+	return policy.Apply(cl, plan)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/reconcile"
+)
+
+func cmdReconcile(cl *transmission.Client, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Only print the changes that would be made")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	d, err := reconcile.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	plan, err := d.Plan(cl)
+	if err != nil {
+		return err
+	}
+	t := &table{header: []string{"Change", "Name", "Details"}, value: plan}
+	for _, c := range plan {
+		t.add(c.Kind, c.Name, strings.Join(c.Details, "; "))
+	}
+	if err := t.print(); err != nil {
+		return err
+	}
+	if *dryRun {
+		return nil
+	}
+	return reconcile.Apply(cl, plan)
+}
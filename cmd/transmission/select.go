@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/filter"
+)
+
+const selectorHelp = `Torrents are selected by:
+  all            all torrents
+  <number>       the torrent's ID
+  <hex>          a prefix of at least 6 characters of the torrent's info hash;
+                 numbers of at least 6 digits match both IDs and hash prefixes
+  label:<label>  torrents with the label
+  <glob>         torrents whose name matches the glob, ignoring case
+  <expression>   torrents matching a filter expression, such as
+                 'label:movies and status:seeding and ratio>2'; see the
+                 documentation of honnef.co/go/transmission/filter.
+                 Selectors without any key, such as 'My Show*', are
+                 globs.
+`
+
+var errUsage = errors.New("usage error")
+
+var selectFields = []string{"id", "hashString", "name", "labels"}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// isSimple reports whether a selector is one of the simple forms,
+// rather than a filter expression.
+func isSimple(sel string) bool {
+	sel = strings.TrimPrefix(sel, "label:")
+	return !strings.ContainsAny(sel, " \t\n:<>=()\"")
+}
+
+func matchSelector(sel string, info *transmission.TorrentInfo) (bool, error) {
+	if sel == "all" {
+		return true, nil
+	}
+	// Numbers are IDs, but long enough numbers may also be hash
+	// prefixes that happen to consist of digits only.
+	id, err := strconv.Atoi(sel)
+	numeric := err == nil
+	if numeric && info.ID == id {
+		return true, nil
+	}
+	if strings.HasPrefix(sel, "label:") {
+		label := strings.TrimPrefix(sel, "label:")
+		for _, l := range info.Labels {
+			if l == label {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if len(sel) >= 6 && isHex(sel) && strings.HasPrefix(info.Hash, strings.ToLower(sel)) {
+		return true, nil
+	}
+	if numeric {
+		return false, nil
+	}
+	ok, err := path.Match(strings.ToLower(sel), strings.ToLower(info.Name))
+	if err != nil {
+		return false, fmt.Errorf("invalid selector %q: %s", sel, err)
+	}
+	return ok, nil
+}
+
+// selectTorrents returns the torrents matching any of the selectors,
+// with the requested fields. At least one selector must be provided.
+// Every selector has to match at least one torrent.
+func selectTorrents(cl *transmission.Client, sels []string, fields []string) ([]transmission.TorrentInfo, error) {
+	if len(sels) == 0 {
+		return nil, errUsage
+	}
+	fields = append(fields[:len(fields):len(fields)], selectFields...)
+	filters := make([]*filter.Filter, len(sels))
+	// filterErrs explains why selectors that look like expressions
+	// were matched as globs.
+	filterErrs := make([]error, len(sels))
+	for i, sel := range sels {
+		if isSimple(sel) {
+			continue
+		}
+		// Names may contain spaces and colons, so selectors that only
+		// match names are still globs.
+		f, err := filter.Compile(sel)
+		if err != nil {
+			filterErrs[i] = err
+			continue
+		}
+		if !f.Keyed() {
+			continue
+		}
+		filters[i] = f
+		fields = append(fields, f.Fields()...)
+	}
+	infos, err := cl.TorrentInfo(nil, fields)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var out []transmission.TorrentInfo
+	seen := map[int]bool{}
+	for j, sel := range sels {
+		matched := false
+		for i := range infos {
+			var ok bool
+			if filters[j] != nil {
+				ok = filters[j].MatchAt(&infos[i], now)
+			} else {
+				ok, err = matchSelector(sel, &infos[i])
+				if err != nil {
+					return nil, err
+				}
+			}
+			if !ok {
+				continue
+			}
+			matched = true
+			if !seen[infos[i].ID] {
+				seen[infos[i].ID] = true
+				out = append(out, infos[i])
+			}
+		}
+		if !matched {
+			if filterErrs[j] != nil {
+				return nil, fmt.Errorf("no torrents match %q, which isn't a valid filter either: %s", sel, filterErrs[j])
+			}
+			return nil, fmt.Errorf("no torrents match %q", sel)
+		}
+	}
+	return out, nil
+}
+
+// selectIDs is like selectTorrents but only returns the torrents' hashes.
+func selectIDs(cl *transmission.Client, sels []string) ([]string, error) {
+	infos, err := selectTorrents(cl, sels, nil)
+	if err != nil {
+		return nil, err
+	}
+	return hashes(infos), nil
+}
+
+func hashes(infos []transmission.TorrentInfo) []string {
+	out := make([]string, len(infos))
+	for i, info := range infos {
+		out[i] = info.Hash
+	}
+	return out
+}
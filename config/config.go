@@ -0,0 +1,451 @@
+// Package config loads connection profiles for Transmission daemons
+// from configuration files and the environment.
+//
+// Configuration files can be written in TOML, YAML or JSON; the format
+// is chosen based on the file's extension. A file contains any number
+// of named profiles and, optionally, the name of the default profile:
+//
+//	default = "seedbox"
+//
+//	[profiles.seedbox]
+//	url = "https://seedbox.example.com/transmission/rpc"
+//	username = "admin"
+//	password_file = "/run/secrets/transmission"
+//	timeout = "30s"
+//
+//	[profiles.seedbox.tls]
+//	ca_file = "/etc/ssl/seedbox-ca.pem"
+//
+// Environment variables override the values of the selected profile:
+// TRANSMISSION_URL, TRANSMISSION_USERNAME, TRANSMISSION_PASSWORD,
+// TRANSMISSION_PASSWORD_FILE, TRANSMISSION_TIMEOUT, TRANSMISSION_PROXY,
+// TRANSMISSION_CA_FILE, TRANSMISSION_CERT_FILE, TRANSMISSION_KEY_FILE
+// and TRANSMISSION_INSECURE_SKIP_VERIFY. TRANSMISSION_CONFIG and
+// TRANSMISSION_PROFILE select the configuration file and profile.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultURL is the endpoint used by profiles that don't specify one.
+const DefaultURL = "http://localhost:9091/transmission/rpc"
+
+type File struct {
+	// The name of the profile to use if none has been requested
+	// explicitly. If empty and there is exactly one profile, that
+	// profile is the default.
+	Default  string              `json:"default" yaml:"default" toml:"default"`
+	Profiles map[string]*Profile `json:"profiles" yaml:"profiles" toml:"profiles"`
+}
+
+type Profile struct {
+	// The name of the profile, as used in the configuration file.
+	Name string `json:"name" yaml:"-" toml:"-"`
+	// The URL of the RPC endpoint. User information in the URL is
+	// used if Username isn't set.
+	URL      string `json:"url" yaml:"url" toml:"url"`
+	Username string `json:"username" yaml:"username" toml:"username"`
+	Password string `json:"password" yaml:"password" toml:"password"`
+	// A file to read the password from, if Password is empty. Trailing
+	// newlines are removed.
+	PasswordFile string `json:"password_file" yaml:"password_file" toml:"password_file"`
+	TLS          TLS    `json:"tls" yaml:"tls" toml:"tls"`
+	// The timeout for individual requests. Zero means no timeout.
+	Timeout Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	// The URL of an HTTP proxy. If empty, the proxy is taken from the
+	// standard environment variables, such as HTTPS_PROXY.
+	Proxy string `json:"proxy" yaml:"proxy" toml:"proxy"`
+}
+
+type TLS struct {
+	// A PEM file of certificate authorities to trust, in addition to
+	// the system's.
+	CAFile string `json:"ca_file" yaml:"ca_file" toml:"ca_file"`
+	// A client certificate and key, in PEM format.
+	CertFile           string `json:"cert_file" yaml:"cert_file" toml:"cert_file"`
+	KeyFile            string `json:"key_file" yaml:"key_file" toml:"key_file"`
+	ServerName         string `json:"server_name" yaml:"server_name" toml:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify" toml:"insecure_skip_verify"`
+}
+
+// Duration is a time.Duration that is written as a string, such as
+// "1m30s", in configuration files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// Load reads a configuration file.
+func Load(path string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		_, err = toml.Decode(string(b), &f)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, &f)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&f)
+	default:
+		return nil, fmt.Errorf("%s: unsupported configuration format %q", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for name, p := range f.Profiles {
+		if p == nil {
+			p = &Profile{}
+			f.Profiles[name] = p
+		}
+		p.Name = name
+	}
+	return &f, nil
+}
+
+// Profile returns the named profile, or the default profile if name is
+// empty.
+func (f *File) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = f.Default
+	}
+	if name == "" {
+		if len(f.Profiles) == 1 {
+			for _, p := range f.Profiles {
+				return p, nil
+			}
+		}
+		return nil, errors.New("no profile selected and no default profile configured")
+	}
+	p, ok := f.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+	return p, nil
+}
+
+// Names returns the names of all profiles, sorted.
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultPath returns the location of the configuration file used when
+// none has been specified, or the empty string if there is none. It
+// looks for config.toml, config.yaml, config.yml and config.json in
+// the transmission directory of the user's configuration directory.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	for _, ext := range []string{".toml", ".yaml", ".yml", ".json"} {
+		path := filepath.Join(dir, "transmission", "config"+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Resolve returns the named profile from the configuration file at
+// path, with environment variables applied. An empty path falls back
+// to TRANSMISSION_CONFIG and DefaultPath; an empty name falls back to
+// TRANSMISSION_PROFILE and the file's default profile. Without any
+// configuration file, the profile is built from the environment alone.
+func Resolve(path, name string) (*Profile, error) {
+	if path == "" {
+		path = os.Getenv("TRANSMISSION_CONFIG")
+	}
+	if path == "" {
+		path = DefaultPath()
+	}
+	if name == "" {
+		name = os.Getenv("TRANSMISSION_PROFILE")
+	}
+
+	var p Profile
+	if path != "" {
+		f, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		fp, err := f.Profile(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		p = *fp
+	} else if name != "" {
+		return nil, fmt.Errorf("profile %q requested but no configuration file found", name)
+	}
+	if err := p.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Overrides are connection settings given on the command line. They
+// take precedence over the profile and the environment. Empty fields
+// are ignored.
+type Overrides struct {
+	URL          string
+	Username     string
+	Password     string
+	PasswordFile string
+}
+
+// Override applies the overrides to the profile.
+func (p *Profile) Override(o Overrides) {
+	if o.URL != "" {
+		p.URL = o.URL
+	}
+	if o.Username != "" {
+		p.Username = o.Username
+	}
+	if o.Password != "" {
+		p.Password = o.Password
+	}
+	if o.PasswordFile != "" {
+		p.Password = ""
+		p.PasswordFile = o.PasswordFile
+	}
+}
+
+// ResolveClient resolves a profile like Resolve, applies the overrides
+// and returns a client for it.
+func ResolveClient(path, name string, o Overrides) (*transmission.Client, error) {
+	p, err := Resolve(path, name)
+	if err != nil {
+		return nil, err
+	}
+	p.Override(o)
+	return p.Client()
+}
+
+// ApplyEnv overrides the profile's settings with those set in
+// environment variables.
+func (p *Profile) ApplyEnv() error {
+	strs := map[string]*string{
+		"TRANSMISSION_URL":           &p.URL,
+		"TRANSMISSION_USERNAME":      &p.Username,
+		"TRANSMISSION_PASSWORD":      &p.Password,
+		"TRANSMISSION_PASSWORD_FILE": &p.PasswordFile,
+		"TRANSMISSION_PROXY":         &p.Proxy,
+		"TRANSMISSION_CA_FILE":       &p.TLS.CAFile,
+		"TRANSMISSION_CERT_FILE":     &p.TLS.CertFile,
+		"TRANSMISSION_KEY_FILE":      &p.TLS.KeyFile,
+	}
+	for env, ptr := range strs {
+		if v, ok := os.LookupEnv(env); ok {
+			*ptr = v
+		}
+	}
+	if _, ok := os.LookupEnv("TRANSMISSION_PASSWORD_FILE"); ok {
+		// An explicitly configured password file takes precedence
+		// over a password from the configuration file.
+		if _, ok := os.LookupEnv("TRANSMISSION_PASSWORD"); !ok {
+			p.Password = ""
+		}
+	}
+	if v, ok := os.LookupEnv("TRANSMISSION_TIMEOUT"); ok {
+		if err := p.Timeout.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("invalid TRANSMISSION_TIMEOUT: %s", err)
+		}
+	}
+	if v, ok := os.LookupEnv("TRANSMISSION_INSECURE_SKIP_VERIFY"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid TRANSMISSION_INSECURE_SKIP_VERIFY: %s", err)
+		}
+		p.TLS.InsecureSkipVerify = b
+	}
+	return nil
+}
+
+// Client returns a client for the profile.
+func (p *Profile) Client() (*transmission.Client, error) {
+	endpoint := p.URL
+	if endpoint == "" {
+		endpoint = DefaultURL
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %s", err)
+	}
+	user, pass := p.Username, p.Password
+	if u.User != nil {
+		if user == "" {
+			user = u.User.Username()
+			if pass == "" {
+				pass, _ = u.User.Password()
+			}
+		}
+		u.User = nil
+	}
+	if p.PasswordFile != "" && p.Password == "" {
+		b, err := ioutil.ReadFile(p.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read password: %s", err)
+		}
+		pass = string(bytes.TrimRight(b, "\r\n"))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if p.Proxy != "" {
+		proxy, err := url.Parse(p.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %s", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if p.TLS != (TLS{}) {
+		cfg, err := p.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = cfg
+	}
+
+	return &transmission.Client{
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(p.Timeout),
+		},
+		Endpoint: u.String(),
+		Username: user,
+		Password: pass,
+	}, nil
+}
+
+func (t TLS) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("%s: no certificates found", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the profile with secrets replaced, for
+// printing. File names aren't considered secrets.
+func (p Profile) Redacted() Profile {
+	if p.Password != "" {
+		p.Password = redacted
+	}
+	p.URL = redactURL(p.URL)
+	p.Proxy = redactURL(p.Proxy)
+	return p
+}
+
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
+
+// String returns a description of the profile, with secrets redacted.
+func (p Profile) String() string {
+	p = p.Redacted()
+	var parts []string
+	add := func(k, v string) {
+		if v != "" {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	add("name", p.Name)
+	add("url", p.URL)
+	add("username", p.Username)
+	add("password", p.Password)
+	add("password_file", p.PasswordFile)
+	add("ca_file", p.TLS.CAFile)
+	add("cert_file", p.TLS.CertFile)
+	add("key_file", p.TLS.KeyFile)
+	add("server_name", p.TLS.ServerName)
+	if p.TLS.InsecureSkipVerify {
+		add("insecure_skip_verify", "true")
+	}
+	if p.Timeout != 0 {
+		add("timeout", time.Duration(p.Timeout).String())
+	}
+	add("proxy", p.Proxy)
+	return strings.Join(parts, " ")
+}
+
+// GoString redacts secrets when printing profiles with %#v.
+func (p Profile) GoString() string {
+	type profile Profile
+	return fmt.Sprintf("%#v", profile(p.Redacted()))
+}
@@ -0,0 +1,309 @@
+// Package diskguard keeps a Transmission daemon from filling its
+// disks. It pauses downloads when the free space of the directories
+// they write to, minus the data still left to download, drops below a
+// threshold, and resumes them once enough space is available again.
+package diskguard
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"honnef.co/go/transmission"
+)
+
+// Modes of pausing torrents.
+const (
+	// ModeStop stops torrents.
+	ModeStop = "stop"
+	// ModeRequeue stops torrents and moves them to the bottom of the
+	// queue, so that they resume after the other queued downloads.
+	ModeRequeue = "requeue"
+)
+
+var torrentFields = []string{
+	"id", "hashString", "name", "status", "downloadDir", "leftUntilDone", "bandwidthPriority",
+	"queuePosition", "labels",
+}
+
+var sessionFields = []string{"download-dir", "incomplete-dir", "incomplete-dir-enabled"}
+
+type Guard struct {
+	Client *transmission.Client
+	// MinFree is the number of bytes that should remain free in every
+	// directory after all downloads have completed.
+	MinFree int64
+	// ResumeFree is the projected free space required to resume a
+	// paused torrent. It defaults to MinFree; larger values avoid
+	// pausing and resuming the same torrent over and over.
+	ResumeFree int64
+	// Mode is ModeStop or ModeRequeue, defaulting to ModeStop.
+	Mode string
+	// Label, if not empty, is added to paused torrents, so that they
+	// can be recognized after the guard has been restarted. Otherwise,
+	// only torrents paused by this Guard are resumed.
+	Label string
+	// DryRun reports the actions the guard would take, without taking
+	// them.
+	DryRun bool
+
+	paused map[string]bool
+}
+
+// Event describes a torrent that was paused or resumed.
+type Event struct {
+	Hash string
+	Name string
+	// Dir is the directory the torrent writes to.
+	Dir string
+	// Paused is true if the torrent was paused and false if it was
+	// resumed.
+	Paused bool
+	// Free is the free space in Dir, and Needed the sum of the data
+	// left to download by all active downloads writing to Dir, before
+	// the torrent was paused or resumed.
+	Free   int64
+	Needed int64
+}
+
+func (ev Event) String() string {
+	verb := "resumed"
+	if ev.Paused {
+		verb = "paused"
+	}
+	return fmt.Sprintf("%s %s: %d bytes free in %s, %d bytes needed", verb, ev.Name, ev.Free, ev.Dir, ev.Needed)
+}
+
+// directory is a directory and the torrents writing to it.
+type directory struct {
+	path    string
+	active  []transmission.TorrentInfo
+	paused  []transmission.TorrentInfo
+	needed  int64
+	free    int64
+	changes []Event
+}
+
+func isDownloading(info *transmission.TorrentInfo) bool {
+	switch info.Status {
+	case transmission.TorrentStatusDownload, transmission.TorrentStatusDownloadWait,
+		transmission.TorrentStatusCheck, transmission.TorrentStatusCheckWait:
+		return int64(info.LeftUntilDone) > 0
+	}
+	return false
+}
+
+// Check pauses or resumes torrents once and returns what it did.
+func (g *Guard) Check() ([]Event, error) {
+	if g.paused == nil {
+		g.paused = map[string]bool{}
+	}
+	session, err := g.Client.SessionInfo(sessionFields)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get session: %s", err)
+	}
+	infos, err := g.Client.TorrentInfo(nil, torrentFields)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get torrents: %s", err)
+	}
+
+	dirs := map[string]*directory{}
+	seen := map[string]bool{}
+	for _, info := range infos {
+		// Incomplete data is written to the incomplete directory, if
+		// enabled, and moved once the torrent has completed.
+		dir := info.DownloadDir
+		if session.IncompleteDirEnabled && session.IncompleteDir != "" {
+			dir = session.IncompleteDir
+		}
+		dir = path.Clean(dir)
+		d := dirs[dir]
+		if d == nil {
+			d = &directory{path: dir}
+			dirs[dir] = d
+		}
+
+		paused := g.isPaused(&info)
+		if paused && info.Status != transmission.TorrentStatusStopped {
+			// Somebody else started the torrent; leave it alone.
+			g.forget(info)
+			paused = false
+		}
+		switch {
+		case paused:
+			seen[info.Hash] = true
+			d.paused = append(d.paused, info)
+		case isDownloading(&info):
+			d.active = append(d.active, info)
+			d.needed += int64(info.LeftUntilDone)
+		}
+	}
+	for hash := range g.paused {
+		if !seen[hash] {
+			delete(g.paused, hash)
+		}
+	}
+
+	names := make([]string, 0, len(dirs))
+	for name, d := range dirs {
+		if len(d.active) > 0 || len(d.paused) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var events []Event
+	for _, name := range names {
+		d := dirs[name]
+		d.free, err = g.Client.FreeSpace(d.path)
+		if err != nil {
+			return events, fmt.Errorf("couldn't get free space of %s: %s", d.path, err)
+		}
+		evs, err := g.balance(d)
+		events = append(events, evs...)
+		if err != nil {
+			return events, err
+		}
+	}
+	return events, nil
+}
+
+// balance pauses the least important downloads writing to d until the
+// projected free space is above MinFree, or resumes the most important
+// paused ones while it stays above ResumeFree.
+func (g *Guard) balance(d *directory) ([]Event, error) {
+	var events []Event
+	if d.free-d.needed < g.MinFree {
+		// Pause low priority torrents at the end of the queue first.
+		sort.Slice(d.active, func(i, j int) bool {
+			a, b := d.active[i], d.active[j]
+			if a.BandwidthPriority != b.BandwidthPriority {
+				return a.BandwidthPriority < b.BandwidthPriority
+			}
+			return a.QueuePosition > b.QueuePosition
+		})
+		for _, info := range d.active {
+			if d.free-d.needed >= g.MinFree {
+				break
+			}
+			ev := Event{Hash: info.Hash, Name: info.Name, Dir: d.path, Paused: true, Free: d.free, Needed: d.needed}
+			if err := g.pause(info); err != nil {
+				return events, fmt.Errorf("couldn't pause %s: %s", info.Name, err)
+			}
+			d.needed -= int64(info.LeftUntilDone)
+			events = append(events, ev)
+		}
+		return events, nil
+	}
+
+	resumeFree := g.ResumeFree
+	if resumeFree < g.MinFree {
+		resumeFree = g.MinFree
+	}
+	sort.Slice(d.paused, func(i, j int) bool {
+		a, b := d.paused[i], d.paused[j]
+		if a.BandwidthPriority != b.BandwidthPriority {
+			return a.BandwidthPriority > b.BandwidthPriority
+		}
+		return a.QueuePosition < b.QueuePosition
+	})
+	for _, info := range d.paused {
+		if d.free-d.needed-int64(info.LeftUntilDone) < resumeFree {
+			// Resume torrents strictly in order, so that a small
+			// torrent doesn't overtake a more important large one.
+			break
+		}
+		ev := Event{Hash: info.Hash, Name: info.Name, Dir: d.path, Paused: false, Free: d.free, Needed: d.needed}
+		if err := g.resume(info); err != nil {
+			return events, fmt.Errorf("couldn't resume %s: %s", info.Name, err)
+		}
+		d.needed += int64(info.LeftUntilDone)
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+func (g *Guard) isPaused(info *transmission.TorrentInfo) bool {
+	if g.paused[info.Hash] {
+		return true
+	}
+	if g.Label != "" {
+		for _, l := range info.Labels {
+			if l == g.Label {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// forget removes a torrent from the set of paused torrents.
+func (g *Guard) forget(info transmission.TorrentInfo) {
+	delete(g.paused, info.Hash)
+	if g.Label != "" && !g.DryRun {
+		// Best effort; the label is removed again on the next check.
+		g.Client.SetTorrent([]string{info.Hash}, &transmission.TorrentSettings{Labels: without(info.Labels, g.Label)})
+	}
+}
+
+func (g *Guard) pause(info transmission.TorrentInfo) error {
+	g.paused[info.Hash] = true
+	if g.DryRun {
+		return nil
+	}
+	ids := []string{info.Hash}
+	if g.Label != "" {
+		labels := append(without(info.Labels, g.Label), g.Label)
+		if err := g.Client.SetTorrent(ids, &transmission.TorrentSettings{Labels: labels}); err != nil {
+			return err
+		}
+	}
+	if err := g.Client.StopTorrent(ids); err != nil {
+		return err
+	}
+	if g.Mode == ModeRequeue {
+		return g.Client.QueueMoveBottom(ids)
+	}
+	return nil
+}
+
+func (g *Guard) resume(info transmission.TorrentInfo) error {
+	delete(g.paused, info.Hash)
+	if g.DryRun {
+		return nil
+	}
+	ids := []string{info.Hash}
+	if g.Label != "" {
+		if err := g.Client.SetTorrent(ids, &transmission.TorrentSettings{Labels: without(info.Labels, g.Label)}); err != nil {
+			return err
+		}
+	}
+	return g.Client.StartTorrent(ids)
+}
+
+func without(labels []string, label string) []string {
+	out := []string{}
+	for _, l := range labels {
+		if l != label {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Run calls Check every interval until ctx is canceled, passing the
+// results to fn.
+func (g *Guard) Run(ctx context.Context, interval time.Duration, fn func([]Event, error)) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		fn(g.Check())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Package filter implements a small query language for selecting
+// torrents.
+//
+// An expression is a list of terms combined with and, or, not and
+// parentheses. Adjacent terms are implicitly combined with and, and
+// and binds more tightly than or. For example:
+//
+//	label:movies and status:seeding and ratio>2
+//	tracker:~example.org added<30d
+//	(label:tv or label:movies) not private:true
+//
+// A term is a key, an operator and a value. The operator : matches
+// strings against patterns, as in path.Match and ignoring case, and
+// :~ checks whether they contain a substring. Numbers, sizes and ages
+// are compared with =, <, <=, > and >=, and : is the same as =. A term
+// without a key matches torrent names, as in name:value, and the term
+// all matches every torrent. Values that contain spaces or parentheses
+// can be quoted with double quotes.
+//
+// The keys are:
+//
+//	name      the torrent's name
+//	label     any of the torrent's labels
+//	tracker   the host of any of the torrent's trackers
+//	dir       the torrent's download directory
+//	hash      a prefix of the torrent's info hash
+//	status    stopped, check-wait, checking, download-wait, downloading,
+//	          seed-wait or seeding; active matches downloading and
+//	          seeding torrents
+//	private   true or false
+//	error     true if the torrent has an error
+//	id        the torrent's ID
+//	ratio     the upload ratio
+//	progress  the percentage of wanted data that has been downloaded
+//	size      the size of the wanted data, with optional binary
+//	          suffixes such as 700M or 1.5G
+//	peers     the number of connected peers
+//	added     the time since the torrent was added, such as 12h or 30d
+//	done      the time since the torrent finished downloading
+//	activity  the time since the torrent was last active
+//	seeding   the time the torrent has spent seeding
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+)
+
+// A Filter is a compiled filter expression.
+type Filter struct {
+	expr   node
+	fields []string
+	keyed  bool
+}
+
+// Compile parses a filter expression.
+func Compile(s string) (*Filter, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	if len(toks) == 0 {
+		return nil, errors.New("empty filter")
+	}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	f := &Filter{expr: n}
+	seen := map[string]bool{}
+	for _, field := range []string{"id", "hashString", "name"} {
+		seen[field] = true
+		f.fields = append(f.fields, field)
+	}
+	n.walk(func(t *term) {
+		f.keyed = f.keyed || t.keyed
+		for _, field := range t.key.fields {
+			if !seen[field] {
+				seen[field] = true
+				f.fields = append(f.fields, field)
+			}
+		}
+	})
+	return f, nil
+}
+
+// MustCompile is like Compile but panics if the expression is invalid.
+func MustCompile(s string) *Filter {
+	f, err := Compile(s)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Fields returns the torrent fields that the filter needs to be
+// evaluated.
+func (f *Filter) Fields() []string {
+	return f.fields
+}
+
+// Keyed reports whether the expression has any terms with keys, such
+// as label:movies or ratio>2. Expressions without them only match
+// names, and may have been meant as a plain name pattern instead.
+func (f *Filter) Keyed() bool {
+	return f.keyed
+}
+
+// Match reports whether a torrent matches the filter.
+func (f *Filter) Match(info *transmission.TorrentInfo) bool {
+	return f.MatchAt(info, time.Now())
+}
+
+// MatchAt is like Match but computes ages relative to now.
+func (f *Filter) MatchAt(info *transmission.TorrentInfo, now time.Time) bool {
+	return f.expr.eval(info, now)
+}
+
+// Select returns the torrents that match the filter, with the
+// requested fields in addition to the ones the filter needs.
+func (f *Filter) Select(cl *transmission.Client, fields []string) ([]transmission.TorrentInfo, error) {
+	fields = append(fields[:len(fields):len(fields)], f.fields...)
+	infos, err := cl.TorrentInfo(nil, fields)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var out []transmission.TorrentInfo
+	for i := range infos {
+		if f.MatchAt(&infos[i], now) {
+			out = append(out, infos[i])
+		}
+	}
+	return out, nil
+}
+
+type node interface {
+	eval(info *transmission.TorrentInfo, now time.Time) bool
+	walk(fn func(*term))
+}
+
+type andNode struct{ l, r node }
+type orNode struct{ l, r node }
+type notNode struct{ n node }
+
+func (n *andNode) eval(info *transmission.TorrentInfo, now time.Time) bool {
+	return n.l.eval(info, now) && n.r.eval(info, now)
+}
+
+func (n *orNode) eval(info *transmission.TorrentInfo, now time.Time) bool {
+	return n.l.eval(info, now) || n.r.eval(info, now)
+}
+
+func (n *notNode) eval(info *transmission.TorrentInfo, now time.Time) bool {
+	return !n.n.eval(info, now)
+}
+
+func (n *andNode) walk(fn func(*term)) { n.l.walk(fn); n.r.walk(fn) }
+func (n *orNode) walk(fn func(*term))  { n.l.walk(fn); n.r.walk(fn) }
+func (n *notNode) walk(fn func(*term)) { n.n.walk(fn) }
+
+type token struct {
+	text string
+	// quoted is true if the token contained quotes, so that it isn't
+	// mistaken for a keyword.
+	quoted bool
+}
+
+func tokenize(s string) ([]token, error) {
+	var (
+		toks   []token
+		cur    strings.Builder
+		quoted bool
+		inWord bool
+		inQ    bool
+	)
+	flush := func() {
+		if inWord {
+			toks = append(toks, token{cur.String(), quoted})
+		}
+		cur.Reset()
+		quoted, inWord = false, false
+	}
+	for _, c := range s {
+		switch {
+		case inQ:
+			if c == '"' {
+				inQ = false
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '"':
+			inQ, quoted, inWord = true, true, true
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		case c == '(' || c == ')':
+			flush()
+			toks = append(toks, token{text: string(c)})
+		default:
+			cur.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inQ {
+		return nil, errors.New("unterminated quote")
+	}
+	flush()
+	return toks, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+func (p *parser) keyword(kw string) bool {
+	t, ok := p.peek()
+	if ok && !t.quoted && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = &orNode{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (node, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.keyword("and")
+		t, ok := p.peek()
+		if !ok || t.text == ")" && !t.quoted || !t.quoted && strings.EqualFold(t.text, "or") {
+			return l, nil
+		}
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = &andNode{l, r}
+	}
+}
+
+func (p *parser) not() (node, error) {
+	if p.keyword("not") {
+		n, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, errors.New("unexpected end of filter")
+	}
+	p.pos++
+	if !t.quoted {
+		switch t.text {
+		case "(":
+			n, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if t, ok := p.peek(); !ok || t.text != ")" {
+				return nil, errors.New("missing )")
+			}
+			p.pos++
+			return n, nil
+		case ")":
+			return nil, errors.New("unexpected )")
+		}
+		switch strings.ToLower(t.text) {
+		case "and", "or":
+			return nil, fmt.Errorf("unexpected %q", t.text)
+		}
+	}
+	return parseTerm(t)
+}
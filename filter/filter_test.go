@@ -0,0 +1,203 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"honnef.co/go/transmission"
+)
+
+func TestMatch(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	// The daemon reports unset dates as 0.
+	unset := time.Unix(0, 0)
+	torrents := []transmission.TorrentInfo{
+		{
+			ID:           1,
+			Name:         "debian-12.iso",
+			Hash:         "aabbccddeeff00112233445566778899aabbccdd",
+			Labels:       []string{"linux", "iso"},
+			Status:       transmission.TorrentStatusSeed,
+			UploadRatio:  2.5,
+			SizeWhenDone: 600 << 20,
+			PercentDone:  1,
+			AddedDate:    now.Add(-40 * 24 * time.Hour),
+			DoneDate:     now.Add(-39 * 24 * time.Hour),
+			ActivityDate: now.Add(-time.Hour),
+			DownloadDir:  "/srv/iso",
+			IsPrivate:    false,
+			TrackerStats: []transmission.TrackerStats{
+				// As reported by Transmission 3.
+				{Announce: "http://bttracker.debian.org:6969/announce", Host: "http://bttracker.debian.org:6969"},
+			},
+		},
+		{
+			ID:             2,
+			Name:           "Some Movie (2020)",
+			Hash:           "1111111111111111111111111111111111111111",
+			Labels:         []string{"movies"},
+			Status:         transmission.TorrentStatusDownload,
+			UploadRatio:    0.1,
+			SizeWhenDone:   3 << 30,
+			PercentDone:    0.5,
+			PeersConnected: 12,
+			AddedDate:      now.Add(-2 * time.Hour),
+			DoneDate:       unset,
+			ActivityDate:   now.Add(-time.Minute),
+			DownloadDir:    "/srv/movies",
+			IsPrivate:      true,
+			Error:          2,
+			TrackerStats: []transmission.TrackerStats{
+				// As reported by Transmission 4.
+				{Announce: "https://tracker.example.org/announce/key", Host: "tracker.example.org:443"},
+			},
+		},
+		{
+			ID:           3,
+			Name:         "stopped",
+			Hash:         "2222222222222222222222222222222222222222",
+			Status:       transmission.TorrentStatusStopped,
+			AddedDate:    now.Add(-10 * 24 * time.Hour),
+			DoneDate:     unset,
+			ActivityDate: unset,
+			DownloadDir:  "/srv/iso",
+		},
+	}
+
+	tests := []struct {
+		expr string
+		want []int
+	}{
+		{"all", []int{1, 2, 3}},
+		{"debian*", []int{1}},
+		{"name:DEBIAN*", []int{1}},
+		{"name:~movie", []int{2}},
+		{`"Some Movie (2020)"`, []int{2}},
+		{"label:linux", []int{1}},
+		{"label:*", []int{1, 2}},
+		{"tracker:bttracker.debian.org", []int{1}},
+		{"tracker:tracker.example.org", []int{2}},
+		{"tracker:~example", []int{2}},
+		{"dir:/srv/iso", []int{1, 3}},
+		{"hash:aabb", []int{1}},
+		{"hash:AABB", []int{1}},
+		{"status:seeding", []int{1}},
+		{"status:active", []int{1, 2}},
+		{"status:stopped", []int{3}},
+		{"private:true", []int{2}},
+		{"private:no", []int{1, 3}},
+		{"error:true", []int{2}},
+		{"id:2", []int{2}},
+		{"id>=2", []int{2, 3}},
+		{"ratio>2", []int{1}},
+		{"ratio<=0.1", []int{2, 3}},
+		{"progress<100", []int{2, 3}},
+		{"progress:50%", []int{2}},
+		{"size>1G", []int{2}},
+		{"size<700MiB", []int{1, 3}},
+		{"peers>10", []int{2}},
+		{"added<1d", []int{2}},
+		{"added>30d", []int{1}},
+		{"added>1w", []int{1, 3}},
+		{"done>1d", []int{1}},
+		{"done<1000d", []int{1}},
+		{"activity>1d", nil},
+		{"activity<1d", []int{1, 2}},
+		{"activity<=1h", []int{1, 2}},
+		{"label:linux status:seeding", []int{1}},
+		{"label:linux and status:stopped", nil},
+		{"label:linux or label:movies", []int{1, 2}},
+		{"not label:linux", []int{2, 3}},
+		{"not not label:linux", []int{1}},
+		{"label:linux or label:movies and private:true", []int{1, 2}},
+		{"(label:linux or label:movies) and private:true", []int{2}},
+		{"(label:linux or label:movies) not private:true", []int{1}},
+		{"label:linux AND NOT status:stopped", []int{1}},
+		{`"and"`, nil},
+	}
+	for _, tt := range tests {
+		f, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("Compile(%q): %s", tt.expr, err)
+			continue
+		}
+		var got []int
+		for i := range torrents {
+			if f.MatchAt(&torrents[i], now) {
+				got = append(got, torrents[i].ID)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q matches %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"   ",
+		"(",
+		"(label:a",
+		"label:a)",
+		"and",
+		"label:a or",
+		"label:a or or label:b",
+		"not",
+		`"unterminated`,
+		"bogus:1",
+		"name:[",
+		"name>1",
+		"hash>aa",
+		"status:flying",
+		"status>seeding",
+		"private:maybe",
+		"private>true",
+		"ratio>x",
+		"ratio:~1",
+		"size>1X",
+		"size>-1",
+		"added>soon",
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("Compile(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"all", []string{"id", "hashString", "name"}},
+		{"debian", []string{"id", "hashString", "name"}},
+		{"label:a or (tracker:b and ratio>1) label:c", []string{"id", "hashString", "name", "labels", "trackerStats", "uploadRatio"}},
+		{"added>1d not status:seeding", []string{"id", "hashString", "name", "addedDate", "status"}},
+	}
+	for _, tt := range tests {
+		if got := MustCompile(tt.expr).Fields(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Fields(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	tests := []struct {
+		expr  string
+		keyed bool
+	}{
+		{"all", false},
+		{"My Show*", false},
+		{"Pride and Prejudice", false},
+		{"name:x", true},
+		{"My Show* ratio>2", true},
+		{"not label:a", true},
+	}
+	for _, tt := range tests {
+		if got := MustCompile(tt.expr).Keyed(); got != tt.keyed {
+			t.Errorf("Keyed(%q) = %t, want %t", tt.expr, got, tt.keyed)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/internal/format"
+)
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindHash
+	kindStatus
+	kindBool
+	kindNumber
+	kindSize
+	kindAge
+)
+
+type key struct {
+	kind   kind
+	fields []string
+
+	strings func(info *transmission.TorrentInfo) []string
+	bool    func(info *transmission.TorrentInfo) bool
+	number  func(info *transmission.TorrentInfo) float64
+	// age returns false if the torrent has no such age, such as the
+	// time since completion of an incomplete torrent.
+	age func(info *transmission.TorrentInfo, now time.Time) (time.Duration, bool)
+}
+
+// since returns the time since t. The daemon reports unset dates as 0,
+// which TorrentInfo has as the Unix epoch.
+func since(t time.Time, now time.Time) (time.Duration, bool) {
+	if t.Unix() <= 0 {
+		return 0, false
+	}
+	return now.Sub(t), true
+}
+
+var keys = map[string]*key{
+	"name": {kind: kindString, fields: []string{"name"}, strings: func(info *transmission.TorrentInfo) []string {
+		return []string{info.Name}
+	}},
+	"label": {kind: kindString, fields: []string{"labels"}, strings: func(info *transmission.TorrentInfo) []string {
+		return info.Labels
+	}},
+	"tracker": {kind: kindString, fields: []string{"trackerStats"}, strings: func(info *transmission.TorrentInfo) []string {
+		var out []string
+		for i := range info.TrackerStats {
+			out = append(out, info.TrackerStats[i].Hostname())
+		}
+		return out
+	}},
+	"dir": {kind: kindString, fields: []string{"downloadDir"}, strings: func(info *transmission.TorrentInfo) []string {
+		return []string{info.DownloadDir}
+	}},
+	"hash":   {kind: kindHash, fields: []string{"hashString"}},
+	"status": {kind: kindStatus, fields: []string{"status"}},
+	"private": {kind: kindBool, fields: []string{"isPrivate"}, bool: func(info *transmission.TorrentInfo) bool {
+		return info.IsPrivate
+	}},
+	"error": {kind: kindBool, fields: []string{"error"}, bool: func(info *transmission.TorrentInfo) bool {
+		return info.Error != 0
+	}},
+	"id": {kind: kindNumber, fields: []string{"id"}, number: func(info *transmission.TorrentInfo) float64 {
+		return float64(info.ID)
+	}},
+	"ratio": {kind: kindNumber, fields: []string{"uploadRatio"}, number: func(info *transmission.TorrentInfo) float64 {
+		return info.UploadRatio
+	}},
+	"progress": {kind: kindNumber, fields: []string{"percentDone"}, number: func(info *transmission.TorrentInfo) float64 {
+		return info.PercentDone * 100
+	}},
+	"size": {kind: kindSize, fields: []string{"sizeWhenDone"}, number: func(info *transmission.TorrentInfo) float64 {
+		return float64(info.SizeWhenDone)
+	}},
+	"peers": {kind: kindNumber, fields: []string{"peersConnected"}, number: func(info *transmission.TorrentInfo) float64 {
+		return float64(info.PeersConnected)
+	}},
+	"added": {kind: kindAge, fields: []string{"addedDate"}, age: func(info *transmission.TorrentInfo, now time.Time) (time.Duration, bool) {
+		return since(info.AddedDate, now)
+	}},
+	"done": {kind: kindAge, fields: []string{"doneDate"}, age: func(info *transmission.TorrentInfo, now time.Time) (time.Duration, bool) {
+		return since(info.DoneDate, now)
+	}},
+	"activity": {kind: kindAge, fields: []string{"activityDate"}, age: func(info *transmission.TorrentInfo, now time.Time) (time.Duration, bool) {
+		return since(info.ActivityDate, now)
+	}},
+	"seeding": {kind: kindAge, fields: []string{"secondsSeeding"}, age: func(info *transmission.TorrentInfo, now time.Time) (time.Duration, bool) {
+		return info.SecondsSeeding, true
+	}},
+}
+
+var statuses = map[string][]transmission.TorrentStatus{
+	"stopped":       {transmission.TorrentStatusStopped},
+	"check-wait":    {transmission.TorrentStatusCheckWait},
+	"checking":      {transmission.TorrentStatusCheck},
+	"download-wait": {transmission.TorrentStatusDownloadWait},
+	"downloading":   {transmission.TorrentStatusDownload},
+	"seed-wait":     {transmission.TorrentStatusSeedWait},
+	"seeding":       {transmission.TorrentStatusSeed},
+	"active":        {transmission.TorrentStatusDownload, transmission.TorrentStatusSeed},
+}
+
+type term struct {
+	key *key
+	// keyed is true if the term names its key, rather than being a
+	// bare name or all.
+	keyed bool
+	match func(info *transmission.TorrentInfo, now time.Time) bool
+}
+
+func (t *term) eval(info *transmission.TorrentInfo, now time.Time) bool {
+	return t.match(info, now)
+}
+
+func (t *term) walk(fn func(*term)) { fn(t) }
+
+// Operators, longest first.
+var operators = []string{":~", "<=", ">=", ":", "<", ">", "="}
+
+func parseTerm(tok token) (node, error) {
+	s := tok.text
+	i := strings.IndexAny(s, ":<>=")
+	if i == -1 {
+		if !tok.quoted && s == "all" {
+			return &term{key: keys["id"], match: func(*transmission.TorrentInfo, time.Time) bool { return true }}, nil
+		}
+		t, err := stringTerm(keys["name"], ":", s)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	t, err := keyTerm(s, i)
+	if err != nil {
+		return nil, err
+	}
+	t.keyed = true
+	return t, nil
+}
+
+// keyTerm parses the term s, whose key ends at i.
+func keyTerm(s string, i int) (*term, error) {
+	name := strings.ToLower(s[:i])
+	k, ok := keys[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q in %q", s[:i], s)
+	}
+	var op string
+	for _, o := range operators {
+		if strings.HasPrefix(s[i:], o) {
+			op = o
+			break
+		}
+	}
+	value := s[i+len(op):]
+
+	switch k.kind {
+	case kindString:
+		return stringTerm(k, op, value)
+	case kindHash:
+		if op != ":" {
+			return nil, fmt.Errorf("%s: hash only supports :", s)
+		}
+		prefix := strings.ToLower(value)
+		return &term{key: k, match: func(info *transmission.TorrentInfo, _ time.Time) bool {
+			return strings.HasPrefix(strings.ToLower(info.Hash), prefix)
+		}}, nil
+	case kindStatus:
+		if op != ":" {
+			return nil, fmt.Errorf("%s: status only supports :", s)
+		}
+		want, ok := statuses[strings.ToLower(value)]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown status %q", s, value)
+		}
+		return &term{key: k, match: func(info *transmission.TorrentInfo, _ time.Time) bool {
+			for _, st := range want {
+				if info.Status == st {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	case kindBool:
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("%s: %s only supports :", s, name)
+		}
+		var want bool
+		switch strings.ToLower(value) {
+		case "true", "yes":
+			want = true
+		case "false", "no":
+			want = false
+		default:
+			return nil, fmt.Errorf("%s: invalid boolean %q", s, value)
+		}
+		return &term{key: k, match: func(info *transmission.TorrentInfo, _ time.Time) bool {
+			return k.bool(info) == want
+		}}, nil
+	case kindNumber, kindSize:
+		var v float64
+		var err error
+		if k.kind == kindSize {
+			var n int64
+			n, err = format.ParseSize(value)
+			v = float64(n)
+		} else {
+			v, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid number %q", s, value)
+		}
+		cmp, err := comparison(op, s)
+		if err != nil {
+			return nil, err
+		}
+		return &term{key: k, match: func(info *transmission.TorrentInfo, _ time.Time) bool {
+			return cmp(k.number(info), v)
+		}}, nil
+	case kindAge:
+		d, err := parseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid duration %q", s, value)
+		}
+		cmp, err := comparison(op, s)
+		if err != nil {
+			return nil, err
+		}
+		return &term{key: k, match: func(info *transmission.TorrentInfo, now time.Time) bool {
+			age, ok := k.age(info, now)
+			return ok && cmp(float64(age), float64(d))
+		}}, nil
+	}
+	panic("unreachable")
+}
+
+func stringTerm(k *key, op string, value string) (*term, error) {
+	value = strings.ToLower(value)
+	var match func(string) bool
+	switch op {
+	case ":":
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", value)
+		}
+		match = func(s string) bool {
+			ok, _ := path.Match(value, strings.ToLower(s))
+			return ok
+		}
+	case ":~":
+		match = func(s string) bool {
+			return strings.Contains(strings.ToLower(s), value)
+		}
+	default:
+		return nil, fmt.Errorf("strings can't be compared with %s", op)
+	}
+	return &term{key: k, match: func(info *transmission.TorrentInfo, _ time.Time) bool {
+		for _, s := range k.strings(info) {
+			if match(s) {
+				return true
+			}
+		}
+		return false
+	}}, nil
+}
+
+func comparison(op string, s string) (func(a, b float64) bool, error) {
+	switch op {
+	case ":", "=":
+		return func(a, b float64) bool { return a == b }, nil
+	case "<":
+		return func(a, b float64) bool { return a < b }, nil
+	case "<=":
+		return func(a, b float64) bool { return a <= b }, nil
+	case ">":
+		return func(a, b float64) bool { return a > b }, nil
+	case ">=":
+		return func(a, b float64) bool { return a >= b }, nil
+	default:
+		return nil, fmt.Errorf("%s: can't use %s with numbers", s, op)
+	}
+}
+
+// parseDuration is like time.ParseDuration but also supports days and
+// weeks, as in 7d or 2w.
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, suffix), 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
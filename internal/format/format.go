@@ -0,0 +1,75 @@
+// Package format formats values for display by the command-line tools,
+// and parses the values users enter.
+package format
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bytes formats n bytes using binary units, such as 1.5 GiB.
+func Bytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n)
+	for _, suffix := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		f /= unit
+		if f < unit || suffix == "TiB" {
+			return fmt.Sprintf("%.1f %s", f, suffix)
+		}
+	}
+	panic("unreachable")
+}
+
+// Rate formats a transfer rate of n bytes per second. A rate of zero
+// is shown as a dash.
+func Rate(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return Bytes(int64(n)) + "/s"
+}
+
+// ETA formats an estimated time of arrival. Negative durations, which
+// Transmission uses for unknown or unavailable ETAs, are shown as a
+// dash.
+func ETA(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd%dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	}
+	return d.Round(time.Second).String()
+}
+
+// Ratio formats an upload ratio. Negative ratios, which Transmission
+// uses for unavailable or infinite ratios, are shown as a dash.
+func Ratio(r float64) string {
+	if r < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", r)
+}
+
+// ParseSize parses a number of bytes with an optional binary suffix,
+// such as 512M or 1.5T.
+func ParseSize(s string) (int64, error) {
+	t := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	mult := 1.0
+	if n := len(t); n > 0 {
+		if i := strings.IndexByte("KMGTP", t[n-1]); i >= 0 {
+			mult = float64(int64(1) << (10 * uint(i+1)))
+			t = t[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(t, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * mult), nil
+}
@@ -0,0 +1,194 @@
+package metainfo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// The bencode implementation in this file is deliberately minimal. It
+// only supports the four bencode types, represented as int64, string,
+// []interface{} and map[string]interface{}.
+
+type encoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *encoder) write(s string) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = io.WriteString(e.w, s)
+}
+
+func (e *encoder) encode(v interface{}) {
+	switch v := v.(type) {
+	case int64:
+		e.write("i" + strconv.FormatInt(v, 10) + "e")
+	case int:
+		e.encode(int64(v))
+	case string:
+		e.write(strconv.Itoa(len(v)) + ":" + v)
+	case []byte:
+		e.encode(string(v))
+	case rawValue:
+		e.write(string(v))
+	case []interface{}:
+		e.write("l")
+		for _, el := range v {
+			e.encode(el)
+		}
+		e.write("e")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.write("d")
+		for _, k := range keys {
+			e.encode(k)
+			e.encode(v[k])
+		}
+		e.write("e")
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("bencode: unsupported type %T", v)
+		}
+	}
+}
+
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	e := &encoder{w: &buf}
+	e.encode(v)
+	return buf.Bytes(), e.err
+}
+
+// rawValue is an already bencoded value. It is used to preserve the
+// exact bytes of the info dictionary, which determine the info hash.
+type rawValue []byte
+
+var errSyntax = errors.New("bencode: syntax error")
+
+// maxDepth limits the nesting of lists and dictionaries, so that
+// malicious input can't exhaust the stack. Torrent files don't nest
+// deeper than a few levels.
+const maxDepth = 64
+
+type decoder struct {
+	data  []byte
+	off   int
+	depth int
+	// info holds the raw bytes of the top-level info dictionary, if any.
+	info []byte
+}
+
+func (d *decoder) decode() (interface{}, error) {
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(d.data) {
+		return nil, fmt.Errorf("bencode: trailing data at offset %d", d.off)
+	}
+	return v, nil
+}
+
+func (d *decoder) value() (interface{}, error) {
+	if d.off >= len(d.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	switch c := d.data[d.off]; {
+	case c == 'i':
+		d.off++
+		end := bytes.IndexByte(d.data[d.off:], 'e')
+		if end == -1 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		n, err := strconv.ParseInt(string(d.data[d.off:d.off+end]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bencode: invalid integer at offset %d", d.off)
+		}
+		d.off += end + 1
+		return n, nil
+	case c >= '0' && c <= '9':
+		return d.string()
+	case c == 'l':
+		d.off++
+		d.depth++
+		defer func() { d.depth-- }()
+		if d.depth > maxDepth {
+			return nil, fmt.Errorf("bencode: nested too deeply at offset %d", d.off)
+		}
+		list := []interface{}{}
+		for {
+			if d.off >= len(d.data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if d.data[d.off] == 'e' {
+				d.off++
+				return list, nil
+			}
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case c == 'd':
+		d.off++
+		d.depth++
+		defer func() { d.depth-- }()
+		if d.depth > maxDepth {
+			return nil, fmt.Errorf("bencode: nested too deeply at offset %d", d.off)
+		}
+		dict := map[string]interface{}{}
+		for {
+			if d.off >= len(d.data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if d.data[d.off] == 'e' {
+				d.off++
+				return dict, nil
+			}
+			k, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			start := d.off
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			if k == "info" && d.depth == 1 {
+				d.info = d.data[start:d.off]
+			}
+			dict[k] = v
+		}
+	default:
+		return nil, errSyntax
+	}
+}
+
+func (d *decoder) string() (string, error) {
+	colon := bytes.IndexByte(d.data[d.off:], ':')
+	if colon == -1 {
+		return "", io.ErrUnexpectedEOF
+	}
+	n, err := strconv.Atoi(string(d.data[d.off : d.off+colon]))
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("bencode: invalid string length at offset %d", d.off)
+	}
+	d.off += colon + 1
+	if len(d.data)-d.off < n {
+		return "", io.ErrUnexpectedEOF
+	}
+	s := string(d.data[d.off : d.off+n])
+	d.off += n
+	return s, nil
+}
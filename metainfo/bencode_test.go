@@ -0,0 +1,101 @@
+package metainfo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBencodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{int64(0), "i0e"},
+		{int64(-42), "i-42e"},
+		{"", "0:"},
+		{"spam", "4:spam"},
+		{[]interface{}{}, "le"},
+		{[]interface{}{"a", int64(1)}, "l1:ai1ee"},
+		{map[string]interface{}{}, "de"},
+		{map[string]interface{}{"b": int64(2), "a": "x"}, "d1:a1:x1:bi2ee"},
+		{map[string]interface{}{"l": []interface{}{map[string]interface{}{"k": "v"}}}, "d1:lld1:k1:veee"},
+	}
+	for _, tt := range tests {
+		b, err := encode(tt.in)
+		if err != nil {
+			t.Errorf("encode(%#v): %s", tt.in, err)
+			continue
+		}
+		if string(b) != tt.want {
+			t.Errorf("encode(%#v) = %q, want %q", tt.in, b, tt.want)
+		}
+		d := &decoder{data: b}
+		v, err := d.decode()
+		if err != nil {
+			t.Errorf("decode(%q): %s", b, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, tt.in) {
+			t.Errorf("decode(%q) = %#v, want %#v", b, v, tt.in)
+		}
+	}
+}
+
+func TestBencodeEncodeConversions(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{7, "i7e"},
+		{[]byte("ab"), "2:ab"},
+		{rawValue("i1e"), "i1e"},
+	}
+	for _, tt := range tests {
+		b, err := encode(tt.in)
+		if err != nil || string(b) != tt.want {
+			t.Errorf("encode(%#v) = %q, %v, want %q", tt.in, b, err, tt.want)
+		}
+	}
+	if _, err := encode(1.5); err == nil {
+		t.Error("encode(1.5) succeeded, want error")
+	}
+}
+
+func TestBencodeDecodeErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"i1",
+		"iae",
+		"5:abc",
+		"-1:a",
+		"l",
+		"d1:a",
+		"di1ei2ee",
+		"x",
+		"i1ei2e",
+		strings.Repeat("l", 65) + strings.Repeat("e", 65),
+		strings.Repeat("l", 1<<20),
+	} {
+		d := &decoder{data: []byte(in)}
+		if v, err := d.decode(); err == nil {
+			t.Errorf("decode(%q) = %#v, want error", in, v)
+		}
+	}
+	deep := strings.Repeat("l", maxDepth) + strings.Repeat("e", maxDepth)
+	d := &decoder{data: []byte(deep)}
+	if _, err := d.decode(); err != nil {
+		t.Errorf("decode of %d nested lists: %s", maxDepth, err)
+	}
+}
+
+func TestBencodeInfoBytes(t *testing.T) {
+	in := "d4:infod1:xi1ee1:zd4:infoi2eee"
+	d := &decoder{data: []byte(in)}
+	if _, err := d.decode(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(d.info), "d1:xi1ee"; got != want {
+		t.Errorf("info = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,192 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	minPieceLength = 16 << 10
+	maxPieceLength = 16 << 20
+	// The number of pieces the automatic piece length aims for.
+	targetPieces = 1500
+)
+
+// PieceLength returns a suitable piece length for a torrent with a
+// total size of size bytes. It picks the smallest power of two between
+// 16 KiB and 16 MiB that results in no more than about 1500 pieces.
+func PieceLength(size int64) int64 {
+	n := int64(minPieceLength)
+	for n < maxPieceLength && size/n > targetPieces {
+		n *= 2
+	}
+	return n
+}
+
+// A Builder creates metainfo by hashing a file or directory tree.
+type Builder struct {
+	// The piece length. If zero, PieceLength picks one based on the
+	// total size.
+	PieceLength int64
+	// Trackers, grouped by tier.
+	Trackers [][]string
+	// Web seed URLs.
+	WebSeeds []string
+	Private  bool
+	Comment  string
+	// CreatedBy defaults to "honnef.co/go/transmission".
+	CreatedBy string
+	Source    string
+	// CreationDate defaults to the current time.
+	CreationDate time.Time
+	// The number of pieces to hash concurrently. Defaults to the
+	// number of CPUs.
+	Parallelism int
+	// Progress, if set, is called after every hashed piece.
+	Progress func(hashed, total int)
+}
+
+// Build hashes the file or directory at path. Directories are walked
+// recursively; files are sorted by path and symbolic links are
+// followed. Hidden files are included.
+func (b *Builder) Build(path string) (*MetaInfo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		paths   []string
+		lengths []int64
+		files   []FileInfo
+	)
+	if fi.IsDir() {
+		err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				fi, err = os.Stat(p)
+				if err != nil {
+					return err
+				}
+				if fi.IsDir() {
+					// filepath.Walk doesn't follow symlinks to
+					// directories, and neither do we, to avoid cycles.
+					return nil
+				}
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(path, p)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, p)
+			lengths = append(lengths, fi.Size())
+			files = append(files, FileInfo{
+				Length: fi.Size(),
+				Path:   strings.Split(filepath.ToSlash(rel), "/"),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("metainfo: %s contains no files", path)
+		}
+	} else if fi.Mode().IsRegular() {
+		paths = []string{path}
+		lengths = []int64{fi.Size()}
+	} else {
+		return nil, fmt.Errorf("metainfo: %s is not a regular file or directory", path)
+	}
+
+	var total int64
+	for _, n := range lengths {
+		total += n
+	}
+	if total == 0 {
+		return nil, errors.New("metainfo: cannot create a torrent without any data")
+	}
+
+	pieceLength := b.PieceLength
+	if pieceLength == 0 {
+		pieceLength = PieceLength(total)
+	}
+	if pieceLength < minPieceLength || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("metainfo: invalid piece length %d", pieceLength)
+	}
+
+	s := newStorage(paths, lengths, pieceLength)
+	n := s.numPieces()
+	pieces := make([]byte, n*sha1.Size)
+	var (
+		hashed  int
+		hashErr error
+	)
+	s.hashPieces(b.Parallelism, func(res pieceResult) bool {
+		if res.err != nil {
+			hashErr = res.err
+			return false
+		}
+		copy(pieces[res.index*sha1.Size:], res.sum[:])
+		hashed++
+		if b.Progress != nil {
+			b.Progress(hashed, n)
+		}
+		return true
+	})
+	if hashErr != nil {
+		return nil, hashErr
+	}
+
+	mi := &MetaInfo{
+		Comment:      b.Comment,
+		CreatedBy:    b.CreatedBy,
+		CreationDate: b.CreationDate,
+		URLList:      b.WebSeeds,
+		Info: Info{
+			Name:        filepath.Base(path),
+			PieceLength: pieceLength,
+			Pieces:      pieces,
+			Files:       files,
+			Private:     b.Private,
+			Source:      b.Source,
+		},
+	}
+	if files == nil {
+		mi.Info.Length = total
+	}
+	if mi.CreatedBy == "" {
+		mi.CreatedBy = "honnef.co/go/transmission"
+	}
+	if mi.CreationDate.IsZero() {
+		mi.CreationDate = time.Now()
+	}
+	for _, tier := range b.Trackers {
+		if len(tier) == 0 {
+			continue
+		}
+		if mi.Announce == "" {
+			mi.Announce = tier[0]
+		}
+		mi.AnnounceList = append(mi.AnnounceList, tier)
+	}
+	if len(mi.AnnounceList) == 1 && len(mi.AnnounceList[0]) == 1 {
+		// A single tracker doesn't need an announce-list.
+		mi.AnnounceList = nil
+	}
+	return mi, nil
+}
@@ -0,0 +1,188 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPieceLength(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		{0, 16 << 10},
+		{1, 16 << 10},
+		{1500 * 16 << 10, 16 << 10},
+		{1501 * 16 << 10, 32 << 10},
+		{4 << 30, 4 << 20},
+		{1 << 50, 16 << 20},
+	}
+	for _, tt := range tests {
+		if got := PieceLength(tt.size); got != tt.want {
+			t.Errorf("PieceLength(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metainfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3000)
+	writeFiles(t, dir, map[string]string{
+		"tree/b/two":  string(data[20000:]),
+		"tree/a":      string(data[:20000]),
+		"tree/.empty": "",
+		"single":      string(data),
+	})
+
+	created := time.Unix(1600000000, 0)
+	b := &Builder{
+		PieceLength:  16 << 10,
+		Trackers:     [][]string{{"http://a/announce", "http://b/announce"}, {}, {"udp://c:80"}},
+		CreationDate: created,
+	}
+
+	var wantPieces []byte
+	for i := 0; i < len(data); i += 16 << 10 {
+		end := i + 16<<10
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha1.Sum(data[i:end])
+		wantPieces = append(wantPieces, sum[:]...)
+	}
+
+	tests := []struct {
+		path  string
+		name  string
+		files []FileInfo
+	}{
+		{"single", "single", nil},
+		{"tree", "tree", []FileInfo{
+			{Length: 0, Path: []string{".empty"}},
+			{Length: 20000, Path: []string{"a"}},
+			{Length: int64(len(data) - 20000), Path: []string{"b", "two"}},
+		}},
+	}
+	for _, tt := range tests {
+		var hashed, total int
+		b.Progress = func(h, n int) { hashed, total = h, n }
+		mi, err := b.Build(filepath.Join(dir, tt.path))
+		if err != nil {
+			t.Errorf("Build(%s): %s", tt.path, err)
+			continue
+		}
+		if mi.Info.Name != tt.name {
+			t.Errorf("Build(%s): name = %q, want %q", tt.path, mi.Info.Name, tt.name)
+		}
+		if !reflect.DeepEqual(mi.Info.Files, tt.files) {
+			t.Errorf("Build(%s): files = %v, want %v", tt.path, mi.Info.Files, tt.files)
+		}
+		if mi.Info.TotalLength() != int64(len(data)) {
+			t.Errorf("Build(%s): total length = %d, want %d", tt.path, mi.Info.TotalLength(), len(data))
+		}
+		if !bytes.Equal(mi.Info.Pieces, wantPieces) {
+			t.Errorf("Build(%s): wrong piece hashes", tt.path)
+		}
+		if hashed != 3 || total != 3 {
+			t.Errorf("Build(%s): progress = %d/%d, want 3/3", tt.path, hashed, total)
+		}
+		if mi.Announce != "http://a/announce" || len(mi.AnnounceList) != 2 {
+			t.Errorf("Build(%s): announce = %q, announce-list = %v", tt.path, mi.Announce, mi.AnnounceList)
+		}
+
+		// The built metainfo must survive a round trip.
+		raw, err := mi.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := Parse(raw)
+		if err != nil {
+			t.Errorf("Build(%s): Parse: %s", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed.Info, mi.Info) || !parsed.CreationDate.Equal(created) {
+			t.Errorf("Build(%s): round trip changed metainfo", tt.path)
+		}
+		h1, _ := mi.HashString()
+		h2, _ := parsed.HashString()
+		if h1 != h2 {
+			t.Errorf("Build(%s): info hash changed from %s to %s", tt.path, h1, h2)
+		}
+	}
+}
+
+func TestBuildDot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metainfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{"data/file": "x"})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(filepath.Join(dir, "data")); err != nil {
+		t.Fatal(err)
+	}
+	mi, err := (&Builder{}).Build(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mi.Info.Name != "data" {
+		t.Errorf("name = %q, want %q", mi.Info.Name, "data")
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metainfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"empty":     "",
+		"ok":        "data",
+		"nofiles/x": "",
+	})
+	tests := []struct {
+		path        string
+		pieceLength int64
+	}{
+		{"missing", 0},
+		{"empty", 0},
+		{"nofiles", 0},
+		{"ok", 1000},
+		{"ok", 1 << 10},
+	}
+	for _, tt := range tests {
+		b := &Builder{PieceLength: tt.pieceLength}
+		if _, err := b.Build(filepath.Join(dir, tt.path)); err == nil {
+			t.Errorf("Build(%s) with piece length %d succeeded, want error", tt.path, tt.pieceLength)
+		}
+	}
+}
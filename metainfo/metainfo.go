@@ -0,0 +1,342 @@
+// Package metainfo reads, writes and creates BitTorrent metainfo
+// (.torrent) files.
+package metainfo
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"honnef.co/go/transmission"
+)
+
+type MetaInfo struct {
+	// The primary tracker. It is the first tracker of the first tier
+	// in AnnounceList, if AnnounceList is set.
+	Announce string
+	// Trackers, grouped by tier.
+	AnnounceList [][]string
+	// Web seeds, as per BEP 19.
+	URLList      []string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	Info         Info
+
+	// infoBytes holds the bencoded info dictionary as it was read,
+	// so that unknown keys don't change the info hash.
+	infoBytes []byte
+}
+
+type Info struct {
+	// The suggested name of the file, or of the directory in
+	// multi-file torrents.
+	Name        string
+	PieceLength int64
+	// Concatenated SHA-1 hashes of all pieces.
+	Pieces []byte
+	// The length of the file in single-file torrents.
+	Length int64
+	// The files of multi-file torrents. Nil for single-file torrents.
+	Files   []FileInfo
+	Private bool
+	// The source tag, used by private trackers to produce
+	// distinct info hashes for cross-seeded torrents.
+	Source string
+}
+
+type FileInfo struct {
+	Length int64
+	// Path components, relative to the torrent's directory.
+	Path []string
+}
+
+// NumPieces returns the number of pieces.
+func (info *Info) NumPieces() int {
+	return len(info.Pieces) / sha1.Size
+}
+
+// PieceHash returns the expected hash of piece i.
+func (info *Info) PieceHash(i int) []byte {
+	return info.Pieces[i*sha1.Size : (i+1)*sha1.Size]
+}
+
+// TotalLength returns the combined length of all files.
+func (info *Info) TotalLength() int64 {
+	if info.Files == nil {
+		return info.Length
+	}
+	var n int64
+	for _, f := range info.Files {
+		n += f.Length
+	}
+	return n
+}
+
+// FileList returns the files of the torrent. Single-file torrents
+// return a single file whose path is the torrent's name.
+func (info *Info) FileList() []FileInfo {
+	if info.Files == nil {
+		return []FileInfo{{Length: info.Length, Path: []string{info.Name}}}
+	}
+	return info.Files
+}
+
+// FilePath returns the location of the file on disk, with dir being
+// the torrent's download directory.
+func (info *Info) FilePath(dir string, f FileInfo) string {
+	if info.Files == nil {
+		return filepath.Join(dir, info.Name)
+	}
+	return filepath.Join(append([]string{dir, info.Name}, f.Path...)...)
+}
+
+// Load parses a metainfo file.
+func Load(r io.Reader) (*MetaInfo, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// LoadFile parses the metainfo file at path.
+func LoadFile(path string) (*MetaInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Parse parses bencoded metainfo.
+func Parse(b []byte) (*MetaInfo, error) {
+	d := &decoder{data: b}
+	v, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	top, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metainfo: not a dictionary")
+	}
+	infoDict, ok := top["info"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metainfo: missing info dictionary")
+	}
+
+	mi := &MetaInfo{infoBytes: d.info}
+	mi.Announce, _ = top["announce"].(string)
+	mi.Comment, _ = top["comment"].(string)
+	mi.CreatedBy, _ = top["created by"].(string)
+	if n, ok := top["creation date"].(int64); ok {
+		mi.CreationDate = time.Unix(n, 0)
+	}
+	if tiers, ok := top["announce-list"].([]interface{}); ok {
+		for _, tier := range tiers {
+			urls := stringList(tier)
+			if len(urls) > 0 {
+				mi.AnnounceList = append(mi.AnnounceList, urls)
+			}
+		}
+	}
+	switch urls := top["url-list"].(type) {
+	case string:
+		mi.URLList = []string{urls}
+	case []interface{}:
+		mi.URLList = stringList(urls)
+	}
+
+	info := &mi.Info
+	info.Name, _ = infoDict["name"].(string)
+	info.PieceLength, _ = infoDict["piece length"].(int64)
+	pieces, _ := infoDict["pieces"].(string)
+	info.Pieces = []byte(pieces)
+	info.Source, _ = infoDict["source"].(string)
+	if n, _ := infoDict["private"].(int64); n == 1 {
+		info.Private = true
+	}
+	if files, ok := infoDict["files"].([]interface{}); ok {
+		info.Files = make([]FileInfo, 0, len(files))
+		for _, f := range files {
+			fd, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("metainfo: invalid file entry")
+			}
+			length, _ := fd["length"].(int64)
+			path := stringList(fd["path"])
+			if len(path) == 0 {
+				return nil, errors.New("metainfo: file without path")
+			}
+			info.Files = append(info.Files, FileInfo{Length: length, Path: path})
+		}
+	} else {
+		info.Length, _ = infoDict["length"].(int64)
+	}
+
+	if info.PieceLength <= 0 {
+		return nil, errors.New("metainfo: invalid piece length")
+	}
+	if len(info.Pieces)%sha1.Size != 0 {
+		return nil, errors.New("metainfo: invalid pieces")
+	}
+	if want := (info.TotalLength() + info.PieceLength - 1) / info.PieceLength; int64(info.NumPieces()) != want {
+		return nil, fmt.Errorf("metainfo: have %d piece hashes, want %d", info.NumPieces(), want)
+	}
+	return mi, nil
+}
+
+func stringList(v interface{}) []string {
+	l, _ := v.([]interface{})
+	out := make([]string, 0, len(l))
+	for _, el := range l {
+		if s, ok := el.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (info *Info) bencode() ([]byte, error) {
+	d := map[string]interface{}{
+		"name":         info.Name,
+		"piece length": info.PieceLength,
+		"pieces":       info.Pieces,
+	}
+	if info.Files == nil {
+		d["length"] = info.Length
+	} else {
+		files := make([]interface{}, len(info.Files))
+		for i, f := range info.Files {
+			path := make([]interface{}, len(f.Path))
+			for j, p := range f.Path {
+				path[j] = p
+			}
+			files[i] = map[string]interface{}{
+				"length": f.Length,
+				"path":   path,
+			}
+		}
+		d["files"] = files
+	}
+	if info.Private {
+		d["private"] = int64(1)
+	}
+	if info.Source != "" {
+		d["source"] = info.Source
+	}
+	return encode(d)
+}
+
+func (mi *MetaInfo) info() ([]byte, error) {
+	if mi.infoBytes != nil {
+		return mi.infoBytes, nil
+	}
+	return mi.Info.bencode()
+}
+
+// InfoHash returns the SHA-1 hash of the info dictionary.
+func (mi *MetaInfo) InfoHash() ([sha1.Size]byte, error) {
+	b, err := mi.info()
+	if err != nil {
+		return [sha1.Size]byte{}, err
+	}
+	return sha1.Sum(b), nil
+}
+
+// HashString returns the info hash in the format used by
+// TorrentInfo.Hash.
+func (mi *MetaInfo) HashString() (string, error) {
+	h, err := mi.InfoHash()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Trackers returns the torrent's trackers, grouped by tier.
+func (mi *MetaInfo) Trackers() [][]string {
+	if len(mi.AnnounceList) > 0 {
+		return mi.AnnounceList
+	}
+	if mi.Announce != "" {
+		return [][]string{{mi.Announce}}
+	}
+	return nil
+}
+
+// Bytes returns the bencoded metainfo.
+func (mi *MetaInfo) Bytes() ([]byte, error) {
+	info, err := mi.info()
+	if err != nil {
+		return nil, err
+	}
+	d := map[string]interface{}{
+		"info": rawValue(info),
+	}
+	if mi.Announce != "" {
+		d["announce"] = mi.Announce
+	}
+	if len(mi.AnnounceList) > 0 {
+		tiers := make([]interface{}, len(mi.AnnounceList))
+		for i, tier := range mi.AnnounceList {
+			urls := make([]interface{}, len(tier))
+			for j, u := range tier {
+				urls[j] = u
+			}
+			tiers[i] = urls
+		}
+		d["announce-list"] = tiers
+	}
+	if len(mi.URLList) > 0 {
+		urls := make([]interface{}, len(mi.URLList))
+		for i, u := range mi.URLList {
+			urls[i] = u
+		}
+		d["url-list"] = urls
+	}
+	if mi.Comment != "" {
+		d["comment"] = mi.Comment
+	}
+	if mi.CreatedBy != "" {
+		d["created by"] = mi.CreatedBy
+	}
+	if !mi.CreationDate.IsZero() {
+		d["creation date"] = mi.CreationDate.Unix()
+	}
+	return encode(d)
+}
+
+// Write writes the bencoded metainfo to w.
+func (mi *MetaInfo) Write(w io.Writer) error {
+	b, err := mi.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// NewTorrent returns a request for adding the torrent to Transmission.
+// downloadDir is the directory containing the torrent's data, as seen
+// by the daemon. For torrents created by a Builder, this is the parent
+// directory of the path that was hashed, which allows seeding the
+// existing data without downloading it again.
+func (mi *MetaInfo) NewTorrent(downloadDir string) (*transmission.NewTorrent, error) {
+	b, err := mi.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &transmission.NewTorrent{
+		DownloadDir: downloadDir,
+		Metainfo:    base64.StdEncoding.EncodeToString(b),
+	}, nil
+}
@@ -0,0 +1,172 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// storage maps the pieces of a torrent onto the files containing them.
+type storage struct {
+	files       []storageFile
+	pieceLength int64
+	total       int64
+}
+
+type storageFile struct {
+	path   string
+	offset int64
+	length int64
+}
+
+// newStorage doesn't open any files. They are opened when reading
+// pieces, so that the number of open files doesn't grow with the
+// number of files in the torrent.
+func newStorage(paths []string, lengths []int64, pieceLength int64) *storage {
+	s := &storage{pieceLength: pieceLength}
+	for i, path := range paths {
+		s.files = append(s.files, storageFile{
+			path:   path,
+			offset: s.total,
+			length: lengths[i],
+		})
+		s.total += lengths[i]
+	}
+	return s
+}
+
+func (s *storage) numPieces() int {
+	return int((s.total + s.pieceLength - 1) / s.pieceLength)
+}
+
+// pieceSpan returns the byte range of piece i.
+func (s *storage) pieceSpan(i int) (start, end int64) {
+	start = int64(i) * s.pieceLength
+	end = start + s.pieceLength
+	if end > s.total {
+		end = s.total
+	}
+	return start, end
+}
+
+// filesOf returns the indices of the files overlapping the byte range
+// [start, end).
+func (s *storage) filesOf(start, end int64) (first, last int) {
+	first = sort.Search(len(s.files), func(i int) bool {
+		return s.files[i].offset+s.files[i].length > start
+	})
+	last = first
+	for last+1 < len(s.files) && s.files[last+1].offset < end {
+		last++
+	}
+	return first, last
+}
+
+// readPiece reads piece i into buf, which must be at least
+// pieceLength bytes long, and returns the piece's data.
+func (s *storage) readPiece(i int, buf []byte) ([]byte, error) {
+	start, end := s.pieceSpan(i)
+	buf = buf[:end-start]
+	first, last := s.filesOf(start, end)
+	for j := first; j <= last && j < len(s.files); j++ {
+		f := &s.files[j]
+		if f.length == 0 {
+			continue
+		}
+		lo := max64(start, f.offset)
+		hi := min64(end, f.offset+f.length)
+		if err := f.readAt(buf[lo-start:hi-start], lo-f.offset); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func (f *storageFile) readAt(b []byte, off int64) error {
+	fd, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if _, err := fd.ReadAt(b, off); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+type pieceResult struct {
+	index int
+	sum   [sha1.Size]byte
+	err   error
+}
+
+// hashPieces hashes all pieces using the given number of goroutines
+// and calls fn for every piece, in no particular order. fn is never
+// called concurrently. Hashing stops early if fn returns false.
+func (s *storage) hashPieces(parallelism int, fn func(pieceResult) bool) {
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+	n := s.numPieces()
+	indices := make(chan int)
+	results := make(chan pieceResult)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// No piece is longer than the whole torrent.
+			buf := make([]byte, min64(s.pieceLength, s.total))
+			for i := range indices {
+				data, err := s.readPiece(i, buf)
+				res := pieceResult{index: i, err: err}
+				if err == nil {
+					res.sum = sha1.Sum(data)
+				}
+				results <- res
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	go func() {
+	feed:
+		for i := 0; i < n; i++ {
+			select {
+			case indices <- i:
+			case <-stop:
+				break feed
+			}
+		}
+		close(indices)
+		wg.Wait()
+		close(results)
+	}()
+	stopped := false
+	for res := range results {
+		// Keep draining results so that the workers can exit.
+		if !stopped && !fn(res) {
+			stopped = true
+			close(stop)
+		}
+	}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
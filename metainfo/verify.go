@@ -0,0 +1,163 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"honnef.co/go/transmission"
+)
+
+// A Verifier checks data on disk against the piece hashes in a
+// torrent's metainfo, without involving the daemon.
+type Verifier struct {
+	// The number of pieces to hash concurrently. Defaults to the
+	// number of CPUs.
+	Parallelism int
+	// Progress, if set, is called after every checked piece.
+	Progress func(checked, total int)
+}
+
+type VerifyResult struct {
+	// The pieces whose data matched their hashes.
+	Pieces transmission.Bitfield
+	// The number of pieces in the torrent.
+	PieceCount int
+	// The files of the torrent, in metainfo order.
+	Files []FileResult
+}
+
+type FileResult struct {
+	// The location of the file on disk.
+	Path string
+	// The length of the file according to the metainfo.
+	Length int64
+	// The actual size of the file on disk, or -1 if it is missing.
+	Size int64
+	// The number of pieces overlapping the file.
+	Pieces int
+	// The number of overlapping pieces that failed to verify. Pieces
+	// spanning several files count towards all of them.
+	BadPieces int
+}
+
+// Missing reports whether the file doesn't exist.
+func (fr FileResult) Missing() bool { return fr.Size == -1 }
+
+// OK reports whether the file exists, has the right size and all of
+// its pieces verified.
+func (fr FileResult) OK() bool { return fr.Size == fr.Length && fr.BadPieces == 0 }
+
+// Complete reports whether all pieces verified.
+func (r *VerifyResult) Complete() bool {
+	return r.Pieces.Count() == r.PieceCount
+}
+
+// Compare compares the result with the pieces the daemon claims to
+// have, as reported by TorrentInfo.Pieces. It returns the pieces that
+// only verified locally and the pieces that only the daemon has.
+func (r *VerifyResult) Compare(daemon transmission.Bitfield) (onlyLocal, onlyDaemon []int) {
+	for i := 0; i < r.PieceCount; i++ {
+		local, remote := r.Pieces.Has(i), daemon.Has(i)
+		if local && !remote {
+			onlyLocal = append(onlyLocal, i)
+		} else if remote && !local {
+			onlyDaemon = append(onlyDaemon, i)
+		}
+	}
+	return onlyLocal, onlyDaemon
+}
+
+// maxVerifyPieceLength bounds the piece length of torrents to verify,
+// because every worker allocates a buffer of that size. Real torrents
+// rarely use pieces larger than 64 MiB.
+const maxVerifyPieceLength = 256 << 20
+
+// Verify checks the torrent's data in dir, which is the torrent's
+// download directory, as reported by TorrentInfo.DownloadDir.
+// Missing and truncated files don't cause an error; their pieces are
+// reported as bad.
+func (v *Verifier) Verify(info *Info, dir string) (*VerifyResult, error) {
+	if info.PieceLength <= 0 || info.PieceLength > maxVerifyPieceLength {
+		return nil, fmt.Errorf("metainfo: invalid piece length %d", info.PieceLength)
+	}
+	if want := (info.TotalLength() + info.PieceLength - 1) / info.PieceLength; len(info.Pieces)%sha1.Size != 0 || int64(info.NumPieces()) != want {
+		return nil, fmt.Errorf("metainfo: have %d bytes of piece hashes, want %d", len(info.Pieces), want*sha1.Size)
+	}
+	if err := checkPaths(info); err != nil {
+		return nil, err
+	}
+	files := info.FileList()
+	paths := make([]string, len(files))
+	lengths := make([]int64, len(files))
+	res := &VerifyResult{
+		PieceCount: info.NumPieces(),
+		Pieces:     transmission.NewBitfield(info.NumPieces()),
+		Files:      make([]FileResult, len(files)),
+	}
+	for i, f := range files {
+		paths[i] = info.FilePath(dir, f)
+		lengths[i] = f.Length
+		res.Files[i] = FileResult{Path: paths[i], Length: f.Length, Size: -1}
+		if fi, err := os.Stat(paths[i]); err == nil {
+			res.Files[i].Size = fi.Size()
+		}
+	}
+
+	s := newStorage(paths, lengths, info.PieceLength)
+	n := s.numPieces()
+	checked := 0
+	s.hashPieces(v.Parallelism, func(pr pieceResult) bool {
+		good := pr.err == nil && bytes.Equal(pr.sum[:], info.PieceHash(pr.index))
+		if good {
+			res.Pieces.Set(pr.index)
+		}
+		first, last := s.filesOf(s.pieceSpan(pr.index))
+		for i := first; i <= last && i < len(files); i++ {
+			if files[i].Length == 0 {
+				continue
+			}
+			res.Files[i].Pieces++
+			if !good {
+				res.Files[i].BadPieces++
+			}
+		}
+		checked++
+		if v.Progress != nil {
+			v.Progress(checked, n)
+		}
+		return true
+	})
+	return res, nil
+}
+
+// checkPaths rejects names and path components that would refer to
+// files outside of the torrent's directory.
+func checkPaths(info *Info) error {
+	check := func(c string) error {
+		switch {
+		case c == "", c == ".", c == "..", strings.ContainsAny(c, `/\`), filepath.VolumeName(c) != "":
+			return fmt.Errorf("metainfo: invalid path component %q", c)
+		}
+		return nil
+	}
+	if err := check(info.Name); err != nil {
+		return err
+	}
+	for _, f := range info.Files {
+		for _, c := range f.Path {
+			if err := check(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Verify checks the torrent's data in dir using a default Verifier.
+func Verify(info *Info, dir string) (*VerifyResult, error) {
+	return (&Verifier{}).Verify(info, dir)
+}
@@ -0,0 +1,139 @@
+package metainfo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"honnef.co/go/transmission"
+)
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metainfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	src := filepath.Join(dir, "src")
+	writeFiles(t, src, map[string]string{
+		"t/a": string(data[:20000]),
+		"t/b": string(data[20000:40000]),
+		"t/c": string(data[40000:]),
+	})
+	mi, err := (&Builder{PieceLength: 16 << 10}).Build(filepath.Join(src, "t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := append([]byte(nil), data[20000:40000]...)
+	corrupt[0] ^= 1
+	tests := []struct {
+		name     string
+		files    map[string]string
+		complete bool
+		pieces   []bool
+		bad      []int
+		missing  []bool
+	}{
+		{
+			name:     "complete",
+			files:    map[string]string{"t/a": string(data[:20000]), "t/b": string(data[20000:40000]), "t/c": string(data[40000:])},
+			complete: true,
+			pieces:   []bool{true, true, true, true},
+			bad:      []int{0, 0, 0},
+			missing:  []bool{false, false, false},
+		},
+		{
+			name:    "corrupt",
+			files:   map[string]string{"t/a": string(data[:20000]), "t/b": string(corrupt), "t/c": string(data[40000:])},
+			pieces:  []bool{true, false, true, true},
+			bad:     []int{1, 1, 0},
+			missing: []bool{false, false, false},
+		},
+		{
+			name:    "missing",
+			files:   map[string]string{"t/a": string(data[:20000]), "t/c": string(data[40000:])},
+			pieces:  []bool{true, false, false, true},
+			bad:     []int{1, 2, 1},
+			missing: []bool{false, true, false},
+		},
+		{
+			name:    "truncated",
+			files:   map[string]string{"t/a": string(data[:20000]), "t/b": string(data[20000:40000]), "t/c": string(data[40000:60000])},
+			pieces:  []bool{true, true, true, false},
+			bad:     []int{0, 0, 1},
+			missing: []bool{false, false, false},
+		},
+	}
+	for _, tt := range tests {
+		d := filepath.Join(dir, tt.name)
+		writeFiles(t, d, tt.files)
+		res, err := Verify(&mi.Info, d)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if res.Complete() != tt.complete {
+			t.Errorf("%s: Complete() = %t, want %t", tt.name, res.Complete(), tt.complete)
+		}
+		if res.PieceCount != len(tt.pieces) {
+			t.Errorf("%s: PieceCount = %d, want %d", tt.name, res.PieceCount, len(tt.pieces))
+			continue
+		}
+		for i, want := range tt.pieces {
+			if res.Pieces.Has(i) != want {
+				t.Errorf("%s: piece %d verified = %t, want %t", tt.name, i, !want, want)
+			}
+		}
+		for i, f := range res.Files {
+			if f.BadPieces != tt.bad[i] {
+				t.Errorf("%s: file %d has %d bad pieces, want %d", tt.name, i, f.BadPieces, tt.bad[i])
+			}
+			if f.Missing() != tt.missing[i] {
+				t.Errorf("%s: file %d missing = %t, want %t", tt.name, i, f.Missing(), tt.missing[i])
+			}
+		}
+	}
+}
+
+func TestVerifyInvalidInfo(t *testing.T) {
+	hashes := make([]byte, 20)
+	tests := []struct {
+		name string
+		info Info
+	}{
+		{"no piece length", Info{Name: "x", Length: 1, Pieces: hashes}},
+		{"huge piece length", Info{Name: "x", Length: 1 << 40, PieceLength: 1 << 40, Pieces: hashes}},
+		{"missing hashes", Info{Name: "x", Length: 40 << 10, PieceLength: 16 << 10, Pieces: hashes}},
+		{"partial hash", Info{Name: "x", Length: 1, PieceLength: 16 << 10, Pieces: hashes[:10]}},
+		{"dotdot name", Info{Name: "..", Length: 1, PieceLength: 16 << 10, Pieces: hashes}},
+		{"slash in name", Info{Name: "a/b", Length: 1, PieceLength: 16 << 10, Pieces: hashes}},
+		{"dotdot component", Info{Name: "x", PieceLength: 16 << 10, Pieces: hashes, Files: []FileInfo{{Length: 1, Path: []string{"..", "etc"}}}}},
+		{"empty component", Info{Name: "x", PieceLength: 16 << 10, Pieces: hashes, Files: []FileInfo{{Length: 1, Path: []string{""}}}}},
+		{"absolute component", Info{Name: "x", PieceLength: 16 << 10, Pieces: hashes, Files: []FileInfo{{Length: 1, Path: []string{"/etc"}}}}},
+	}
+	for _, tt := range tests {
+		if _, err := Verify(&tt.info, os.TempDir()); err == nil {
+			t.Errorf("%s: Verify succeeded, want error", tt.name)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	res := &VerifyResult{PieceCount: 4, Pieces: transmission.NewBitfield(4)}
+	res.Pieces.Set(0)
+	res.Pieces.Set(1)
+	daemon := transmission.NewBitfield(4)
+	daemon.Set(1)
+	daemon.Set(2)
+	onlyLocal, onlyDaemon := res.Compare(daemon)
+	if len(onlyLocal) != 1 || onlyLocal[0] != 0 {
+		t.Errorf("onlyLocal = %v, want [0]", onlyLocal)
+	}
+	if len(onlyDaemon) != 1 || onlyDaemon[0] != 2 {
+		t.Errorf("onlyDaemon = %v, want [2]", onlyDaemon)
+	}
+}
@@ -0,0 +1,315 @@
+// Package migrate moves torrents from one Transmission daemon to
+// another, preserving their settings.
+//
+// Torrents are added to the destination paused, with the same labels,
+// file selection, priorities, limits and trackers as on the source,
+// and their download directories optionally mapped to new locations.
+// The data itself is not copied; it must already be available to the
+// destination, for example on shared storage or by having been copied
+// beforehand. After verifying the data on the destination, torrents can
+// be removed from the source.
+package migrate
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"honnef.co/go/transmission"
+)
+
+// Fields are the torrent fields needed to migrate torrents.
+var Fields = []string{
+	"id", "hashString", "name", "torrentFile", "magnetLink", "downloadDir", "labels", "wanted",
+	"priorities", "bandwidthPriority", "maxConnectedPeers", "downloadLimit", "downloadLimited",
+	"uploadLimit", "uploadLimited", "honorsSessionLimits", "seedIdleLimit", "seedIdleMode",
+	"seedRatioLimit", "seedRatioMode", "trackers",
+}
+
+type Migration struct {
+	Source      *transmission.Client
+	Destination *transmission.Client
+	// MapDir, if not nil, maps download directories on the source to
+	// download directories on the destination.
+	MapDir func(dir string) string
+	// ReadFile reads the source's copy of a torrent file, whose path
+	// is given by TorrentInfo.TorrentFile. It defaults to
+	// ioutil.ReadFile, which works if the file is accessible locally.
+	ReadFile func(path string) ([]byte, error)
+	// AllowMagnet falls back to adding torrents by their magnet links
+	// if their torrent files can't be read. The destination then has
+	// to fetch the metadata from peers before the file settings can be
+	// applied and the data can be verified.
+	AllowMagnet bool
+	// Verify verifies the data on the destination and waits for the
+	// verification to complete.
+	Verify bool
+	// Start starts torrents on the destination once they have been
+	// migrated, after verification succeeded if Verify is set.
+	Start bool
+	// RemoveSource removes torrents from the source once they have
+	// been verified to be complete on the destination. It requires
+	// Verify. DeleteSourceData also deletes the source's data.
+	RemoveSource     bool
+	DeleteSourceData bool
+	// PollInterval is how often to check on verification and metadata
+	// downloads. It defaults to two seconds.
+	PollInterval time.Duration
+}
+
+// Result describes the migration of a single torrent.
+type Result struct {
+	Hash string
+	Name string
+	// Duplicate is true if the destination already had the torrent.
+	// Its settings are left alone in that case.
+	Duplicate bool
+	// Magnet is true if the torrent was added by its magnet link.
+	Magnet bool
+	// Verified is true if the data on the destination was verified
+	// to be complete.
+	Verified bool
+	// Removed is true if the torrent was removed from the source.
+	Removed bool
+	Err     error
+}
+
+// NewTorrent returns a request for adding the torrent described by
+// info, which must contain the fields torrentFile and magnetLink. The
+// torrent file is read with readFile; if that fails and allowMagnet is
+// set, the magnet link is used instead, and magnet is true.
+func NewTorrent(info *transmission.TorrentInfo, readFile func(string) ([]byte, error), allowMagnet bool) (req *transmission.NewTorrent, magnet bool, err error) {
+	if readFile == nil {
+		readFile = ioutil.ReadFile
+	}
+	b, err := readFile(info.TorrentFile)
+	if err == nil {
+		return &transmission.NewTorrent{Metainfo: base64.StdEncoding.EncodeToString(b)}, false, nil
+	}
+	if !allowMagnet || info.MagnetLink == "" {
+		return nil, false, fmt.Errorf("couldn't read torrent file: %s", err)
+	}
+	return &transmission.NewTorrent{Filename: info.MagnetLink}, true, nil
+}
+
+// fileSettings returns the file selection and priorities of a torrent,
+// as indices.
+func fileSettings(info *transmission.TorrentInfo) (unwanted, high, low []int) {
+	for i, w := range info.Wanted {
+		if !w {
+			unwanted = append(unwanted, i)
+		}
+	}
+	for i, p := range info.Priorities {
+		switch p {
+		case transmission.PriorityHigh:
+			high = append(high, i)
+		case transmission.PriorityLow:
+			low = append(low, i)
+		}
+	}
+	return unwanted, high, low
+}
+
+// Migrate migrates the torrents with the given hashes, one after
+// another. It returns a result for every torrent that was found on the
+// source, and stops early if ctx is canceled.
+func (m *Migration) Migrate(ctx context.Context, hashes []string) ([]Result, error) {
+	if m.RemoveSource && !m.Verify {
+		return nil, errors.New("removing torrents from the source requires verification")
+	}
+	infos, err := m.Source.TorrentInfo(hashes, Fields)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get torrents from source: %s", err)
+	}
+	var results []Result
+	for i := range infos {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		res := Result{Hash: infos[i].Hash, Name: infos[i].Name}
+		res.Err = m.migrate(ctx, &infos[i], &res)
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (m *Migration) migrate(ctx context.Context, info *transmission.TorrentInfo, res *Result) error {
+	req, magnet, err := NewTorrent(info, m.ReadFile, m.AllowMagnet)
+	if err != nil {
+		return err
+	}
+	res.Magnet = magnet
+	req.Paused = true
+	req.DownloadDir = info.DownloadDir
+	if m.MapDir != nil {
+		req.DownloadDir = m.MapDir(info.DownloadDir)
+	}
+	req.BandwidthPriority = info.BandwidthPriority
+	req.PeerLimit = info.MaxConnectedPeers
+	unwanted, high, low := fileSettings(info)
+	if !magnet {
+		req.FilesUnwanted, req.PriorityHigh, req.PriorityLow = unwanted, high, low
+	}
+
+	added, dup, err := m.Destination.AddTorrent(req)
+	if err != nil {
+		return fmt.Errorf("couldn't add to destination: %s", err)
+	}
+	res.Duplicate = dup
+	ids := []string{added.Hash}
+
+	if !dup {
+		hasFileSettings := len(unwanted) > 0 || len(high) > 0 || len(low) > 0
+		if magnet && (hasFileSettings || m.Verify) {
+			// The destination can't apply file settings or verify the
+			// data before it has the metadata. While paused, it won't
+			// fetch it.
+			if err := m.Destination.StartTorrent(ids); err != nil {
+				return err
+			}
+			err := m.waitFor(ctx, added.Hash, []string{"metadataPercentComplete"}, func(t *transmission.TorrentInfo) bool {
+				return t.MetadataPercentComplete == 1
+			})
+			// Stop it again either way, so that it doesn't download
+			// before its files have been selected or verified.
+			if err := m.Destination.StopTorrent(ids); err != nil {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("couldn't get metadata: %s", err)
+			}
+		}
+		if magnet && hasFileSettings {
+			if err := m.Destination.SetTorrent(ids, &transmission.TorrentSettings{
+				FilesUnwanted: unwanted,
+				PriorityHigh:  high,
+				PriorityLow:   low,
+			}); err != nil {
+				return fmt.Errorf("couldn't set file settings: %s", err)
+			}
+		}
+		if err := m.copySettings(info, added.Hash); err != nil {
+			return err
+		}
+	}
+
+	if m.Verify {
+		if err := m.verify(ctx, added.Hash); err != nil {
+			return err
+		}
+		res.Verified = true
+	}
+	if m.Start {
+		if err := m.Destination.StartTorrent(ids); err != nil {
+			return fmt.Errorf("couldn't start on destination: %s", err)
+		}
+	}
+	if m.RemoveSource {
+		if err := m.Source.RemoveTorrent([]string{info.Hash}, m.DeleteSourceData); err != nil {
+			return fmt.Errorf("couldn't remove from source: %s", err)
+		}
+		res.Removed = true
+	}
+	return nil
+}
+
+// copySettings applies the source torrent's labels, limits and
+// trackers to the destination torrent.
+func (m *Migration) copySettings(info *transmission.TorrentInfo, hash string) error {
+	labels := info.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+	settings := &transmission.TorrentSettings{
+		Labels:              labels,
+		DownloadLimit:       &info.DownloadLimit,
+		DownloadLimited:     &info.DownloadLimited,
+		UploadLimit:         &info.UploadLimit,
+		UploadLimited:       &info.UploadLimited,
+		HonorsSessionLimits: &info.HonorsSessionLimits,
+		SeedIdleLimit:       &info.SeedIdleLimit,
+		SeedIdleMode:        &info.SeedIdleMode,
+		SeedRatioLimit:      &info.SeedRatioLimit,
+		SeedRatioMode:       &info.SeedRatioMode,
+	}
+
+	// Trackers may have been added on the source after the torrent
+	// file was created.
+	dst, err := m.Destination.TorrentInfo([]string{hash}, []string{"id", "trackers"})
+	if err != nil {
+		return fmt.Errorf("couldn't get torrent from destination: %s", err)
+	}
+	have := map[string]bool{}
+	if len(dst) > 0 {
+		for _, tr := range dst[0].Trackers {
+			have[tr.Announce] = true
+		}
+	}
+	for _, tr := range info.Trackers {
+		if !have[tr.Announce] {
+			have[tr.Announce] = true
+			settings.TrackerAdd = append(settings.TrackerAdd, tr.Announce)
+		}
+	}
+
+	if err := m.Destination.SetTorrent([]string{hash}, settings); err != nil {
+		return fmt.Errorf("couldn't copy settings: %s", err)
+	}
+	return nil
+}
+
+// verify verifies the torrent on the destination and checks that all
+// wanted data is present.
+func (m *Migration) verify(ctx context.Context, hash string) error {
+	if err := m.Destination.VerifyTorrent([]string{hash}); err != nil {
+		return fmt.Errorf("couldn't verify on destination: %s", err)
+	}
+	var last transmission.TorrentInfo
+	err := m.waitFor(ctx, hash, []string{"status", "percentDone"}, func(t *transmission.TorrentInfo) bool {
+		last = *t
+		switch t.Status {
+		case transmission.TorrentStatusCheck, transmission.TorrentStatusCheckWait:
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't verify on destination: %s", err)
+	}
+	if last.PercentDone < 1 {
+		return fmt.Errorf("destination only has %.1f%% of the data", last.PercentDone*100)
+	}
+	return nil
+}
+
+// waitFor polls the destination torrent until cond returns true.
+func (m *Migration) waitFor(ctx context.Context, hash string, fields []string, cond func(*transmission.TorrentInfo) bool) error {
+	interval := m.PollInterval
+	if interval == 0 {
+		interval = 2 * time.Second
+	}
+	fields = append([]string{"id", "hashString"}, fields...)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		infos, err := m.Destination.TorrentInfo([]string{hash}, fields)
+		if err != nil {
+			return err
+		}
+		if len(infos) == 0 {
+			return errors.New("torrent disappeared")
+		}
+		if cond(&infos[0]) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
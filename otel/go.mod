@@ -0,0 +1,12 @@
+module honnef.co/go/transmission/otel
+
+go 1.22
+
+require (
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/metric v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+	honnef.co/go/transmission v0.0.0-00010101000000-000000000000
+)
+
+replace honnef.co/go/transmission => ../
@@ -0,0 +1,305 @@
+package otel
+
+import (
+	"context"
+	"fmt"
+
+	"honnef.co/go/transmission"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var sessionFields = []string{
+	"version", "rpc-version", "speed-limit-down", "speed-limit-down-enabled", "speed-limit-up",
+	"speed-limit-up-enabled", "alt-speed-down", "alt-speed-up", "alt-speed-enabled", "download-queue-size",
+	"download-queue-enabled", "seed-queue-size", "seed-queue-enabled", "blocklist-size", "download-dir", "units",
+}
+
+var torrentFields = []string{
+	"id", "hashString", "name", "status", "sizeWhenDone", "uploadedEver", "downloadedEver",
+	"corruptEver", "peersConnected", "rateDownload", "rateUpload", "uploadRatio", "leftUntilDone",
+	"trackerStats", "peers",
+}
+
+type instruments struct {
+	info              metric.Int64ObservableGauge
+	downloadRate      metric.Int64ObservableGauge
+	uploadRate        metric.Int64ObservableGauge
+	downloaded        metric.Int64ObservableCounter
+	uploaded          metric.Int64ObservableCounter
+	sessionDownloaded metric.Int64ObservableGauge
+	sessionUploaded   metric.Int64ObservableGauge
+	uptime            metric.Int64ObservableGauge
+	activeTime        metric.Int64ObservableCounter
+	sessions          metric.Int64ObservableCounter
+	filesAdded        metric.Int64ObservableCounter
+	torrents          metric.Int64ObservableGauge
+	speedLimit        metric.Int64ObservableGauge
+	speedLimitEnabled metric.Int64ObservableGauge
+	altSpeedEnabled   metric.Int64ObservableGauge
+	queueSize         metric.Int64ObservableGauge
+	queueEnabled      metric.Int64ObservableGauge
+	blocklistRules    metric.Int64ObservableGauge
+	downloadDirFree   metric.Int64ObservableGauge
+	torrentStatus     metric.Int64ObservableGauge
+	torrentSize       metric.Int64ObservableGauge
+	torrentDown       metric.Int64ObservableCounter
+	torrentUp         metric.Int64ObservableCounter
+	torrentCorrupt    metric.Int64ObservableCounter
+	torrentPeers      metric.Int64ObservableGauge
+	torrentDownRate   metric.Int64ObservableGauge
+	torrentUpRate     metric.Int64ObservableGauge
+	torrentRatio      metric.Float64ObservableGauge
+	torrentRemaining  metric.Int64ObservableGauge
+	trackers          metric.Int64ObservableGauge
+	trackerSeeders    metric.Int64ObservableGauge
+	trackerLeechers   metric.Int64ObservableGauge
+	peers             metric.Int64ObservableGauge
+}
+
+// RegisterMetrics registers instruments that report the session-level,
+// per-torrent and peer metrics of the daemon that cl is connected to,
+// the same data as transmission-exporter. The daemon is queried
+// whenever the meter provider collects metrics, with the context of the
+// collection. Unregister the returned registration to stop.
+//
+// Some of the exporter's metrics are not reported: the peer progress
+// and rate histograms, because OpenTelemetry has no asynchronous
+// histograms; peers by country and autonomous system, which need GeoIP
+// databases; and the number of torrents over the series limit, because
+// every torrent is reported.
+func RegisterMetrics(mp metric.MeterProvider, cl *transmission.Client) (metric.Registration, error) {
+	m := mp.Meter(instrumentationName)
+	var ins instruments
+	var errs []error
+	gauge := func(name, unit, desc string) metric.Int64ObservableGauge {
+		g, err := m.Int64ObservableGauge(name, metric.WithUnit(unit), metric.WithDescription(desc))
+		errs = append(errs, err)
+		return g
+	}
+	counter := func(name, unit, desc string) metric.Int64ObservableCounter {
+		c, err := m.Int64ObservableCounter(name, metric.WithUnit(unit), metric.WithDescription(desc))
+		errs = append(errs, err)
+		return c
+	}
+	ins.info = gauge("transmission.info", "1", "Version of the Transmission daemon")
+	ins.downloadRate = gauge("transmission.download.rate", "By/s", "Current global download rate")
+	ins.uploadRate = gauge("transmission.upload.rate", "By/s", "Current global upload rate")
+	ins.downloaded = counter("transmission.downloaded", "By", "Bytes downloaded across all sessions")
+	ins.uploaded = counter("transmission.uploaded", "By", "Bytes uploaded across all sessions")
+	ins.sessionDownloaded = gauge("transmission.session.downloaded", "By", "Bytes downloaded in the current session")
+	ins.sessionUploaded = gauge("transmission.session.uploaded", "By", "Bytes uploaded in the current session")
+	ins.uptime = gauge("transmission.uptime", "s", "Time since the daemon was started")
+	ins.activeTime = counter("transmission.active_time", "s", "Time the daemon has been running, across all sessions")
+	ins.sessions = counter("transmission.sessions", "{session}", "Number of times the daemon has been started")
+	ins.filesAdded = counter("transmission.files_added", "{file}", "Number of files added across all sessions")
+	ins.torrents = gauge("transmission.torrents", "{torrent}", "Number of torrents")
+	ins.speedLimit = gauge("transmission.speed_limit", "By/s", "Configured speed limit")
+	ins.speedLimitEnabled = gauge("transmission.speed_limit.enabled", "1", "Whether the regular speed limit is enabled")
+	ins.altSpeedEnabled = gauge("transmission.alt_speed.enabled", "1", "Whether the alternative speed limits are in effect")
+	ins.queueSize = gauge("transmission.queue.size", "{torrent}", "Maximum number of torrents in the queue")
+	ins.queueEnabled = gauge("transmission.queue.enabled", "1", "Whether the queue is enabled")
+	ins.blocklistRules = gauge("transmission.blocklist.rules", "{rule}", "Number of rules in the blocklist")
+	ins.downloadDirFree = gauge("transmission.download_dir.free", "By", "Free space in the default download directory")
+	ins.torrentStatus = gauge("transmission.torrent.status", "1", "Status of the torrent")
+	ins.torrentSize = gauge("transmission.torrent.size", "By", "Size of the wanted files")
+	ins.torrentDown = counter("transmission.torrent.downloaded", "By", "Bytes downloaded")
+	ins.torrentUp = counter("transmission.torrent.uploaded", "By", "Bytes uploaded")
+	ins.torrentCorrupt = counter("transmission.torrent.corrupt", "By", "Bytes of corrupt data downloaded")
+	ins.torrentPeers = gauge("transmission.torrent.peers", "{peer}", "Number of connected peers")
+	ins.torrentDownRate = gauge("transmission.torrent.download.rate", "By/s", "Current download rate")
+	ins.torrentUpRate = gauge("transmission.torrent.upload.rate", "By/s", "Current upload rate")
+	ins.torrentRemaining = gauge("transmission.torrent.remaining", "By", "Bytes still wanted")
+	ins.trackers = gauge("transmission.torrent.trackers", "{tracker}", "Number of trackers, by host")
+	ins.trackerSeeders = gauge("transmission.torrent.tracker.seeders", "{peer}", "Number of seeders the tracker knows of; the maximum of all trackers on the same host")
+	ins.trackerLeechers = gauge("transmission.torrent.tracker.leechers", "{peer}", "Number of leechers the tracker knows of; the maximum of all trackers on the same host")
+	ins.peers = gauge("transmission.peers", "{peer}", "Number of connected peers, by client, transport, encryption and direction")
+	ratio, err := m.Float64ObservableGauge("transmission.torrent.ratio", metric.WithUnit("1"), metric.WithDescription("Upload ratio"))
+	errs = append(errs, err)
+	ins.torrentRatio = ratio
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		if err := ins.observeSession(ctx, o, cl); err != nil {
+			return err
+		}
+		return ins.observeTorrents(ctx, o, cl)
+	},
+		ins.info, ins.downloadRate, ins.uploadRate, ins.downloaded, ins.uploaded, ins.sessionDownloaded,
+		ins.sessionUploaded, ins.uptime, ins.activeTime, ins.sessions, ins.filesAdded, ins.torrents, ins.speedLimit, ins.speedLimitEnabled, ins.altSpeedEnabled, ins.queueSize,
+		ins.queueEnabled, ins.blocklistRules, ins.downloadDirFree, ins.torrentStatus, ins.torrentSize,
+		ins.torrentDown, ins.torrentUp, ins.torrentCorrupt, ins.torrentPeers, ins.torrentDownRate,
+		ins.torrentUpRate, ins.torrentRatio, ins.torrentRemaining, ins.trackers, ins.trackerSeeders,
+		ins.trackerLeechers, ins.peers,
+	)
+}
+
+func (ins *instruments) observeSession(ctx context.Context, o metric.Observer, cl *transmission.Client) error {
+	stats, err := cl.SessionStatsContext(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't get session statistics: %s", err)
+	}
+	o.ObserveInt64(ins.downloadRate, int64(stats.DownloadSpeed))
+	o.ObserveInt64(ins.uploadRate, int64(stats.UploadSpeed))
+	o.ObserveInt64(ins.downloaded, int64(stats.CumulativeStats.DownloadedBytes))
+	o.ObserveInt64(ins.uploaded, int64(stats.CumulativeStats.UploadedBytes))
+	o.ObserveInt64(ins.sessionDownloaded, int64(stats.CurrentStats.DownloadedBytes))
+	o.ObserveInt64(ins.sessionUploaded, int64(stats.CurrentStats.UploadedBytes))
+	o.ObserveInt64(ins.uptime, int64(stats.CurrentStats.SecondsActive))
+	o.ObserveInt64(ins.activeTime, int64(stats.CumulativeStats.SecondsActive))
+	o.ObserveInt64(ins.sessions, int64(stats.CumulativeStats.SessionCount))
+	o.ObserveInt64(ins.filesAdded, int64(stats.CumulativeStats.FilesAdded))
+	o.ObserveInt64(ins.torrents, int64(stats.ActiveTorrents), metric.WithAttributes(attribute.String("state", "active")))
+	o.ObserveInt64(ins.torrents, int64(stats.PausedTorrents), metric.WithAttributes(attribute.String("state", "paused")))
+
+	info, err := cl.SessionInfoContext(ctx, sessionFields)
+	if err != nil {
+		return fmt.Errorf("couldn't get session: %s", err)
+	}
+	o.ObserveInt64(ins.info, 1, metric.WithAttributes(
+		attribute.String("version", info.Version),
+		attribute.Int("rpc_version", info.RpcVersion),
+	))
+	// Speed limits are configured in units of speed-bytes, which is
+	// usually 1000.
+	kB := int64(info.Units.SpeedBytes)
+	if kB == 0 {
+		kB = 1000
+	}
+	limit := func(v int, direction string, alt bool) {
+		o.ObserveInt64(ins.speedLimit, int64(v)*kB, metric.WithAttributes(
+			attribute.String("direction", direction),
+			attribute.Bool("alt", alt),
+		))
+	}
+	limit(info.SpeedLimitDown, "down", false)
+	limit(info.SpeedLimitUp, "up", false)
+	limit(info.AltSpeedDown, "down", true)
+	limit(info.AltSpeedUp, "up", true)
+	flag := func(inst metric.Int64ObservableGauge, b bool, attrs ...attribute.KeyValue) {
+		var v int64
+		if b {
+			v = 1
+		}
+		o.ObserveInt64(inst, v, metric.WithAttributes(attrs...))
+	}
+	flag(ins.speedLimitEnabled, info.SpeedLimitDownEnabled, attribute.String("direction", "down"))
+	flag(ins.speedLimitEnabled, info.SpeedLimitUpEnabled, attribute.String("direction", "up"))
+	flag(ins.altSpeedEnabled, info.AltSpeedEnabled)
+	o.ObserveInt64(ins.queueSize, int64(info.DownloadQueueSize), metric.WithAttributes(attribute.String("queue", "download")))
+	o.ObserveInt64(ins.queueSize, int64(info.SeedQueueSize), metric.WithAttributes(attribute.String("queue", "seed")))
+	flag(ins.queueEnabled, info.DownloadQueueEnabled, attribute.String("queue", "download"))
+	flag(ins.queueEnabled, info.SeedQueueEnabled, attribute.String("queue", "seed"))
+	o.ObserveInt64(ins.blocklistRules, int64(info.BlocklistSize))
+
+	free, err := cl.FreeSpaceContext(ctx, info.DownloadDir)
+	if err != nil {
+		return fmt.Errorf("couldn't get free space: %s", err)
+	}
+	o.ObserveInt64(ins.downloadDirFree, free, metric.WithAttributes(attribute.String("path", info.DownloadDir)))
+	return nil
+}
+
+func (ins *instruments) observeTorrents(ctx context.Context, o metric.Observer, cl *transmission.Client) error {
+	infos, err := cl.TorrentInfoContext(ctx, nil, torrentFields)
+	if err != nil {
+		return fmt.Errorf("couldn't get torrents: %s", err)
+	}
+	type peerKey struct {
+		client, transport, direction string
+		encrypted                    bool
+	}
+	peers := map[peerKey]int64{}
+	for i := range infos {
+		info := &infos[i]
+		for j := range info.Peers {
+			p := &info.Peers[j]
+			key := peerKey{client: p.UnversionedClientName(), transport: "tcp", direction: "outgoing", encrypted: p.IsEncrypted}
+			if p.IsUTP {
+				key.transport = "utp"
+			}
+			if p.IsIncoming {
+				key.direction = "incoming"
+			}
+			peers[key]++
+		}
+		attrs := metric.WithAttributes(
+			attribute.String("torrent", info.Hash),
+			attribute.String("name", info.Name),
+		)
+		o.ObserveInt64(ins.torrentStatus, 1, metric.WithAttributes(
+			attribute.String("torrent", info.Hash),
+			attribute.String("name", info.Name),
+			attribute.String("status", info.Status.String()),
+		))
+		o.ObserveInt64(ins.torrentSize, int64(info.SizeWhenDone), attrs)
+		o.ObserveInt64(ins.torrentDown, int64(info.DownloadedEver), attrs)
+		o.ObserveInt64(ins.torrentUp, int64(info.UploadedEver), attrs)
+		o.ObserveInt64(ins.torrentCorrupt, int64(info.CorruptEver), attrs)
+		o.ObserveInt64(ins.torrentPeers, int64(info.PeersConnected), attrs)
+		o.ObserveInt64(ins.torrentDownRate, int64(info.RateDownload), attrs)
+		o.ObserveInt64(ins.torrentUpRate, int64(info.RateUpload), attrs)
+		o.ObserveFloat64(ins.torrentRatio, info.UploadRatio, attrs)
+		o.ObserveInt64(ins.torrentRemaining, int64(info.LeftUntilDone), attrs)
+		ins.observeTrackers(o, info)
+	}
+	// Like transmission-exporter, peers are aggregated to avoid series
+	// per peer.
+	for key, n := range peers {
+		o.ObserveInt64(ins.peers, n, metric.WithAttributes(
+			attribute.String("client", key.client),
+			attribute.String("transport", key.transport),
+			attribute.Bool("encryption", key.encrypted),
+			attribute.String("direction", key.direction),
+		))
+	}
+	return nil
+}
+
+// observeTrackers reports a torrent's trackers. Like
+// transmission-exporter, it aggregates trackers on the same host, so
+// that each host is observed only once.
+func (ins *instruments) observeTrackers(o metric.Observer, info *transmission.TorrentInfo) {
+	type hostStats struct {
+		trackers int64
+		seeders  int
+		leechers int
+	}
+	var hosts []string
+	stats := map[string]*hostStats{}
+	for _, tr := range info.TrackerStats {
+		st, ok := stats[tr.Host]
+		if !ok {
+			// Negative counts mean that the tracker doesn't know.
+			st = &hostStats{seeders: -1, leechers: -1}
+			stats[tr.Host] = st
+			hosts = append(hosts, tr.Host)
+		}
+		st.trackers++
+		if tr.SeederCount > st.seeders {
+			st.seeders = tr.SeederCount
+		}
+		if tr.LeecherCount > st.leechers {
+			st.leechers = tr.LeecherCount
+		}
+	}
+	for _, host := range hosts {
+		st := stats[host]
+		attrs := metric.WithAttributes(
+			attribute.String("torrent", info.Hash),
+			attribute.String("tracker", host),
+		)
+		o.ObserveInt64(ins.trackers, st.trackers, attrs)
+		if st.seeders >= 0 {
+			o.ObserveInt64(ins.trackerSeeders, int64(st.seeders), attrs)
+		}
+		if st.leechers >= 0 {
+			o.ObserveInt64(ins.trackerLeechers, int64(st.leechers), attrs)
+		}
+	}
+}
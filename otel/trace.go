@@ -0,0 +1,71 @@
+// Package otel integrates the Transmission client with OpenTelemetry.
+// It records spans for RPC requests and reports the metrics of a
+// daemon, for pushing them to an OTLP collector.
+//
+// It is a separate module so that the main module doesn't depend on
+// OpenTelemetry.
+package otel
+
+import (
+	"context"
+	"encoding/json"
+
+	"honnef.co/go/transmission"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "honnef.co/go/transmission/otel"
+
+// Observer records a span for every request of a client. Use it by
+// setting transmission.Client.Observer.
+type Observer struct {
+	tracer trace.Tracer
+}
+
+var _ transmission.RequestObserver = (*Observer)(nil)
+
+func NewObserver(tp trace.TracerProvider) *Observer {
+	return &Observer{tracer: tp.Tracer(instrumentationName)}
+}
+
+func (o *Observer) StartRequest(ctx context.Context, method string, args interface{}) (context.Context, func(transmission.RequestStats, error)) {
+	ctx, span := o.tracer.Start(ctx, "transmission "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "transmission"),
+			attribute.String("rpc.method", method),
+		))
+	return ctx, func(stats transmission.RequestStats, err error) {
+		span.SetAttributes(
+			attribute.String("transmission.result", stats.Result),
+			attribute.Int("transmission.request.size", stats.RequestBytes),
+			attribute.Int("transmission.response.size", stats.ResponseBytes),
+		)
+		if n, ok := torrentCount(stats.Arguments); ok {
+			span.SetAttributes(attribute.Int("transmission.torrents", n))
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// torrentCount returns the number of torrents in the arguments of a
+// response, if they contain any.
+func torrentCount(args json.RawMessage) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	var body struct {
+		Torrents []json.RawMessage `json:"torrents"`
+	}
+	if err := json.Unmarshal(args, &body); err != nil || body.Torrents == nil {
+		return 0, false
+	}
+	return len(body.Torrents), true
+}
@@ -0,0 +1,461 @@
+// Package policy implements rule-based seeding policies, which stop,
+// remove, move or relabel torrents once they have seeded enough.
+//
+// A policy is a list of rules. Every torrent is governed by the first
+// rule whose selectors match it. The rule's action is taken once any
+// of its triggers is met, but never before all of its requirements
+// are. A rule without triggers never acts, which protects the
+// torrents it matches from later rules.
+//
+// Policies are usually written in YAML:
+//
+//	rules:
+//	  - name: tracker-x
+//	    trackers: ["*.tracker-x.org"]
+//	    min_seeding_time: 72h
+//	    ratio: 1
+//	    action: {type: stop}
+//	  - name: public
+//	    private: false
+//	    ratio: 2
+//	    seeding_time: 7d
+//	    action: {type: remove-data}
+//	  - name: stale
+//	    filter: "label:temp and activity>14d"
+//	    age: 30d
+//	    action: {type: remove}
+//	  - name: archive
+//	    labels: [seeding]
+//	    seeding_time: 30d
+//	    action: {type: label, labels: [archived], remove: [seeding]}
+//
+// Label actions add Labels and remove Remove, or, with replace: true,
+// replace all of a torrent's labels with Labels.
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/filter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Fields are the torrent fields that policies are evaluated on.
+var Fields = []string{
+	"id", "hashString", "name", "status", "uploadRatio", "secondsSeeding", "isPrivate",
+	"trackerStats", "labels", "addedDate", "leftUntilDone", "downloadDir",
+}
+
+// Duration is a time.Duration that is written as a string, such as
+// "72h". Days are also supported, as in "7d".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	s := string(b)
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		*d = Duration(n * float64(24*time.Hour))
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Action types.
+const (
+	ActionStop       = "stop"
+	ActionRemove     = "remove"
+	ActionRemoveData = "remove-data"
+	ActionMove       = "move"
+	ActionLabel      = "label"
+)
+
+type Action struct {
+	// Type is one of the Action constants.
+	Type string `yaml:"type" json:"type"`
+	// Location is the new location for ActionMove.
+	Location string `yaml:"location,omitempty" json:"location,omitempty"`
+	// Labels are added to the torrent by ActionLabel.
+	Labels []string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	// Remove are labels that ActionLabel removes from the torrent.
+	Remove []string `yaml:"remove,omitempty" json:"remove,omitempty"`
+	// Replace makes ActionLabel replace all of the torrent's labels
+	// with Labels.
+	Replace bool `yaml:"replace,omitempty" json:"replace,omitempty"`
+}
+
+func (a Action) String() string {
+	switch a.Type {
+	case ActionMove:
+		return "move to " + a.Location
+	case ActionLabel:
+		if a.Replace {
+			return "set labels to " + strings.Join(a.Labels, ",")
+		}
+		var parts []string
+		if len(a.Labels) > 0 {
+			parts = append(parts, "label "+strings.Join(a.Labels, ","))
+		}
+		if len(a.Remove) > 0 {
+			parts = append(parts, "unlabel "+strings.Join(a.Remove, ","))
+		}
+		return strings.Join(parts, ", ")
+	default:
+		return a.Type
+	}
+}
+
+type Rule struct {
+	Name string `yaml:"name"`
+
+	// Selectors. A torrent must match all of them.
+
+	// Private, if set, matches private or public torrents only.
+	Private *bool `yaml:"private"`
+	// Trackers match torrents that have a tracker whose host matches
+	// any of the patterns, as in path.Match.
+	Trackers []string `yaml:"trackers"`
+	// Labels match torrents that have all of the labels.
+	Labels []string `yaml:"labels"`
+	// Incomplete also matches torrents that haven't finished
+	// downloading.
+	Incomplete bool `yaml:"incomplete"`
+	// Filter matches torrents that match the filter expression, as in
+	// the filter package.
+	Filter string `yaml:"filter"`
+
+	// Triggers. The action is taken once any of them is met.
+
+	Ratio       float64  `yaml:"ratio"`
+	SeedingTime Duration `yaml:"seeding_time"`
+	// Age is the time since the torrent was added.
+	Age Duration `yaml:"age"`
+
+	// Requirements. The action is not taken before all of them are
+	// met.
+
+	MinRatio       float64  `yaml:"min_ratio"`
+	MinSeedingTime Duration `yaml:"min_seeding_time"`
+
+	Action Action `yaml:"action"`
+
+	// filter is Filter, compiled. It is compiled when first needed,
+	// and again if Filter changes.
+	filter    *filter.Filter
+	filterSrc string
+}
+
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Load reads a policy from a YAML file.
+func Load(file string) (*Policy, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := yaml.UnmarshalStrict(b, &p); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", file, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return &p, nil
+}
+
+// Validate checks the policy for errors and compiles the rules'
+// filters.
+func (p *Policy) Validate() error {
+	for i, r := range p.Rules {
+		name := ruleName(i, &r)
+		if err := p.Rules[i].compileFilter(); err != nil {
+			return fmt.Errorf("rule %s: invalid filter: %s", name, err)
+		}
+		for _, pat := range r.Trackers {
+			if _, err := path.Match(pat, ""); err != nil {
+				return fmt.Errorf("rule %s: invalid tracker pattern %q", name, pat)
+			}
+		}
+		if !r.hasTriggers() {
+			if r.Action.Type != "" {
+				return fmt.Errorf("rule %s: action without triggers", name)
+			}
+			continue
+		}
+		switch r.Action.Type {
+		case ActionStop, ActionRemove, ActionRemoveData:
+		case ActionMove:
+			if r.Action.Location == "" {
+				return fmt.Errorf("rule %s: move action without location", name)
+			}
+		case ActionLabel:
+			switch {
+			case r.Action.Replace && len(r.Action.Remove) > 0:
+				return fmt.Errorf("rule %s: label action can't both replace and remove labels", name)
+			case !r.Action.Replace && len(r.Action.Labels) == 0 && len(r.Action.Remove) == 0:
+				return fmt.Errorf("rule %s: label action without labels", name)
+			}
+		case "":
+			return fmt.Errorf("rule %s: triggers without action", name)
+		default:
+			return fmt.Errorf("rule %s: unknown action %q", name, r.Action.Type)
+		}
+	}
+	return nil
+}
+
+func ruleName(i int, r *Rule) string {
+	if r.Name == "" {
+		return "#" + strconv.Itoa(i+1)
+	}
+	return r.Name
+}
+
+// compileFilter compiles the rule's filter, unless that has already
+// been done.
+func (r *Rule) compileFilter() error {
+	if r.Filter == "" {
+		r.filter, r.filterSrc = nil, ""
+		return nil
+	}
+	if r.filter != nil && r.filterSrc == r.Filter {
+		return nil
+	}
+	f, err := filter.Compile(r.Filter)
+	if err != nil {
+		return err
+	}
+	r.filter, r.filterSrc = f, r.Filter
+	return nil
+}
+
+func (r *Rule) hasTriggers() bool {
+	return r.Ratio > 0 || r.SeedingTime > 0 || r.Age > 0
+}
+
+// Fields returns the torrent fields that the policy is evaluated on,
+// which are Fields and the fields needed by the rules' filters.
+// Invalid filters are skipped; Plan reports them.
+func (p *Policy) Fields() []string {
+	out := append([]string(nil), Fields...)
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.compileFilter() == nil && r.filter != nil {
+			out = append(out, r.filter.Fields()...)
+		}
+	}
+	return out
+}
+
+func (r *Rule) matches(info *transmission.TorrentInfo, now time.Time) bool {
+	if r.Private != nil && *r.Private != info.IsPrivate {
+		return false
+	}
+	if r.filter != nil && !r.filter.MatchAt(info, now) {
+		return false
+	}
+	if !r.Incomplete && info.LeftUntilDone > 0 {
+		return false
+	}
+	for _, l := range r.Labels {
+		if !hasLabel(info, l) {
+			return false
+		}
+	}
+	if len(r.Trackers) > 0 {
+		found := false
+		for i := range info.TrackerStats {
+			host := info.TrackerStats[i].Hostname()
+			for _, pat := range r.Trackers {
+				if ok, _ := path.Match(pat, host); ok {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// reason returns a description of the trigger that was met, or the
+// empty string if the rule shouldn't act yet.
+func (r *Rule) reason(info *transmission.TorrentInfo, now time.Time) string {
+	if info.UploadRatio < r.MinRatio || info.SecondsSeeding < time.Duration(r.MinSeedingTime) {
+		return ""
+	}
+	switch {
+	case r.Ratio > 0 && info.UploadRatio >= r.Ratio:
+		return fmt.Sprintf("ratio %.2f >= %g", info.UploadRatio, r.Ratio)
+	case r.SeedingTime > 0 && info.SecondsSeeding >= time.Duration(r.SeedingTime):
+		return fmt.Sprintf("seeding for %s >= %s", info.SecondsSeeding, time.Duration(r.SeedingTime))
+	case r.Age > 0 && now.Sub(info.AddedDate) >= time.Duration(r.Age):
+		return fmt.Sprintf("added %s ago >= %s", now.Sub(info.AddedDate).Truncate(time.Second), time.Duration(r.Age))
+	}
+	return ""
+}
+
+func hasLabel(info *transmission.TorrentInfo, label string) bool {
+	return contains(info.Labels, label)
+}
+
+// A Planned action is an action that a policy wants to take on a
+// torrent.
+type Planned struct {
+	ID     int    `json:"id"`
+	Hash   string `json:"hash"`
+	Name   string `json:"name"`
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+	Action Action `json:"action"`
+
+	// labels are the torrent's labels, which ActionLabel changes.
+	labels []string
+}
+
+// Plan determines the actions to take on torrents, which must have
+// been requested with at least the fields returned by p.Fields. The
+// policy should have been validated; Plan only fails if a rule's
+// filter is invalid. Actions that wouldn't change anything, such as
+// stopping a stopped torrent, are omitted.
+func (p *Policy) Plan(torrents []transmission.TorrentInfo, now time.Time) ([]Planned, error) {
+	for i := range p.Rules {
+		if err := p.Rules[i].compileFilter(); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid filter: %s", ruleName(i, &p.Rules[i]), err)
+		}
+	}
+	var out []Planned
+	for i := range torrents {
+		info := &torrents[i]
+		for j := range p.Rules {
+			r := &p.Rules[j]
+			if !r.matches(info, now) {
+				continue
+			}
+			if reason := r.reason(info, now); reason != "" && !noop(r.Action, info) {
+				out = append(out, Planned{
+					ID:     info.ID,
+					Hash:   info.Hash,
+					Name:   info.Name,
+					Rule:   r.Name,
+					Reason: reason,
+					Action: r.Action,
+					labels: info.Labels,
+				})
+			}
+			break
+		}
+	}
+	return out, nil
+}
+
+func noop(a Action, info *transmission.TorrentInfo) bool {
+	switch a.Type {
+	case ActionStop:
+		return info.Status == transmission.TorrentStatusStopped
+	case ActionMove:
+		return path.Clean(info.DownloadDir) == path.Clean(a.Location)
+	case ActionLabel:
+		labels := a.labels(info.Labels)
+		if len(labels) != len(info.Labels) {
+			return false
+		}
+		for i := range labels {
+			if labels[i] != info.Labels[i] {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// labels returns the labels that ActionLabel leaves a torrent with.
+func (a Action) labels(current []string) []string {
+	if a.Replace {
+		return append([]string{}, a.Labels...)
+	}
+	out := []string{}
+	for _, l := range current {
+		if !contains(a.Remove, l) {
+			out = append(out, l)
+		}
+	}
+	for _, l := range a.Labels {
+		if !contains(out, l) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Apply takes the planned actions. It continues after failures and
+// returns an error describing all of them.
+func Apply(cl *transmission.Client, plan []Planned) error {
+	var errs []string
+	for _, pl := range plan {
+		if err := apply(cl, pl); err != nil {
+			errs = append(errs, fmt.Sprintf("%s (%s): %s", pl.Name, pl.Action, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("couldn't apply policy: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func apply(cl *transmission.Client, pl Planned) error {
+	ids := []string{pl.Hash}
+	switch pl.Action.Type {
+	case ActionStop:
+		return cl.StopTorrent(ids)
+	case ActionRemove:
+		return cl.RemoveTorrent(ids, false)
+	case ActionRemoveData:
+		return cl.RemoveTorrent(ids, true)
+	case ActionMove:
+		return cl.MoveTorrent(ids, pl.Action.Location, true)
+	case ActionLabel:
+		return cl.SetTorrent(ids, &transmission.TorrentSettings{Labels: pl.Action.labels(pl.labels)})
+	default:
+		return fmt.Errorf("unknown action %q", pl.Action.Type)
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"honnef.co/go/transmission"
+)
+
+func TestPlan(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	yes, no := true, false
+	torrent := func(id int, f func(info *transmission.TorrentInfo)) transmission.TorrentInfo {
+		info := transmission.TorrentInfo{
+			ID:             id,
+			Hash:           string(rune('a' + id)),
+			Status:         transmission.TorrentStatusSeed,
+			UploadRatio:    1.5,
+			SecondsSeeding: 48 * time.Hour,
+			AddedDate:      now.Add(-72 * time.Hour),
+			DownloadDir:    "/data",
+		}
+		if f != nil {
+			f(&info)
+		}
+		return info
+	}
+	tracker := func(announce, host string) func(info *transmission.TorrentInfo) {
+		return func(info *transmission.TorrentInfo) {
+			info.TrackerStats = []transmission.TrackerStats{{Announce: announce, Host: host}}
+		}
+	}
+	labels := func(ls ...string) func(info *transmission.TorrentInfo) {
+		return func(info *transmission.TorrentInfo) { info.Labels = ls }
+	}
+
+	tests := []struct {
+		name     string
+		rules    []Rule
+		torrents []transmission.TorrentInfo
+		// want maps torrent IDs to the names of the rules that act on
+		// them.
+		want map[int]string
+	}{
+		{
+			name:     "ratio trigger",
+			rules:    []Rule{{Name: "r", Ratio: 1, Action: Action{Type: ActionStop}}},
+			torrents: []transmission.TorrentInfo{torrent(1, nil), torrent(2, func(info *transmission.TorrentInfo) { info.UploadRatio = 0.5 })},
+			want:     map[int]string{1: "r"},
+		},
+		{
+			name:     "seeding time and age triggers",
+			rules:    []Rule{{Name: "seed", SeedingTime: Duration(24 * time.Hour), Action: Action{Type: ActionStop}}, {Name: "age", Age: Duration(time.Hour), Action: Action{Type: ActionRemove}}},
+			torrents: []transmission.TorrentInfo{torrent(1, nil)},
+			want:     map[int]string{1: "seed"},
+		},
+		{
+			name:     "requirements not met",
+			rules:    []Rule{{Name: "r", Ratio: 1, MinSeedingTime: Duration(72 * time.Hour), Action: Action{Type: ActionStop}}},
+			torrents: []transmission.TorrentInfo{torrent(1, nil)},
+			want:     map[int]string{},
+		},
+		{
+			name: "rule without triggers protects torrents",
+			rules: []Rule{
+				{Name: "keep", Private: &yes},
+				{Name: "r", Ratio: 1, Action: Action{Type: ActionStop}},
+			},
+			torrents: []transmission.TorrentInfo{torrent(1, func(info *transmission.TorrentInfo) { info.IsPrivate = true }), torrent(2, nil)},
+			want:     map[int]string{2: "r"},
+		},
+		{
+			name:     "public only",
+			rules:    []Rule{{Name: "r", Private: &no, Ratio: 1, Action: Action{Type: ActionStop}}},
+			torrents: []transmission.TorrentInfo{torrent(1, func(info *transmission.TorrentInfo) { info.IsPrivate = true }), torrent(2, nil)},
+			want:     map[int]string{2: "r"},
+		},
+		{
+			name:  "tracker hosts of all daemon versions",
+			rules: []Rule{{Name: "r", Trackers: []string{"*.example.org"}, Ratio: 1, Action: Action{Type: ActionStop}}},
+			torrents: []transmission.TorrentInfo{
+				torrent(1, tracker("https://tracker.example.org:443/announce", "https://tracker.example.org:443")),
+				torrent(2, tracker("udp://tracker.example.org:6969", "tracker.example.org:6969")),
+				torrent(3, tracker("http://tracker.example.com/announce", "http://tracker.example.com:80")),
+				torrent(4, tracker("", "http://tracker.example.org:80")),
+				torrent(5, nil),
+			},
+			want: map[int]string{1: "r", 2: "r", 4: "r"},
+		},
+		{
+			name:     "labels",
+			rules:    []Rule{{Name: "r", Labels: []string{"a", "b"}, Ratio: 1, Action: Action{Type: ActionStop}}},
+			torrents: []transmission.TorrentInfo{torrent(1, labels("a")), torrent(2, labels("b", "c", "a"))},
+			want:     map[int]string{2: "r"},
+		},
+		{
+			name:  "incomplete",
+			rules: []Rule{{Name: "r", Ratio: 1, Action: Action{Type: ActionStop}}, {Name: "i", Incomplete: true, Ratio: 1, Action: Action{Type: ActionRemove}}},
+			torrents: []transmission.TorrentInfo{torrent(1, func(info *transmission.TorrentInfo) {
+				info.LeftUntilDone = 1
+			})},
+			want: map[int]string{1: "i"},
+		},
+		{
+			name:  "no-ops",
+			rules: []Rule{{Name: "r", Labels: []string{"stop"}, Ratio: 1, Action: Action{Type: ActionStop}}, {Name: "m", Ratio: 1, Action: Action{Type: ActionMove, Location: "/data/"}}},
+			torrents: []transmission.TorrentInfo{torrent(1, func(info *transmission.TorrentInfo) {
+				info.Labels = []string{"stop"}
+				info.Status = transmission.TorrentStatusStopped
+			}), torrent(2, nil)},
+			want: map[int]string{},
+		},
+	}
+	for _, tt := range tests {
+		p := &Policy{Rules: tt.rules}
+		if err := p.Validate(); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		plan, err := p.Plan(tt.torrents, now)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		got := map[int]string{}
+		for _, pl := range plan {
+			if _, ok := got[pl.ID]; ok {
+				t.Errorf("%s: torrent %d planned twice", tt.name, pl.ID)
+			}
+			got[pl.ID] = pl.Rule
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLabelAction(t *testing.T) {
+	tests := []struct {
+		action  Action
+		current []string
+		want    []string
+		noop    bool
+	}{
+		{Action{Labels: []string{"a"}}, []string{"a"}, []string{"a"}, true},
+		{Action{Labels: []string{"a", "b"}}, []string{"c", "a"}, []string{"c", "a", "b"}, false},
+		{Action{Remove: []string{"a"}}, []string{"a", "b"}, []string{"b"}, false},
+		{Action{Remove: []string{"a"}}, []string{"b"}, []string{"b"}, true},
+		{Action{Labels: []string{"new"}, Remove: []string{"old"}}, []string{"old", "x"}, []string{"x", "new"}, false},
+		{Action{Labels: []string{"a"}, Replace: true}, []string{"b", "c"}, []string{"a"}, false},
+		{Action{Labels: []string{"a"}, Replace: true}, []string{"a"}, []string{"a"}, true},
+		{Action{Replace: true}, []string{"b"}, []string{}, false},
+	}
+	for _, tt := range tests {
+		tt.action.Type = ActionLabel
+		if got := tt.action.labels(tt.current); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s on %v: got %v, want %v", tt.action, tt.current, got, tt.want)
+		}
+		info := &transmission.TorrentInfo{Labels: tt.current}
+		if got := noop(tt.action, info); got != tt.noop {
+			t.Errorf("%s on %v: noop = %t, want %t", tt.action, tt.current, got, tt.noop)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		ok   bool
+	}{
+		{"valid", Rule{Ratio: 1, Action: Action{Type: ActionStop}}, true},
+		{"no triggers", Rule{}, true},
+		{"action without triggers", Rule{Action: Action{Type: ActionStop}}, false},
+		{"triggers without action", Rule{Ratio: 1}, false},
+		{"unknown action", Rule{Ratio: 1, Action: Action{Type: "explode"}}, false},
+		{"move without location", Rule{Ratio: 1, Action: Action{Type: ActionMove}}, false},
+		{"label without labels", Rule{Ratio: 1, Action: Action{Type: ActionLabel}}, false},
+		{"remove labels", Rule{Ratio: 1, Action: Action{Type: ActionLabel, Remove: []string{"a"}}}, true},
+		{"clear labels", Rule{Ratio: 1, Action: Action{Type: ActionLabel, Replace: true}}, true},
+		{"replace and remove", Rule{Ratio: 1, Action: Action{Type: ActionLabel, Replace: true, Remove: []string{"a"}}}, false},
+		{"invalid tracker pattern", Rule{Trackers: []string{"["}}, false},
+		{"invalid filter", Rule{Filter: "ratio>"}, false},
+	}
+	for _, tt := range tests {
+		p := &Policy{Rules: []Rule{tt.rule}}
+		if err := p.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok = %t", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestPlanFilter(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	torrents := []transmission.TorrentInfo{
+		{ID: 1, UploadRatio: 2, Labels: []string{"temp"}},
+		{ID: 2, UploadRatio: 2},
+	}
+	// The policy isn't validated, so the filter has to be compiled by
+	// Plan.
+	p := &Policy{Rules: []Rule{{Name: "temp", Filter: "label:temp", Ratio: 1, Action: Action{Type: ActionRemove}}}}
+	plan, err := p.Plan(torrents, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 1 || plan[0].ID != 1 {
+		t.Errorf("got plan %+v, want torrent 1 only", plan)
+	}
+
+	// Changing the filter recompiles it.
+	p.Rules[0].Filter = "not label:temp"
+	plan, err = p.Plan(torrents, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 1 || plan[0].ID != 2 {
+		t.Errorf("got plan %+v, want torrent 2 only", plan)
+	}
+
+	p.Rules[0].Filter = "ratio>"
+	if _, err := p.Plan(torrents, now); err == nil {
+		t.Error("Plan succeeded with an invalid filter")
+	}
+}
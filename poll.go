@@ -0,0 +1,76 @@
+package transmission
+
+import (
+	"sort"
+)
+
+// A Poller maintains an up-to-date list of torrents. The first call to
+// Poll fetches all torrents, subsequent calls only fetch the torrents
+// that have been active recently. This greatly reduces the cost of
+// frequently polling a daemon with many torrents.
+type Poller struct {
+	Client *Client
+	// The fields to fetch. The "id" field is always fetched.
+	Fields []string
+	// If non-zero, every FullRefresh'th call to Poll fetches all
+	// torrents, to pick up changes to inactive torrents, such as
+	// changed settings.
+	FullRefresh int
+
+	torrents map[int]TorrentInfo
+	polls    int
+}
+
+// Poll updates the list of torrents and returns it, sorted by ID.
+func (p *Poller) Poll() ([]TorrentInfo, error) {
+	fields := p.Fields
+	if !hasField(fields, "id") {
+		fields = append(fields[:len(fields):len(fields)], "id")
+	}
+
+	full := p.torrents == nil || (p.FullRefresh > 0 && p.polls%p.FullRefresh == 0)
+	p.polls++
+	if full {
+		infos, err := p.Client.TorrentInfo(nil, fields)
+		if err != nil {
+			return nil, err
+		}
+		p.torrents = make(map[int]TorrentInfo, len(infos))
+		for _, info := range infos {
+			p.torrents[info.ID] = info
+		}
+	} else {
+		active, removed, err := p.Client.RecentlyActiveTorrents(fields)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range active {
+			p.torrents[info.ID] = info
+		}
+		for _, id := range removed {
+			delete(p.torrents, id)
+		}
+	}
+
+	out := make([]TorrentInfo, 0, len(p.torrents))
+	for _, info := range p.torrents {
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+// Reset discards the current list of torrents, causing the next call
+// to Poll to fetch all torrents.
+func (p *Poller) Reset() {
+	p.torrents = nil
+}
+
+func hasField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -2,7 +2,11 @@ package transmission
 
 import (
 	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
 	"time"
+	"unicode"
 )
 
 type Response struct {
@@ -44,12 +48,120 @@ type NewTorrent struct {
 	PriorityNormal    []int    `json:"priority-normal,omitempty"`
 }
 
+// TorrentSettings describes changes to torrents. Nil fields are left
+// unchanged.
+type TorrentSettings struct {
+	BandwidthPriority   *Priority `json:"bandwidthPriority,omitempty"`
+	DownloadLimit       *int      `json:"downloadLimit,omitempty"`
+	DownloadLimited     *bool     `json:"downloadLimited,omitempty"`
+	FilesWanted         []int     `json:"files-wanted,omitempty"`
+	FilesUnwanted       []int     `json:"files-unwanted,omitempty"`
+	HonorsSessionLimits *bool     `json:"honorsSessionLimits,omitempty"`
+	// The torrents' labels. A non-nil, empty slice removes all labels.
+	Labels         []string `json:"-"`
+	Location       string   `json:"location,omitempty"`
+	PeerLimit      *int     `json:"peer-limit,omitempty"`
+	PriorityHigh   []int    `json:"priority-high,omitempty"`
+	PriorityLow    []int    `json:"priority-low,omitempty"`
+	PriorityNormal []int    `json:"priority-normal,omitempty"`
+	QueuePosition  *int     `json:"queuePosition,omitempty"`
+	SeedIdleLimit  *int     `json:"seedIdleLimit,omitempty"`
+	SeedIdleMode   *int     `json:"seedIdleMode,omitempty"`
+	SeedRatioLimit *float64 `json:"seedRatioLimit,omitempty"`
+	SeedRatioMode  *int     `json:"seedRatioMode,omitempty"`
+	// Announce URLs to add.
+	TrackerAdd []string `json:"trackerAdd,omitempty"`
+	// IDs of trackers to remove.
+	TrackerRemove []int `json:"trackerRemove,omitempty"`
+	// Pairs of tracker IDs and their new announce URLs.
+	TrackerReplace []TrackerReplacement `json:"-"`
+	UploadLimit    *int                 `json:"uploadLimit,omitempty"`
+	UploadLimited  *bool                `json:"uploadLimited,omitempty"`
+}
+
+type TrackerReplacement struct {
+	ID       int
+	Announce string
+}
+
+func (s TorrentSettings) MarshalJSON() ([]byte, error) {
+	type settings TorrentSettings
+	out := struct {
+		settings
+		Labels         *[]string     `json:"labels,omitempty"`
+		TrackerReplace []interface{} `json:"trackerReplace,omitempty"`
+	}{settings: settings(s)}
+	if s.Labels != nil {
+		out.Labels = &s.Labels
+	}
+	for _, r := range s.TrackerReplace {
+		out.TrackerReplace = append(out.TrackerReplace, r.ID, r.Announce)
+	}
+	return json.Marshal(out)
+}
+
+// SessionSettings describes changes to the session. Nil fields are
+// left unchanged. See SessionInfo for the meaning of the fields.
+type SessionSettings struct {
+	AltSpeedDown              *int     `json:"alt-speed-down,omitempty"`
+	AltSpeedEnabled           *bool    `json:"alt-speed-enabled,omitempty"`
+	AltSpeedTimeBegin         *int     `json:"alt-speed-time-begin,omitempty"`
+	AltSpeedTimeEnabled       *bool    `json:"alt-speed-time-enabled,omitempty"`
+	AltSpeedTimeEnd           *int     `json:"alt-speed-time-end,omitempty"`
+	AltSpeedTimeDay           *int     `json:"alt-speed-time-day,omitempty"`
+	AltSpeedUp                *int     `json:"alt-speed-up,omitempty"`
+	BlocklistUrl              *string  `json:"blocklist-url,omitempty"`
+	BlocklistEnabled          *bool    `json:"blocklist-enabled,omitempty"`
+	CacheSizeMB               *int     `json:"cache-size-mb,omitempty"`
+	DownloadDir               *string  `json:"download-dir,omitempty"`
+	DownloadQueueSize         *int     `json:"download-queue-size,omitempty"`
+	DownloadQueueEnabled      *bool    `json:"download-queue-enabled,omitempty"`
+	DhtEnabled                *bool    `json:"dht-enabled,omitempty"`
+	Encryption                *string  `json:"encryption,omitempty"`
+	IdleSeedingLimit          *int     `json:"idle-seeding-limit,omitempty"`
+	IdleSeedingLimitEnabled   *bool    `json:"idle-seeding-limit-enabled,omitempty"`
+	IncompleteDir             *string  `json:"incomplete-dir,omitempty"`
+	IncompleteDirEnabled      *bool    `json:"incomplete-dir-enabled,omitempty"`
+	LpdEnabled                *bool    `json:"lpd-enabled,omitempty"`
+	PeerLimitGlobal           *int     `json:"peer-limit-global,omitempty"`
+	PeerLimitPerTorrent       *int     `json:"peer-limit-per-torrent,omitempty"`
+	PexEnabled                *bool    `json:"pex-enabled,omitempty"`
+	PeerPort                  *int     `json:"peer-port,omitempty"`
+	PeerPortRandomOnStart     *bool    `json:"peer-port-random-on-start,omitempty"`
+	PortForwardingEnabled     *bool    `json:"port-forwarding-enabled,omitempty"`
+	QueueStalledEnabled       *bool    `json:"queue-stalled-enabled,omitempty"`
+	QueueStalledMinutes       *int     `json:"queue-stalled-minutes,omitempty"`
+	RenamePartialFiles        *bool    `json:"rename-partial-files,omitempty"`
+	ScriptTorrentDoneFilename *string  `json:"script-torrent-done-filename,omitempty"`
+	ScriptTorrentDoneEnabled  *bool    `json:"script-torrent-done-enabled,omitempty"`
+	SeedRatioLimit            *float64 `json:"seedRatioLimit,omitempty"`
+	SeedRatioLimited          *bool    `json:"seedRatioLimited,omitempty"`
+	SeedQueueSize             *int     `json:"seed-queue-size,omitempty"`
+	SeedQueueEnabled          *bool    `json:"seed-queue-enabled,omitempty"`
+	SpeedLimitDown            *int     `json:"speed-limit-down,omitempty"`
+	SpeedLimitDownEnabled     *bool    `json:"speed-limit-down-enabled,omitempty"`
+	SpeedLimitUp              *int     `json:"speed-limit-up,omitempty"`
+	SpeedLimitUpEnabled       *bool    `json:"speed-limit-up-enabled,omitempty"`
+	StartAddedTorrents        *bool    `json:"start-added-torrents,omitempty"`
+	TrashOriginalTorrentFiles *bool    `json:"trash-original-torrent-files,omitempty"`
+	UtpEnabled                *bool    `json:"utp-enabled,omitempty"`
+}
+
 type AddedTorrent struct {
 	ID   int
 	Name string
 	Hash string
 }
 
+// RenamedPath is the outcome of renaming a path of a torrent.
+type RenamedPath struct {
+	ID int `json:"id"`
+	// Path is the old path.
+	Path string `json:"path"`
+	// Name is the new name.
+	Name string `json:"name"`
+}
+
 type TorrentInfo struct {
 	// The last time we uploaded or downloaded piece data on this torrent.
 	ActivityDate time.Time
@@ -141,7 +253,7 @@ type TorrentInfo struct {
 	PercentDone float64
 	PieceCount  int
 	PieceSize   int
-	Pieces      []byte
+	Pieces      Bitfield
 	Priorities  []Priority
 	// This torrent's queue position.
 	// All torrents have a queue position, even if it's not queued.
@@ -260,11 +372,11 @@ type torrentInfo struct {
 	PercentDone             float64        `json:"percentDone"`
 	PieceCount              int            `json:"pieceCount"`
 	PieceSize               int            `json:"pieceSize"`
-	Pieces                  []byte         `json:"pieces"`
+	Pieces                  Bitfield       `json:"pieces"`
 	Priorities              []Priority     `json:"priorities"`
 	QueuePosition           int            `json:"queuePosition"`
-	RateDownload            int            `json:"rateDownload (B/s)"`
-	RateUpload              int            `json:"rateUpload (B/s)"`
+	RateDownload            int            `json:"rateDownload"`
+	RateUpload              int            `json:"rateUpload"`
 	RecheckProgress         float64        `json:"recheckProgress"`
 	SecondsDownloading      int            `json:"secondsDownloading"`
 	SecondsSeeding          int            `json:"secondsSeeding"`
@@ -303,8 +415,49 @@ type Peer struct {
 	PeerIsInterested   bool    `json:"peerIsInterested"`
 	Port               int     `json:"port"`
 	Progress           float64 `json:"progress"`
-	RateToClient       int     `json:"rateToClient (B/s)"`
-	RateToPeer         int     `json:"rateToPeer (B/s)"`
+	RateToClient       int     `json:"rateToClient"`
+	RateToPeer         int     `json:"rateToPeer"`
+}
+
+// UnversionedClientName returns the name of the peer's client without
+// its version, which is useful for grouping peers. "Transmission
+// 4.0.5", "Transmission/3.00" and "Transmission-4.0" all become
+// "Transmission". Peers without a client name are "unknown".
+func (p *Peer) UnversionedClientName() string {
+	fields := strings.Fields(p.ClientName)
+	for i, f := range fields {
+		if isVersion(f) {
+			fields = fields[:i]
+			break
+		}
+		if j := versionSuffix(f); j > 0 {
+			fields[i] = f[:j]
+			fields = fields[:i+1]
+			break
+		}
+	}
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	return strings.Join(fields, " ")
+}
+
+// versionSuffix returns the index of the separator in front of a
+// version suffix such as "/4.3.1" or "-1.2", or -1.
+func versionSuffix(s string) int {
+	for i, r := range s {
+		if (r == '/' || r == '-') && isVersion(s[i+1:]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isVersion reports whether s looks like a version number, such as
+// "4.0.5" or "v4.3.1".
+func isVersion(s string) bool {
+	s = strings.TrimPrefix(s, "v")
+	return s != "" && unicode.IsDigit([]rune(s)[0])
 }
 
 type SessionInfo struct {
@@ -512,6 +665,24 @@ type TrackerStats struct {
 	Tier int
 }
 
+// Hostname returns the host name of the tracker, without scheme or
+// port. It is derived from the announce URL, because the format of
+// Host differs between versions of Transmission: 2.x and 3.x report
+// "http://host:port", 4.x "host:port".
+func (tr *TrackerStats) Hostname() string {
+	if u, err := url.Parse(tr.Announce); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	host := tr.Host
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+len("://"):]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
 func convertTorrentInfo(in *torrentInfo, out *TorrentInfo) {
 	*out = TorrentInfo{
 		ActivityDate:            time.Unix(int64(in.ActivityDate), 0),
@@ -0,0 +1,412 @@
+package reconcile
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"honnef.co/go/transmission"
+)
+
+// Kinds of changes, named after the RPC methods that make them.
+const (
+	ChangeSession  = transmission.MethodSessionSet
+	ChangeAdd      = transmission.MethodTorrentAdd
+	ChangeSet      = transmission.MethodTorrentSet
+	ChangeLocation = transmission.MethodTorrentSetLocation
+	ChangeRemove   = transmission.MethodTorrentRemove
+)
+
+// A Change is a single call that brings the daemon closer to the
+// desired state.
+type Change struct {
+	// Kind is one of the Change constants.
+	Kind string `json:"kind"`
+	Hash string `json:"hash,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Details describe what changes, such as "labels: [] -> [linux]".
+	Details []string `json:"details,omitempty"`
+
+	session *transmission.SessionSettings
+	add     *transmission.NewTorrent
+	// settings are applied by ChangeSet, and after adding a torrent
+	// by ChangeAdd.
+	settings   *transmission.TorrentSettings
+	location   string
+	move       bool
+	deleteData bool
+}
+
+func (c Change) String() string {
+	s := c.Kind
+	if c.Name != "" {
+		s += " " + c.Name
+	}
+	if len(c.Details) > 0 {
+		s += ": " + strings.Join(c.Details, "; ")
+	}
+	return s
+}
+
+// Plan compares the document to the daemon's current state and returns
+// the changes needed to reconcile them. Torrent sources are fetched if
+// torrents need to be added or aren't identified by their hashes.
+func (d *Document) Plan(cl *transmission.Client) ([]Change, error) {
+	var plan []Change
+	if len(d.Session) > 0 {
+		c, err := d.planSession(cl)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			plan = append(plan, *c)
+		}
+	}
+
+	infos, err := cl.TorrentInfo(nil, Fields)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]*transmission.TorrentInfo{}
+	for i := range infos {
+		existing[strings.ToLower(infos[i].Hash)] = &infos[i]
+	}
+
+	listed := map[string]bool{}
+	for i := range d.Torrents {
+		t := &d.Torrents[i]
+		hash := strings.ToLower(t.Hash)
+		var src *source
+		if hash == "" || (existing[hash] == nil && t.Source != "") {
+			src, err = fetch(t.Source)
+			if err != nil {
+				return nil, err
+			}
+			if hash != "" && src.hash != hash {
+				return nil, fmt.Errorf("torrent %s: source has hash %s", hash, src.hash)
+			}
+			hash = src.hash
+		}
+		if listed[hash] {
+			return nil, fmt.Errorf("torrent %s is listed more than once", hash)
+		}
+		listed[hash] = true
+
+		info := existing[hash]
+		if info == nil {
+			if src == nil {
+				return nil, fmt.Errorf("torrent %s doesn't exist and has no source", hash)
+			}
+			plan = append(plan, d.planAdd(t, src))
+			continue
+		}
+		if t.Location != "" && path.Clean(t.Location) != path.Clean(info.DownloadDir) {
+			plan = append(plan, Change{
+				Kind:     ChangeLocation,
+				Hash:     info.Hash,
+				Name:     info.Name,
+				Details:  []string{fmt.Sprintf("location: %s -> %s", info.DownloadDir, t.Location)},
+				location: t.Location,
+				move:     d.MoveData,
+			})
+		}
+		if settings, details := diffTorrent(t, info); len(details) > 0 {
+			plan = append(plan, Change{
+				Kind:     ChangeSet,
+				Hash:     info.Hash,
+				Name:     info.Name,
+				Details:  details,
+				settings: settings,
+			})
+		}
+	}
+
+	if d.Prune {
+		for i := range infos {
+			if listed[strings.ToLower(infos[i].Hash)] {
+				continue
+			}
+			c := Change{Kind: ChangeRemove, Hash: infos[i].Hash, Name: infos[i].Name, deleteData: d.PruneData}
+			if d.PruneData {
+				c.Details = []string{"delete data"}
+			}
+			plan = append(plan, c)
+		}
+	}
+	return plan, nil
+}
+
+// planSession compares the desired session settings to the current
+// ones and returns a change that sets the differing ones, or nil.
+func (d *Document) planSession(cl *transmission.Client) (*Change, error) {
+	info, err := cl.SessionInfo(nil)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	var current map[string]interface{}
+	if err := json.Unmarshal(b, &current); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(d.Session))
+	for k := range d.Session {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	changed := map[string]interface{}{}
+	var details []string
+	for _, k := range keys {
+		// Normalize the desired value to what the current one decodes
+		// to.
+		b, err := json.Marshal(d.Session[k])
+		if err != nil {
+			return nil, err
+		}
+		var want interface{}
+		if err := json.Unmarshal(b, &want); err != nil {
+			return nil, err
+		}
+		have, ok := current[k]
+		if ok && reflect.DeepEqual(have, want) {
+			continue
+		}
+		changed[k] = d.Session[k]
+		if ok {
+			details = append(details, fmt.Sprintf("%s: %v -> %v", k, have, want))
+		} else {
+			details = append(details, fmt.Sprintf("%s: %v", k, want))
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	s, err := sessionSettings(changed)
+	if err != nil {
+		return nil, err
+	}
+	return &Change{Kind: ChangeSession, Details: details, session: s}, nil
+}
+
+func (d *Document) planAdd(t *Torrent, src *source) Change {
+	c := Change{Kind: ChangeAdd, Hash: src.hash, Name: t.Source}
+	req := &transmission.NewTorrent{DownloadDir: t.Location}
+	if src.mi != nil {
+		c.Name = src.mi.Info.Name
+		req.Metainfo = base64.StdEncoding.EncodeToString(src.data)
+		if t.Wanted != nil {
+			for i, name := range fileNames(src.mi) {
+				if !wanted(t.Wanted, name) {
+					req.FilesUnwanted = append(req.FilesUnwanted, i)
+				}
+			}
+		}
+	} else {
+		req.Filename = src.magnet
+		if t.Wanted != nil {
+			// Without metadata, we don't know the files yet. The next
+			// reconciliation will take care of them.
+			c.Details = append(c.Details, "wanted files are set once metadata is available")
+		}
+	}
+	if t.Location != "" {
+		c.Details = append(c.Details, "location: "+t.Location)
+	}
+	if len(req.FilesUnwanted) > 0 {
+		c.Details = append(c.Details, fmt.Sprintf("%d unwanted files", len(req.FilesUnwanted)))
+	}
+	c.add = req
+
+	// Everything but the files is diffed against a torrent with
+	// default settings.
+	settings, details := diffTorrent(&Torrent{
+		Labels:         t.Labels,
+		DownloadLimit:  t.DownloadLimit,
+		UploadLimit:    t.UploadLimit,
+		PeerLimit:      t.PeerLimit,
+		SeedRatioLimit: t.SeedRatioLimit,
+		Priority:       t.Priority,
+	}, &transmission.TorrentInfo{})
+	if len(details) > 0 {
+		c.settings = settings
+		c.Details = append(c.Details, details...)
+	}
+	return c
+}
+
+// diffTorrent compares a torrent's desired settings to its current
+// ones, returning the settings to change and descriptions of the
+// changes.
+func diffTorrent(t *Torrent, info *transmission.TorrentInfo) (*transmission.TorrentSettings, []string) {
+	s := &transmission.TorrentSettings{}
+	var details []string
+
+	if t.Labels != nil && !sameLabels(t.Labels, info.Labels) {
+		s.Labels = t.Labels
+		details = append(details, fmt.Sprintf("labels: [%s] -> [%s]", strings.Join(info.Labels, ", "), strings.Join(t.Labels, ", ")))
+	}
+	limit := func(name string, want *int, curLimited bool, curLimit int) (*bool, *int) {
+		if want == nil {
+			return nil, nil
+		}
+		on := *want >= 0
+		if on == curLimited && (!on || *want == curLimit) {
+			return nil, nil
+		}
+		details = append(details, fmt.Sprintf("%s: %s -> %s", name, formatLimit(curLimited, curLimit), formatLimit(on, *want)))
+		if !on {
+			return &on, nil
+		}
+		return &on, want
+	}
+	s.DownloadLimited, s.DownloadLimit = limit("download limit", t.DownloadLimit, info.DownloadLimited, info.DownloadLimit)
+	s.UploadLimited, s.UploadLimit = limit("upload limit", t.UploadLimit, info.UploadLimited, info.UploadLimit)
+	if t.PeerLimit != nil && *t.PeerLimit != info.MaxConnectedPeers {
+		s.PeerLimit = t.PeerLimit
+		details = append(details, fmt.Sprintf("peer limit: %d -> %d", info.MaxConnectedPeers, *t.PeerLimit))
+	}
+	if t.SeedRatioLimit != nil {
+		want := *t.SeedRatioLimit
+		mode := seedRatioSingle
+		if want < 0 {
+			mode = seedRatioUnlimited
+		}
+		if mode != info.SeedRatioMode || (mode == seedRatioSingle && want != info.SeedRatioLimit) {
+			s.SeedRatioMode = &mode
+			if mode == seedRatioSingle {
+				s.SeedRatioLimit = &want
+			}
+			details = append(details, fmt.Sprintf("seed ratio limit: %s -> %s", formatRatio(info.SeedRatioMode, info.SeedRatioLimit), formatRatio(mode, want)))
+		}
+	}
+	if t.Priority != "" {
+		p := priorities[t.Priority]
+		if p != info.BandwidthPriority {
+			s.BandwidthPriority = &p
+			details = append(details, fmt.Sprintf("priority: %s -> %s", info.BandwidthPriority, p))
+		}
+	}
+	if t.Wanted != nil && len(info.Files) > 0 {
+		var add, remove []int
+		for i, f := range info.Files {
+			w := wanted(t.Wanted, f.Name)
+			cur := i < len(info.Wanted) && info.Wanted[i]
+			switch {
+			case w && !cur:
+				add = append(add, i)
+			case !w && cur:
+				remove = append(remove, i)
+			}
+		}
+		if len(add) > 0 {
+			s.FilesWanted = add
+			details = append(details, fmt.Sprintf("want %d more files", len(add)))
+		}
+		if len(remove) > 0 {
+			s.FilesUnwanted = remove
+			details = append(details, fmt.Sprintf("don't want %d files", len(remove)))
+		}
+	}
+	return s, details
+}
+
+// Seed ratio modes, other than 0 for the global limit.
+const (
+	seedRatioSingle    = 1
+	seedRatioUnlimited = 2
+)
+
+func formatLimit(limited bool, limit int) string {
+	if !limited {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d KB/s", limit)
+}
+
+func formatRatio(mode int, limit float64) string {
+	switch mode {
+	case seedRatioSingle:
+		return fmt.Sprintf("%g", limit)
+	case seedRatioUnlimited:
+		return "unlimited"
+	default:
+		return "global"
+	}
+}
+
+func sameLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply makes the planned changes. It continues after failures and
+// returns an error describing all of them.
+func Apply(cl *transmission.Client, plan []Change) error {
+	var errs []string
+	for _, c := range plan {
+		if err := apply(cl, c); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", c.Kind+" "+c.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("couldn't reconcile: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func apply(cl *transmission.Client, c Change) error {
+	ids := []string{c.Hash}
+	switch c.Kind {
+	case ChangeSession:
+		return cl.SetSession(c.session)
+	case ChangeAdd:
+		if c.settings == nil {
+			_, _, err := cl.AddTorrent(c.add)
+			return err
+		}
+		// Add the torrent paused, so that it doesn't transfer anything
+		// before its limits, labels and so on have been set.
+		add := *c.add
+		add.Paused = true
+		added, duplicate, err := cl.AddTorrent(&add)
+		if err != nil {
+			return err
+		}
+		hashes := []string{added.Hash}
+		if err := cl.SetTorrent(hashes, c.settings); err != nil {
+			return fmt.Errorf("added torrent, but couldn't change its settings, leaving it stopped: %s", err)
+		}
+		if duplicate {
+			// Someone else added it in the meantime; leave it in the
+			// state they chose.
+			return nil
+		}
+		return cl.StartTorrent(hashes)
+	case ChangeSet:
+		return cl.SetTorrent(ids, c.settings)
+	case ChangeLocation:
+		return cl.MoveTorrent(ids, c.location, c.move)
+	case ChangeRemove:
+		return cl.RemoveTorrent(ids, c.deleteData)
+	default:
+		return fmt.Errorf("unknown change %q", c.Kind)
+	}
+}
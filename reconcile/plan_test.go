@@ -0,0 +1,121 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"honnef.co/go/transmission"
+)
+
+func TestDiffTorrent(t *testing.T) {
+	intp := func(v int) *int { return &v }
+	floatp := func(v float64) *float64 { return &v }
+	boolp := func(v bool) *bool { return &v }
+	prio := func(p transmission.Priority) *transmission.Priority { return &p }
+
+	files := []transmission.File{{Name: "t/a.mkv"}, {Name: "t/b.nfo"}, {Name: "t/sub/c.mkv"}}
+	tests := []struct {
+		name    string
+		want    Torrent
+		info    transmission.TorrentInfo
+		out     transmission.TorrentSettings
+		details int
+	}{
+		{
+			name: "nothing set",
+			info: transmission.TorrentInfo{Labels: []string{"a"}, DownloadLimited: true, DownloadLimit: 10},
+		},
+		{
+			name: "same labels in another order",
+			want: Torrent{Labels: []string{"b", "a"}},
+			info: transmission.TorrentInfo{Labels: []string{"a", "b"}},
+		},
+		{
+			name:    "new labels",
+			want:    Torrent{Labels: []string{"linux"}},
+			info:    transmission.TorrentInfo{Labels: []string{"iso"}},
+			out:     transmission.TorrentSettings{Labels: []string{"linux"}},
+			details: 1,
+		},
+		{
+			name:    "remove labels",
+			want:    Torrent{Labels: []string{}},
+			info:    transmission.TorrentInfo{Labels: []string{"iso"}},
+			out:     transmission.TorrentSettings{Labels: []string{}},
+			details: 1,
+		},
+		{
+			name: "limits unchanged",
+			want: Torrent{DownloadLimit: intp(100), UploadLimit: intp(-1)},
+			info: transmission.TorrentInfo{DownloadLimited: true, DownloadLimit: 100, UploadLimit: 50},
+		},
+		{
+			name:    "set limit",
+			want:    Torrent{DownloadLimit: intp(100)},
+			info:    transmission.TorrentInfo{DownloadLimit: 100},
+			out:     transmission.TorrentSettings{DownloadLimited: boolp(true), DownloadLimit: intp(100)},
+			details: 1,
+		},
+		{
+			name:    "change and lift limits",
+			want:    Torrent{DownloadLimit: intp(200), UploadLimit: intp(-1)},
+			info:    transmission.TorrentInfo{DownloadLimited: true, DownloadLimit: 100, UploadLimited: true, UploadLimit: 50},
+			out:     transmission.TorrentSettings{DownloadLimited: boolp(true), DownloadLimit: intp(200), UploadLimited: boolp(false)},
+			details: 2,
+		},
+		{
+			name:    "peer limit",
+			want:    Torrent{PeerLimit: intp(10)},
+			info:    transmission.TorrentInfo{MaxConnectedPeers: 50},
+			out:     transmission.TorrentSettings{PeerLimit: intp(10)},
+			details: 1,
+		},
+		{
+			name: "seed ratio unchanged",
+			want: Torrent{SeedRatioLimit: floatp(2)},
+			info: transmission.TorrentInfo{SeedRatioMode: seedRatioSingle, SeedRatioLimit: 2},
+		},
+		{
+			name:    "seed ratio from global",
+			want:    Torrent{SeedRatioLimit: floatp(2)},
+			info:    transmission.TorrentInfo{SeedRatioLimit: 2},
+			out:     transmission.TorrentSettings{SeedRatioMode: intp(seedRatioSingle), SeedRatioLimit: floatp(2)},
+			details: 1,
+		},
+		{
+			name:    "seed forever",
+			want:    Torrent{SeedRatioLimit: floatp(-1)},
+			info:    transmission.TorrentInfo{SeedRatioMode: seedRatioSingle, SeedRatioLimit: 2},
+			out:     transmission.TorrentSettings{SeedRatioMode: intp(seedRatioUnlimited)},
+			details: 1,
+		},
+		{
+			name:    "priority",
+			want:    Torrent{Priority: "high"},
+			info:    transmission.TorrentInfo{BandwidthPriority: transmission.PriorityNormal},
+			out:     transmission.TorrentSettings{BandwidthPriority: prio(transmission.PriorityHigh)},
+			details: 1,
+		},
+		{
+			name:    "wanted files",
+			want:    Torrent{Wanted: []string{"*.mkv"}},
+			info:    transmission.TorrentInfo{Files: files, Wanted: []bool{false, true, true}},
+			out:     transmission.TorrentSettings{FilesWanted: []int{0}, FilesUnwanted: []int{1}},
+			details: 2,
+		},
+		{
+			name: "wanted files without metadata",
+			want: Torrent{Wanted: []string{"*.mkv"}},
+			info: transmission.TorrentInfo{},
+		},
+	}
+	for _, tt := range tests {
+		s, details := diffTorrent(&tt.want, &tt.info)
+		if !reflect.DeepEqual(*s, tt.out) {
+			t.Errorf("%s: got settings %+v, want %+v", tt.name, *s, tt.out)
+		}
+		if len(details) != tt.details {
+			t.Errorf("%s: got details %q, want %d of them", tt.name, details, tt.details)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Package reconcile brings a daemon into a desired state described by
+// a document, in the manner of declarative configuration management.
+//
+// A document lists session settings and torrents, with their labels,
+// locations, limits and wanted files. Reconciling computes a plan of
+// the calls needed to turn the daemon's current state into the
+// desired one, which can be reviewed before it is applied. Anything
+// the document doesn't mention is left alone, except that torrents
+// missing from the document are removed if Prune is set.
+//
+// Documents are usually written in YAML:
+//
+//	session:
+//	  speed-limit-down: 5000
+//	  speed-limit-down-enabled: true
+//	torrents:
+//	  - source: https://cdimage.debian.org/debian-cd/current/amd64/bt-cd/debian-12.5.0-amd64-netinst.iso.torrent
+//	    location: /srv/iso
+//	    labels: [linux]
+//	    upload_limit: 500
+//	  - hash: 2b66980093bc11806fab50cb3cb41835b95a0362
+//	    location: /srv/media
+//	    wanted: ["*.mkv"]
+package reconcile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"honnef.co/go/transmission"
+	"honnef.co/go/transmission/metainfo"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Fields are the torrent fields that documents are compared against.
+var Fields = []string{
+	"id", "hashString", "name", "labels", "downloadDir", "downloadLimit", "downloadLimited",
+	"uploadLimit", "uploadLimited", "maxConnectedPeers", "seedRatioLimit", "seedRatioMode",
+	"bandwidthPriority", "files", "wanted",
+}
+
+type Document struct {
+	// Session are session settings, in the daemon's naming, as in
+	// "speed-limit-down".
+	Session  map[string]interface{} `yaml:"session"`
+	Torrents []Torrent              `yaml:"torrents"`
+	// Prune removes torrents that aren't listed in the document.
+	// PruneData also deletes their data.
+	Prune     bool `yaml:"prune"`
+	PruneData bool `yaml:"prune_data"`
+	// MoveData moves torrents' data when their locations change.
+	// Otherwise, the data is expected to be at the new location
+	// already.
+	MoveData bool `yaml:"move_data"`
+}
+
+// A Torrent is the desired state of a torrent. Unset fields are left
+// alone.
+type Torrent struct {
+	// Hash is the torrent's info hash. Either Hash or Source must be
+	// set. If Hash isn't set, the torrent is identified by the hash of
+	// its source.
+	Hash string `yaml:"hash"`
+	// Source is where to add the torrent from if it doesn't exist yet:
+	// a magnet link, or the URL or local path of a torrent file.
+	Source string `yaml:"source"`
+	// Labels are the torrent's labels. An empty list removes all
+	// labels.
+	Labels   []string `yaml:"labels"`
+	Location string   `yaml:"location"`
+	// Speed limits in KB/s. -1 means unlimited.
+	DownloadLimit *int `yaml:"download_limit"`
+	UploadLimit   *int `yaml:"upload_limit"`
+	PeerLimit     *int `yaml:"peer_limit"`
+	// SeedRatioLimit is the ratio at which to stop seeding. -1 means
+	// seeding forever.
+	SeedRatioLimit *float64 `yaml:"seed_ratio_limit"`
+	// Priority is the bandwidth priority: low, normal or high.
+	Priority string `yaml:"priority"`
+	// Wanted are patterns, as in path.Match, of the files to download.
+	// They are matched against the files' paths within the torrent and
+	// against their base names. Files matching none of the patterns
+	// aren't downloaded.
+	Wanted []string `yaml:"wanted"`
+}
+
+// Load reads a document from a YAML file.
+func Load(file string) (*Document, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var d Document
+	if err := yaml.UnmarshalStrict(b, &d); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", file, err)
+	}
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return &d, nil
+}
+
+func (d *Document) Validate() error {
+	if _, err := sessionSettings(d.Session); err != nil {
+		return err
+	}
+	hashes := map[string]bool{}
+	for i := range d.Torrents {
+		t := &d.Torrents[i]
+		name := t.Hash
+		if name == "" {
+			name = t.Source
+		}
+		if name == "" {
+			return fmt.Errorf("torrent #%d has neither hash nor source", i+1)
+		}
+		if t.Hash != "" {
+			if !isHash(t.Hash) {
+				return fmt.Errorf("torrent %s: invalid hash", name)
+			}
+			h := strings.ToLower(t.Hash)
+			if hashes[h] {
+				return fmt.Errorf("torrent %s is listed more than once", name)
+			}
+			hashes[h] = true
+		}
+		if _, ok := priorities[t.Priority]; !ok {
+			return fmt.Errorf("torrent %s: invalid priority %q", name, t.Priority)
+		}
+		for _, pat := range t.Wanted {
+			if _, err := path.Match(pat, ""); err != nil {
+				return fmt.Errorf("torrent %s: invalid pattern %q", name, pat)
+			}
+		}
+	}
+	return nil
+}
+
+var priorities = map[string]transmission.Priority{
+	"":       0,
+	"low":    transmission.PriorityLow,
+	"normal": transmission.PriorityNormal,
+	"high":   transmission.PriorityHigh,
+}
+
+func isHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// sessionSettings converts session settings in the daemon's naming to
+// SessionSettings, rejecting unknown and read-only settings.
+func sessionSettings(m map[string]interface{}) (*transmission.SessionSettings, error) {
+	var s transmission.SessionSettings
+	if len(m) == 0 {
+		return &s, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session settings: %s", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid session settings: %s", err)
+	}
+	return &s, nil
+}
+
+// source is a fetched torrent source.
+type source struct {
+	hash string
+	// magnet is the magnet link, for magnet sources.
+	magnet string
+	// data and mi are the torrent file, for other sources.
+	data []byte
+	mi   *metainfo.MetaInfo
+}
+
+// fetchClient fetches torrent files from URLs.
+var fetchClient = &http.Client{Timeout: time.Minute}
+
+// fetch fetches and parses a torrent source.
+func fetch(src string) (*source, error) {
+	if strings.HasPrefix(src, "magnet:") {
+		u, err := url.Parse(src)
+		if err != nil {
+			return nil, err
+		}
+		for _, xt := range u.Query()["xt"] {
+			if h := strings.TrimPrefix(xt, "urn:btih:"); h != xt && isHash(h) {
+				return &source{hash: strings.ToLower(h), magnet: src}, nil
+			}
+		}
+		return nil, fmt.Errorf("magnet link %s has no hex info hash", src)
+	}
+
+	var data []byte
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := fetchClient.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("couldn't fetch %s: %s", src, resp.Status)
+		}
+		data, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		data, err = ioutil.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mi, err := metainfo.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", src, err)
+	}
+	hash, err := mi.HashString()
+	if err != nil {
+		return nil, err
+	}
+	return &source{hash: hash, data: data, mi: mi}, nil
+}
+
+// fileNames returns the names of a torrent's files, as the daemon
+// reports them.
+func fileNames(mi *metainfo.MetaInfo) []string {
+	if mi.Info.Files == nil {
+		return []string{mi.Info.Name}
+	}
+	out := make([]string, len(mi.Info.Files))
+	for i, f := range mi.Info.Files {
+		out[i] = path.Join(append([]string{mi.Info.Name}, f.Path...)...)
+	}
+	return out
+}
+
+// wanted reports whether a file, named as the daemon reports it,
+// matches any of the patterns.
+func wanted(patterns []string, name string) bool {
+	// Multi-file torrents' file names start with the torrent's
+	// directory.
+	rel := name
+	if i := strings.IndexByte(name, '/'); i != -1 {
+		rel = name[i+1:]
+	}
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pat, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package transmission
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// validName checks that name can be used as the new name of a path.
+func validName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return fmt.Errorf("invalid name %q", name)
+	case strings.ContainsAny(name, "/\\"):
+		return fmt.Errorf("name %q must not contain directories", name)
+	}
+	return nil
+}
+
+// hasPath reports whether path is a file or directory of a torrent.
+func hasPath(files []File, path string) bool {
+	for _, f := range files {
+		if f.Name == path || strings.HasPrefix(f.Name, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (cl *Client) torrentFiles(id string) (*TorrentInfo, error) {
+	infos, err := cl.TorrentInfo([]string{id}, []string{"id", "hashString", "name", "files"})
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		if strings.EqualFold(infos[i].Hash, id) || strconv.Itoa(infos[i].ID) == id {
+			return &infos[i], nil
+		}
+	}
+	return nil, ErrTorrentNotFound
+}
+
+// RenamePath renames a file or directory of a single torrent, like
+// RenameTorrentPath, but first checks that the path exists in the
+// torrent's files.
+func (cl *Client) RenamePath(id string, path string, name string) (RenamedPath, error) {
+	if err := validName(name); err != nil {
+		return RenamedPath{}, err
+	}
+	info, err := cl.torrentFiles(id)
+	if err != nil {
+		return RenamedPath{}, err
+	}
+	if !hasPath(info.Files, path) {
+		return RenamedPath{}, fmt.Errorf("torrent %s has no file or directory %q", info.Name, path)
+	}
+	return cl.renameTorrentPath([]string{id}, path, name)
+}
+
+// RenameTorrent renames a torrent's top-level directory, or its file
+// for single-file torrents, which also changes the torrent's name.
+func (cl *Client) RenameTorrent(id string, name string) (RenamedPath, error) {
+	if err := validName(name); err != nil {
+		return RenamedPath{}, err
+	}
+	info, err := cl.torrentFiles(id)
+	if err != nil {
+		return RenamedPath{}, err
+	}
+	if len(info.Files) == 0 {
+		return RenamedPath{}, fmt.Errorf("torrent %s has no metadata yet", info.Name)
+	}
+	return cl.renameTorrentPath([]string{id}, topLevel(info), name)
+}
+
+// RenameFile renames the file with the given index in
+// TorrentInfo.Files. name is the file's new base name.
+func (cl *Client) RenameFile(id string, index int, name string) (RenamedPath, error) {
+	if err := validName(name); err != nil {
+		return RenamedPath{}, err
+	}
+	info, err := cl.torrentFiles(id)
+	if err != nil {
+		return RenamedPath{}, err
+	}
+	if index < 0 || index >= len(info.Files) {
+		return RenamedPath{}, fmt.Errorf("torrent %s has no file %d", info.Name, index)
+	}
+	return cl.renameTorrentPath([]string{id}, info.Files[index].Name, name)
+}
+
+// topLevel returns the path of a torrent's top-level directory or
+// file.
+func topLevel(info *TorrentInfo) string {
+	name := info.Files[0].Name
+	if i := strings.IndexByte(name, '/'); i != -1 {
+		return name[:i]
+	}
+	return name
+}
+
+// A Rename is a planned rename of a torrent's top-level directory or
+// file, as returned by RenameTemplate.
+type Rename struct {
+	ID   int
+	Hash string
+	// Path is the current name of the top-level directory or file.
+	Path string
+	// Name is the new name.
+	Name string
+	// Err is set if the template couldn't be expanded for the torrent.
+	// Such renames are skipped by ApplyRenames.
+	Err error
+}
+
+var (
+	yearRe     = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)[0-9]{2})(?:[^0-9]|$)`)
+	templateRe = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+// templateVars returns the variables available to rename templates.
+func templateVars(info *TorrentInfo, path string) map[string]string {
+	base := path
+	ext := ""
+	// Single-file torrents keep their extension.
+	if !strings.Contains(info.Files[0].Name, "/") {
+		if i := strings.LastIndexByte(path, '.'); i > 0 {
+			base, ext = path[:i], path[i:]
+		}
+	}
+	vars := map[string]string{
+		"name": base,
+		"ext":  ext,
+		"id":   strconv.Itoa(info.ID),
+		"hash": info.Hash,
+	}
+	title := base
+	if m := yearRe.FindStringSubmatchIndex(base); m != nil {
+		vars["year"] = base[m[2]:m[3]]
+		title = base[:m[2]]
+	}
+	title = strings.NewReplacer(".", " ", "_", " ").Replace(title)
+	title = strings.Trim(title, " -([")
+	if title != "" {
+		vars["title"] = title
+	}
+	if len(info.Labels) > 0 {
+		vars["label"] = info.Labels[0]
+	}
+	return vars
+}
+
+// RenameTemplate computes new names for the top-level directories or
+// files of torrents, which must have been requested with the fields
+// id, hashString, files and labels. It doesn't rename anything; pass
+// the result to ApplyRenames to do so.
+//
+// The template refers to variables in braces:
+//
+//	{name}   the current name, without the file extension of single-file torrents
+//	{ext}    the file extension of single-file torrents, including the dot
+//	{title}  the name up to the year, with dots and underscores replaced by spaces
+//	{year}   the first year between 1900 and 2099 found in the name
+//	{label}  the torrent's first label
+//	{id}     the torrent's ID
+//	{hash}   the torrent's info hash
+//
+// For example, "{title} ({year})" renames "Some.Movie.2020.1080p" to
+// "Some Movie (2020)". Torrents that already have the new name, or
+// have no metadata yet, are omitted. Torrents that lack variables used
+// by the template are returned with Err set.
+func RenameTemplate(infos []TorrentInfo, template string) ([]Rename, error) {
+	for _, v := range templateRe.FindAllString(template, -1) {
+		switch v[1 : len(v)-1] {
+		case "name", "ext", "title", "year", "label", "id", "hash":
+		default:
+			return nil, fmt.Errorf("unknown template variable %s", v)
+		}
+	}
+
+	var out []Rename
+	for i := range infos {
+		info := &infos[i]
+		if len(info.Files) == 0 {
+			continue
+		}
+		path := topLevel(info)
+		vars := templateVars(info, path)
+		r := Rename{ID: info.ID, Hash: info.Hash, Path: path}
+		r.Name = templateRe.ReplaceAllStringFunc(template, func(v string) string {
+			val, ok := vars[v[1:len(v)-1]]
+			if !ok && r.Err == nil {
+				r.Err = fmt.Errorf("%s has no %s", path, v)
+			}
+			return val
+		})
+		if r.Err == nil {
+			r.Err = validName(r.Name)
+		}
+		if r.Err == nil && r.Name == path {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// ApplyRenames performs renames returned by RenameTemplate, skipping
+// those with errors. The result is keyed by info hash.
+func (cl *Client) ApplyRenames(renames []Rename) BulkResult {
+	res := BulkResult{}
+	for _, r := range renames {
+		if r.Err != nil {
+			res[r.Hash] = r.Err
+			continue
+		}
+		res[r.Hash] = cl.RenameTorrentPath([]string{r.Hash}, r.Path, r.Name)
+	}
+	return res
+}
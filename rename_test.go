@@ -0,0 +1,69 @@
+package transmission
+
+import (
+	"testing"
+)
+
+func TestRenameTemplate(t *testing.T) {
+	dir := func(id int, name string, labels ...string) TorrentInfo {
+		return TorrentInfo{ID: id, Hash: "h" + name, Labels: labels, Files: []File{{Name: name + "/a.mkv"}, {Name: name + "/b.nfo"}}}
+	}
+	file := func(id int, name string) TorrentInfo {
+		return TorrentInfo{ID: id, Hash: "h" + name, Files: []File{{Name: name}}}
+	}
+
+	tests := []struct {
+		template string
+		info     TorrentInfo
+		// want is the new name, or "" if the torrent is omitted.
+		want string
+		err  bool
+	}{
+		{"{title} ({year})", dir(1, "Some.Movie.2020.1080p"), "Some Movie (2020)", false},
+		{"{title} ({year})", dir(1, "Some_Movie_(2020)"), "Some Movie (2020)", false},
+		{"{title} ({year})", dir(1, "Some Movie (2020)"), "", false},
+		{"{title} ({year})", dir(1, "No Year"), "", true},
+		{"{title} ({year})", dir(1, "2020"), "", true},
+		{"{title} ({year}){ext}", file(1, "Some.Movie.1999.mkv"), "Some Movie (1999).mkv", false},
+		{"{name}{ext}", file(1, "movie.mkv"), "", false},
+		{"{name}-{id}", file(7, "movie.mkv"), "movie-7", false},
+		{"{name}{ext}", dir(1, "dir.with.dots"), "", false},
+		{"[{label}] {name}", dir(1, "x", "movies", "hd"), "[movies] x", false},
+		{"[{label}] {name}", dir(1, "x"), "", true},
+		{"{hash}", dir(1, "x"), "hx", false},
+		{"{name}/sub", dir(1, "x"), "", true},
+		{"{ext}", dir(1, "x"), "", true},
+		{"{name}", TorrentInfo{ID: 1, Hash: "magnet"}, "", false},
+	}
+	for _, tt := range tests {
+		renames, err := RenameTemplate([]TorrentInfo{tt.info}, tt.template)
+		if err != nil {
+			t.Errorf("%q: %s", tt.template, err)
+			continue
+		}
+		if tt.want == "" && !tt.err {
+			if len(renames) != 0 {
+				t.Errorf("%q on %+v: got %+v, want nothing", tt.template, tt.info.Files, renames)
+			}
+			continue
+		}
+		if len(renames) != 1 {
+			t.Errorf("%q on %+v: got %d renames, want 1", tt.template, tt.info.Files, len(renames))
+			continue
+		}
+		r := renames[0]
+		if (r.Err != nil) != tt.err {
+			t.Errorf("%q on %+v: got error %v, want error = %t", tt.template, tt.info.Files, r.Err, tt.err)
+		}
+		if !tt.err && r.Name != tt.want {
+			t.Errorf("%q on %+v: got %q, want %q", tt.template, tt.info.Files, r.Name, tt.want)
+		}
+		if r.ID != tt.info.ID || r.Hash != tt.info.Hash || r.Path != topLevel(&tt.info) {
+			t.Errorf("%q on %+v: got %+v", tt.template, tt.info.Files, r)
+		}
+	}
+
+	if _, err := RenameTemplate(nil, "{name} {bogus}"); err == nil {
+		t.Error("RenameTemplate accepted an unknown variable")
+	}
+}
@@ -2,9 +2,12 @@ package transmission
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"sync"
 )
 
 type TrackerState int
@@ -120,7 +123,7 @@ var AllTorrentFields = []string{
 	"isStalled", "labels", "leftUntilDone", "magnetLink", "manualAnnounceTime", "maxConnectedPeers",
 	"metadataPercentComplete", "name", "peer-limit", "peers", "peersConnected", "peersFrom",
 	"peersGettingFromUs", "peersSendingToUs", "percentDone", "pieceCount", "pieceSize", "pieces",
-	"priorities", "queuePosition", "rateDownload (B/s)", "rateUpload (B/s)", "recheckProgress",
+	"priorities", "queuePosition", "rateDownload", "rateUpload", "recheckProgress",
 	"secondsDownloading", "secondsSeeding", "seedIdleLimit", "seedIdleMode", "seedRatioLimit", "seedRatioMode",
 	"sizeWhenDone", "startDate", "status", "torrentFile", "totalSize", "trackerStats",
 	"trackers", "uploadLimit", "uploadLimited", "uploadRatio", "uploadedEver", "wanted",
@@ -140,12 +143,41 @@ var AllSessionFields = []string{
 	"utp-enabled", "version",
 }
 
+// A Client is safe for concurrent use by multiple goroutines, as long
+// as its fields aren't modified after its first use.
 type Client struct {
 	Client   *http.Client
 	Endpoint string
 	Username string
 	Password string
-	csrf     string
+	// Observer, if not nil, is notified of every request, for example
+	// to record traces or metrics.
+	Observer RequestObserver
+
+	mu   sync.Mutex
+	csrf string
+}
+
+// A RequestObserver observes requests made by a Client.
+type RequestObserver interface {
+	// StartRequest is called before a request is sent. The returned
+	// context is used for the HTTP request. The returned function is
+	// called once the request has completed, with err set if it
+	// failed.
+	StartRequest(ctx context.Context, method string, args interface{}) (context.Context, func(stats RequestStats, err error))
+}
+
+// RequestStats describes a completed request.
+type RequestStats struct {
+	// Result is the result reported by the daemon, "success" for
+	// successful requests. It is empty if no response was received.
+	Result string
+	// RequestBytes and ResponseBytes are the sizes of the request and
+	// response bodies.
+	RequestBytes  int
+	ResponseBytes int
+	// Arguments are the arguments of the response, if any.
+	Arguments json.RawMessage
 }
 
 func NewClient(endpoint string, client *http.Client) *Client {
@@ -160,6 +192,23 @@ func NewClient(endpoint string, client *http.Client) *Client {
 }
 
 func (cl *Client) Request(method string, args interface{}) (Response, error) {
+	return cl.RequestContext(context.Background(), method, args)
+}
+
+// RequestContext is like Request, but uses ctx for the HTTP request.
+func (cl *Client) RequestContext(ctx context.Context, method string, args interface{}) (Response, error) {
+	if cl.Observer == nil {
+		var stats RequestStats
+		return cl.request(ctx, method, args, &stats)
+	}
+	ctx, done := cl.Observer.StartRequest(ctx, method, args)
+	var stats RequestStats
+	resp, err := cl.request(ctx, method, args, &stats)
+	done(stats, err)
+	return resp, err
+}
+
+func (cl *Client) request(ctx context.Context, method string, args interface{}, stats *RequestStats) (Response, error) {
 	type request struct {
 		Method    string      `json:"method"`
 		Arguments interface{} `json:"arguments"`
@@ -169,11 +218,15 @@ func (cl *Client) Request(method string, args interface{}) (Response, error) {
 	if err != nil {
 		return Response{}, err
 	}
-	hreq, err := http.NewRequest(http.MethodPost, cl.Endpoint, bytes.NewReader(b))
+	stats.RequestBytes = len(b)
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, cl.Endpoint, bytes.NewReader(b))
 	if err != nil {
 		return Response{}, err
 	}
-	hreq.Header.Set(csrfHeader, cl.csrf)
+	cl.mu.Lock()
+	csrf := cl.csrf
+	cl.mu.Unlock()
+	hreq.Header.Set(csrfHeader, csrf)
 	if cl.Username != "" {
 		hreq.SetBasicAuth(cl.Username, cl.Password)
 	}
@@ -185,18 +238,29 @@ func (cl *Client) Request(method string, args interface{}) (Response, error) {
 	defer resp.Body.Close()
 	if resp.StatusCode == http.StatusConflict {
 		// XXX don't get stuck in a loop
+		cl.mu.Lock()
 		cl.csrf = resp.Header.Get(csrfHeader)
-		return cl.Request(method, args)
+		cl.mu.Unlock()
+		return cl.request(ctx, method, args, stats)
 	}
 	if resp.StatusCode/100 != 2 {
 		return Response{},
 			fmt.Errorf("request %s to %s failed: %s", method, cl.Endpoint, http.StatusText(resp.StatusCode))
 	}
 
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Response{}, err
+	}
+	stats.ResponseBytes = len(body)
 	var out Response
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+	if err := json.Unmarshal(body, &out); err != nil {
 		return Response{}, err
 	}
+	stats.Result = out.Result
+	if out.Arguments != nil {
+		stats.Arguments = *out.Arguments
+	}
 	if out.Result != "success" {
 		return out, fmt.Errorf("request %s to %s failed: %s", method, cl.Endpoint, out.Result)
 	}
@@ -204,7 +268,13 @@ func (cl *Client) Request(method string, args interface{}) (Response, error) {
 }
 
 func (cl *Client) SessionStats() (*SessionStats, error) {
-	resp, err := cl.Request(MethodSessionStats, nil)
+	return cl.SessionStatsContext(context.Background())
+}
+
+// SessionStatsContext is like SessionStats, but uses ctx for the HTTP
+// request.
+func (cl *Client) SessionStatsContext(ctx context.Context) (*SessionStats, error) {
+	resp, err := cl.RequestContext(ctx, MethodSessionStats, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -277,32 +347,56 @@ func (cl *Client) AddTorrent(torrent *NewTorrent) (info AddedTorrent, duplicate
 }
 
 func (cl *Client) TorrentInfo(ids []string, fields []string) ([]TorrentInfo, error) {
+	return cl.TorrentInfoContext(context.Background(), ids, fields)
+}
+
+// TorrentInfoContext is like TorrentInfo, but uses ctx for the HTTP
+// request.
+func (cl *Client) TorrentInfoContext(ctx context.Context, ids []string, fields []string) ([]TorrentInfo, error) {
+	var sel interface{}
+	if len(ids) > 0 {
+		sel = ids
+	}
+	out, _, err := cl.torrentGet(ctx, sel, fields)
+	return out, err
+}
+
+// RecentlyActiveTorrents returns the torrents that have been active
+// recently, as well as the IDs of torrents that have been removed
+// recently. It allows keeping a list of torrents up to date without
+// fetching all of them, see Poller.
+func (cl *Client) RecentlyActiveTorrents(fields []string) (active []TorrentInfo, removed []int, err error) {
+	return cl.torrentGet(context.Background(), "recently-active", fields)
+}
+
+func (cl *Client) torrentGet(ctx context.Context, ids interface{}, fields []string) ([]TorrentInfo, []int, error) {
 	req := struct {
-		IDs    []string `json:"ids,omitempty"`
-		Fields []string `json:"fields"`
-		Format string   `json:"format"`
+		IDs    interface{} `json:"ids,omitempty"`
+		Fields []string    `json:"fields"`
+		Format string      `json:"format"`
 	}{
 		ids,
 		fields,
 		"objects",
 	}
-	resp, err := cl.Request(MethodTorrentGet, req)
+	resp, err := cl.RequestContext(ctx, MethodTorrentGet, req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var infos struct {
 		Torrents []torrentInfo `json:"torrents"`
+		Removed  []int         `json:"removed"`
 	}
 	if err := json.Unmarshal([]byte(*resp.Arguments), &infos); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	out := make([]TorrentInfo, len(infos.Torrents))
 	for i := range out {
 		convertTorrentInfo(&infos.Torrents[i], &out[i])
 	}
-	return out, nil
+	return out, infos.Removed, nil
 }
 
 func (cl *Client) RemoveTorrent(ids []string, deleteLocalData bool) error {
@@ -322,20 +416,44 @@ func (cl *Client) MoveTorrent(ids []string, location string, move bool) error {
 	return err
 }
 
+// RenameTorrentPath renames a file or directory of a torrent. path is
+// the file's or directory's current path within the torrent, as in
+// File.Name, and name is its new name, without any directories. The
+// daemon only accepts a single torrent.
 func (cl *Client) RenameTorrentPath(ids []string, path string, name string) error {
-	_, err := cl.Request(MethodTorrentSetLocation, struct {
+	_, err := cl.renameTorrentPath(ids, path, name)
+	return err
+}
+
+// renameTorrentPath is like RenameTorrentPath but returns the
+// daemon's response.
+func (cl *Client) renameTorrentPath(ids []string, path string, name string) (RenamedPath, error) {
+	resp, err := cl.Request(MethodTorrentRenamePath, struct {
 		IDs  []string `json:"ids"`
 		Path string   `json:"path"`
 		Name string   `json:"name"`
 	}{ids, path, name})
-	return err
+	if err != nil {
+		return RenamedPath{}, err
+	}
+	var out RenamedPath
+	if err := json.Unmarshal([]byte(*resp.Arguments), &out); err != nil {
+		return RenamedPath{}, err
+	}
+	return out, nil
 }
 
 func (cl *Client) SessionInfo(fields []string) (*SessionInfo, error) {
+	return cl.SessionInfoContext(context.Background(), fields)
+}
+
+// SessionInfoContext is like SessionInfo, but uses ctx for the HTTP
+// request.
+func (cl *Client) SessionInfoContext(ctx context.Context, fields []string) (*SessionInfo, error) {
 	req := struct {
 		Fields []string `json:"fields,omitempty"`
 	}{fields}
-	resp, err := cl.Request(MethodSessionGet, req)
+	resp, err := cl.RequestContext(ctx, MethodSessionGet, req)
 	if err != nil {
 		return nil, err
 	}
@@ -346,3 +464,67 @@ func (cl *Client) SessionInfo(fields []string) (*SessionInfo, error) {
 	}
 	return &out, nil
 }
+
+func (cl *Client) SetTorrent(ids []string, settings *TorrentSettings) error {
+	// TorrentSettings has a custom MarshalJSON, which rules out
+	// embedding it in a struct alongside the IDs.
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	var args map[string]json.RawMessage
+	if err := json.Unmarshal(b, &args); err != nil {
+		return err
+	}
+	if args["ids"], err = json.Marshal(ids); err != nil {
+		return err
+	}
+	_, err = cl.Request(MethodTorrentSet, args)
+	return err
+}
+
+func (cl *Client) SetSession(settings *SessionSettings) error {
+	_, err := cl.Request(MethodSessionSet, settings)
+	return err
+}
+
+func (cl *Client) QueueMoveTop(ids []string) error {
+	return cl.torrentAction(MethodQueueMoveTop, ids)
+}
+
+func (cl *Client) QueueMoveUp(ids []string) error {
+	return cl.torrentAction(MethodQueueMoveUp, ids)
+}
+
+func (cl *Client) QueueMoveDown(ids []string) error {
+	return cl.torrentAction(MethodQueueMoveDown, ids)
+}
+
+func (cl *Client) QueueMoveBottom(ids []string) error {
+	return cl.torrentAction(MethodQueueMoveBottom, ids)
+}
+
+// FreeSpace returns the number of bytes available in the directory at
+// path on the daemon's host.
+func (cl *Client) FreeSpace(path string) (int64, error) {
+	return cl.FreeSpaceContext(context.Background(), path)
+}
+
+// FreeSpaceContext is like FreeSpace, but uses ctx for the HTTP
+// request.
+func (cl *Client) FreeSpaceContext(ctx context.Context, path string) (int64, error) {
+	resp, err := cl.RequestContext(ctx, MethodFreeSpace, struct {
+		Path string `json:"path"`
+	}{path})
+	if err != nil {
+		return 0, err
+	}
+
+	var out struct {
+		SizeBytes int64 `json:"size-bytes"`
+	}
+	if err := json.Unmarshal([]byte(*resp.Arguments), &out); err != nil {
+		return 0, err
+	}
+	return out.SizeBytes, nil
+}
@@ -0,0 +1,525 @@
+package transmission
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultPollInterval = 2 * time.Second
+
+// errorLocal is the value of TorrentInfo.Error for local errors, such
+// as failing to move data.
+const errorLocal = 3
+
+// lookup maps the IDs passed to TorrentInfo, which may be numeric IDs
+// or info hashes, to the torrents they refer to.
+func lookup(ids []string, infos []TorrentInfo) map[string]*TorrentInfo {
+	byKey := map[string]*TorrentInfo{}
+	for i := range infos {
+		byKey[strconv.Itoa(infos[i].ID)] = &infos[i]
+		byKey[strings.ToLower(infos[i].Hash)] = &infos[i]
+	}
+	out := make(map[string]*TorrentInfo, len(ids))
+	for _, id := range ids {
+		out[id] = byKey[strings.ToLower(id)]
+	}
+	return out
+}
+
+// poll repeatedly fetches the torrents with the given IDs until fn
+// returns true or ctx is done. Torrents that don't exist map to nil.
+func (cl *Client) poll(ctx context.Context, ids []string, fields []string, interval time.Duration, fn func(infos map[string]*TorrentInfo) bool) error {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	fields = append([]string{"id", "hashString"}, fields...)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		infos, _, err := cl.torrentGet(ctx, ids, fields)
+		if err != nil {
+			return err
+		}
+		if fn(lookup(ids, infos)) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// MoveStatus is the state of a torrent that is being moved.
+type MoveStatus struct {
+	// ID is the ID passed to MoveAndWait.
+	ID          string
+	Name        string
+	DownloadDir string
+	Status      TorrentStatus
+	// Done is true once the torrent has been moved or the move failed.
+	Done bool
+	Err  error
+}
+
+type MoveOptions struct {
+	// PollInterval is how often to check on the move. It defaults to
+	// two seconds.
+	PollInterval time.Duration
+	// Progress, if not nil, is called after every check with the state
+	// of all torrents.
+	Progress func([]MoveStatus)
+	// VerifySizes checks, once a torrent has moved, that its complete
+	// files exist at the new location with the right sizes. This
+	// requires the location to be accessible locally.
+	VerifySizes bool
+	// LocalPath maps paths on the daemon's host to local paths for
+	// VerifySizes. By default, paths are used as they are.
+	LocalPath func(path string) string
+}
+
+// MoveAndWait moves torrents and their data to a new location and
+// waits for the daemon to finish moving the data, which happens in the
+// background and can take a long time. It returns the outcome for every
+// torrent. A torrent that has a local error and isn't at the new
+// location yet counts as failed, even if it had the error before the
+// move.
+//
+// Only ctx bounds the wait; without a deadline, MoveAndWait waits as
+// long as the daemon takes. If ctx is done first, torrents that haven't
+// finished moving fail with ctx's error, although the daemon continues
+// to move them. The returned error is reserved for failing to start the
+// move.
+func (cl *Client) MoveAndWait(ctx context.Context, ids []string, location string, opts *MoveOptions) (BulkResult, error) {
+	if opts == nil {
+		opts = &MoveOptions{}
+	}
+	fields := []string{"name", "downloadDir", "status", "error", "errorString"}
+	infos, _, err := cl.torrentGet(ctx, ids, append([]string{"id", "hashString"}, fields...))
+	if err != nil {
+		return nil, err
+	}
+	before := lookup(ids, infos)
+
+	res := BulkResult{}
+	states := make([]MoveStatus, len(ids))
+	var moving []string
+	for i, id := range ids {
+		states[i].ID = id
+		info := before[id]
+		if info == nil {
+			states[i].Done, states[i].Err = true, ErrTorrentNotFound
+			res[id] = ErrTorrentNotFound
+			continue
+		}
+		states[i].Name = info.Name
+		moving = append(moving, id)
+	}
+	if len(moving) == 0 {
+		return res, nil
+	}
+	if err := cl.MoveTorrent(moving, location, true); err != nil {
+		return nil, err
+	}
+
+	err = cl.poll(ctx, moving, fields, opts.PollInterval, func(infos map[string]*TorrentInfo) bool {
+		done := true
+		for i := range states {
+			st := &states[i]
+			if st.Done {
+				continue
+			}
+			info := infos[st.ID]
+			switch {
+			case info == nil:
+				st.Done, st.Err = true, ErrTorrentNotFound
+			case path.Clean(info.DownloadDir) == path.Clean(location):
+				st.Done = true
+			case info.Error == errorLocal:
+				// The daemon may fail with the same error as before
+				// the move, such as a missing directory, so any local
+				// error counts as a failure.
+				st.Done, st.Err = true, fmt.Errorf("couldn't move: %s", info.ErrorString)
+			default:
+				done = false
+			}
+			if info != nil {
+				st.DownloadDir, st.Status = info.DownloadDir, info.Status
+			}
+		}
+		if opts.Progress != nil {
+			opts.Progress(append([]MoveStatus(nil), states...))
+		}
+		return done
+	})
+	for _, st := range states {
+		switch {
+		case st.Done:
+			res[st.ID] = st.Err
+		case err != nil:
+			res[st.ID] = err
+		default:
+			res[st.ID] = ctx.Err()
+		}
+	}
+
+	if opts.VerifySizes {
+		var moved []string
+		for _, id := range moving {
+			if res[id] == nil {
+				moved = append(moved, id)
+			}
+		}
+		if len(moved) > 0 {
+			cl.verifySizes(ctx, moved, location, opts.LocalPath, res)
+		}
+	}
+	return res, nil
+}
+
+// verifySizes checks the sizes of the complete files of moved
+// torrents, recording failures in res.
+func (cl *Client) verifySizes(ctx context.Context, ids []string, location string, localPath func(string) string, res BulkResult) {
+	infos, _, err := cl.torrentGet(ctx, ids, []string{"id", "hashString", "files"})
+	if err != nil {
+		for _, id := range ids {
+			res[id] = fmt.Errorf("couldn't verify sizes: %s", err)
+		}
+		return
+	}
+	dir := location
+	if localPath != nil {
+		dir = localPath(location)
+	}
+	for id, info := range lookup(ids, infos) {
+		if info == nil {
+			res[id] = ErrTorrentNotFound
+			continue
+		}
+		for _, f := range info.Files {
+			if f.BytesCompleted != f.Length {
+				continue
+			}
+			fi, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.Name)))
+			if err != nil {
+				res[id] = err
+				break
+			}
+			if fi.Size() != int64(f.Length) {
+				res[id] = fmt.Errorf("%s has %d bytes, expected %d", f.Name, fi.Size(), f.Length)
+				break
+			}
+		}
+	}
+}
+
+// VerifyProgress is the state of a torrent that is being verified.
+type VerifyProgress struct {
+	// ID is the ID passed to VerifyAndWait.
+	ID     string
+	Name   string
+	Status TorrentStatus
+	// RecheckProgress is the fraction of the torrent that has been
+	// verified, while Status is TorrentStatusCheck.
+	RecheckProgress float64
+}
+
+// VerifyResult is the outcome of verifying a torrent.
+type VerifyResult struct {
+	Name string
+	// HaveValid is the number of bytes that passed verification.
+	HaveValid int
+	// CorruptDelta is the number of corrupt bytes found by the
+	// verification.
+	CorruptDelta int
+	PercentDone  float64
+	// ErrorString is the torrent's error after the verification, such
+	// as missing data.
+	ErrorString string
+	Err         error
+}
+
+type VerifyOptions struct {
+	// PollInterval is how often to check on the verification. It
+	// defaults to two seconds.
+	PollInterval time.Duration
+	// Progress, if not nil, is called after every check for every
+	// torrent that is still being verified.
+	Progress func(VerifyProgress)
+	// RestoreState restores each torrent's previous state after
+	// verification: stopped torrents are stopped again, and running
+	// ones are started again.
+	RestoreState bool
+}
+
+func checking(s TorrentStatus) bool {
+	return s == TorrentStatusCheckWait || s == TorrentStatusCheck
+}
+
+// VerifyAndWait verifies torrents' local data and waits for the
+// verification to complete. It returns the outcome for every torrent,
+// keyed by the IDs passed to it. If ctx is done first, torrents that
+// are still being verified fail with ctx's error. The returned error
+// is reserved for failing to start the verification.
+func (cl *Client) VerifyAndWait(ctx context.Context, ids []string, opts *VerifyOptions) (map[string]VerifyResult, error) {
+	if opts == nil {
+		opts = &VerifyOptions{}
+	}
+	fields := []string{"name", "status", "recheckProgress", "haveValid", "corruptEver", "percentDone", "error", "errorString"}
+	infos, _, err := cl.torrentGet(ctx, ids, append([]string{"id", "hashString"}, fields...))
+	if err != nil {
+		return nil, err
+	}
+	before := lookup(ids, infos)
+
+	res := map[string]VerifyResult{}
+	var pending []string
+	for _, id := range ids {
+		if before[id] == nil {
+			res[id] = VerifyResult{Err: ErrTorrentNotFound}
+			continue
+		}
+		pending = append(pending, id)
+	}
+	if len(pending) == 0 {
+		return res, nil
+	}
+	if err := cl.VerifyTorrent(pending); err != nil {
+		return nil, err
+	}
+
+	// The daemon queues torrents for verification before responding,
+	// so torrents that aren't checking anymore are done.
+	err = cl.poll(ctx, pending, fields, opts.PollInterval, func(infos map[string]*TorrentInfo) bool {
+		var next []string
+		for _, id := range pending {
+			info := infos[id]
+			switch {
+			case info == nil:
+				res[id] = VerifyResult{Name: before[id].Name, Err: ErrTorrentNotFound}
+			case checking(info.Status):
+				next = append(next, id)
+				if opts.Progress != nil {
+					opts.Progress(VerifyProgress{id, info.Name, info.Status, info.RecheckProgress})
+				}
+			default:
+				res[id] = VerifyResult{
+					Name:         info.Name,
+					HaveValid:    info.HaveValid,
+					CorruptDelta: info.CorruptEver - before[id].CorruptEver,
+					PercentDone:  info.PercentDone,
+					ErrorString:  info.ErrorString,
+				}
+			}
+		}
+		pending = next
+		return len(pending) == 0
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	for _, id := range pending {
+		res[id] = VerifyResult{Name: before[id].Name, Err: err}
+	}
+
+	if opts.RestoreState {
+		cl.restoreState(ctx, before, res)
+	}
+	return res, nil
+}
+
+// restoreState stops or starts verified torrents so that they are in
+// the state they were in before.
+func (cl *Client) restoreState(ctx context.Context, before map[string]*TorrentInfo, res map[string]VerifyResult) {
+	var ids []string
+	for id, r := range res {
+		if r.Err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	infos, _, err := cl.torrentGet(ctx, ids, []string{"id", "hashString", "status"})
+	if err != nil {
+		for _, id := range ids {
+			r := res[id]
+			r.Err = fmt.Errorf("couldn't restore state: %s", err)
+			res[id] = r
+		}
+		return
+	}
+	var start, stop []string
+	for id, info := range lookup(ids, infos) {
+		if info == nil {
+			continue
+		}
+		wasStopped := before[id].Status == TorrentStatusStopped
+		isStopped := info.Status == TorrentStatusStopped
+		switch {
+		case wasStopped && !isStopped:
+			stop = append(stop, id)
+		case !wasStopped && isStopped:
+			start = append(start, id)
+		}
+	}
+	for _, op := range []struct {
+		ids []string
+		fn  func([]string) error
+	}{{start, cl.StartTorrent}, {stop, cl.StopTorrent}} {
+		if len(op.ids) == 0 {
+			continue
+		}
+		if err := op.fn(op.ids); err != nil {
+			for _, id := range op.ids {
+				r := res[id]
+				r.Err = fmt.Errorf("couldn't restore state: %s", err)
+				res[id] = r
+			}
+		}
+	}
+}
+
+// MetadataAction is applied to a torrent once its metadata has arrived.
+type MetadataAction struct {
+	// Settings, if not nil, are applied to the torrent, for example to
+	// select files or set labels.
+	Settings *TorrentSettings
+	// Location, if not empty, moves the torrent to a new location.
+	Location string
+	// Start starts the torrent.
+	Start bool
+}
+
+type MetadataOptions struct {
+	// PollInterval is how often to check for metadata. It defaults to
+	// two seconds.
+	PollInterval time.Duration
+	// Fields are the fields of the returned TorrentInfo. They default
+	// to AllTorrentFields.
+	Fields []string
+	// Fetch starts the torrent if it is stopped, because stopped
+	// torrents don't fetch metadata. It is stopped again once the
+	// metadata has arrived, unless the action starts it, and when
+	// waiting or applying the action fails.
+	Fetch bool
+	// Action, if not nil, is called with the torrent once its metadata
+	// has arrived, and the action it returns is applied. Use it to make
+	// decisions that depend on the torrent's files.
+	Action func(info *TorrentInfo) (*MetadataAction, error)
+}
+
+// WaitForMetadata waits for a torrent, usually added with a magnet
+// link, to have its metadata, and returns it with the files, size and
+// pieces known. Only the metadata's progress is polled, and the full
+// torrent is fetched once. If an action is applied, the returned
+// torrent reflects it.
+func (cl *Client) WaitForMetadata(ctx context.Context, id string, opts *MetadataOptions) (*TorrentInfo, error) {
+	if opts == nil {
+		opts = &MetadataOptions{}
+	}
+	ids := []string{id}
+	fields := []string{"status", "metadataPercentComplete"}
+	infos, _, err := cl.torrentGet(ctx, ids, append([]string{"id", "hashString"}, fields...))
+	if err != nil {
+		return nil, err
+	}
+	info := lookup(ids, infos)[id]
+	if info == nil {
+		return nil, ErrTorrentNotFound
+	}
+
+	// running is true while the torrent is running only because we
+	// started it. fail stops it again, so that errors don't leave it
+	// downloading.
+	var started, running bool
+	fail := func(err error) (*TorrentInfo, error) {
+		if running {
+			cl.StopTorrent(ids)
+		}
+		return nil, err
+	}
+	if info.MetadataPercentComplete < 1 {
+		if opts.Fetch && info.Status == TorrentStatusStopped {
+			if err := cl.StartTorrent(ids); err != nil {
+				return nil, err
+			}
+			started, running = true, true
+		}
+		err := cl.poll(ctx, ids, fields[1:], opts.PollInterval, func(infos map[string]*TorrentInfo) bool {
+			info = infos[id]
+			return info == nil || info.MetadataPercentComplete == 1
+		})
+		if err == nil && info == nil {
+			err = ErrTorrentNotFound
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	info, err = cl.metadataInfo(ctx, id, opts.Fields)
+	if err != nil {
+		return fail(err)
+	}
+	var action *MetadataAction
+	if opts.Action != nil {
+		if action, err = opts.Action(info); err != nil {
+			return fail(err)
+		}
+	}
+	if action == nil {
+		action = &MetadataAction{}
+	}
+	if running && !action.Start {
+		if err := cl.StopTorrent(ids); err != nil {
+			return nil, err
+		}
+		running = false
+	}
+	if action.Settings != nil {
+		if err := cl.SetTorrent(ids, action.Settings); err != nil {
+			return fail(fmt.Errorf("couldn't apply settings: %s", err))
+		}
+	}
+	if action.Location != "" {
+		if err := cl.MoveTorrent(ids, action.Location, true); err != nil {
+			return fail(fmt.Errorf("couldn't move: %s", err))
+		}
+	}
+	if action.Start && !started {
+		if err := cl.StartTorrent(ids); err != nil {
+			return nil, err
+		}
+	}
+	if *action != (MetadataAction{}) || started {
+		// The action has been applied; the torrent keeps running if it
+		// asked for that.
+		return cl.metadataInfo(ctx, id, opts.Fields)
+	}
+	return info, nil
+}
+
+func (cl *Client) metadataInfo(ctx context.Context, id string, fields []string) (*TorrentInfo, error) {
+	if fields == nil {
+		fields = AllTorrentFields
+	} else {
+		fields = append([]string{"id", "hashString"}, fields...)
+	}
+	infos, _, err := cl.torrentGet(ctx, []string{id}, fields)
+	if err != nil {
+		return nil, err
+	}
+	info := lookup([]string{id}, infos)[id]
+	if info == nil {
+		return nil, ErrTorrentNotFound
+	}
+	return info, nil
+}